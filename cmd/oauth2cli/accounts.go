@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// runAccounts implements the accounts command and its subcommands
+func runAccounts(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return usageErrorf("usage: oauth2cli accounts list|switch|remove [flags] [account]")
+	}
+
+	switch sub, args := args[0], args[1:]; sub {
+	case "list":
+		return runAccountsList(args)
+	case "switch":
+		return runAccountsSwitch(args)
+	case "remove":
+		return runAccountsRemove(args)
+	default:
+		return usageErrorf("unknown accounts command %q (list, switch or remove)", sub)
+	}
+}
+
+// account is a cached account with all of its tokens
+type account struct {
+	subject string
+	email   string
+	name    string
+	updated time.Time
+	entries []*store.Entry
+}
+
+// runAccountsList implements accounts list
+func runAccountsList(args []string) error {
+	var opts options
+	fs := newFlagSet("accounts list", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	accounts, err := cachedAccounts(&opts)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		fmt.Fprintf(os.Stderr, "No cached accounts for %s\n", opts.provider())
+		return nil
+	}
+
+	active := activeAccount(&opts)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tSUBJECT\tEMAIL\tNAME\tTOKENS")
+	for _, a := range accounts {
+		marker := ""
+		if a.subject != "" && a.subject == active {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", marker, dash(a.subject), dash(a.email), dash(a.name), len(a.entries))
+	}
+	return w.Flush()
+}
+
+// runAccountsSwitch implements accounts switch
+func runAccountsSwitch(args []string) error {
+	var opts options
+	fs := newFlagSet("accounts switch", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	a, err := findAccount(&opts, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := setActiveAccount(&opts, a.subject); err != nil {
+		return err
+	}
+
+	fmt.Printf("Switched to %s\n", accountName(a.subject, a.email))
+	return nil
+}
+
+// runAccountsRemove implements accounts remove
+func runAccountsRemove(args []string) error {
+	var opts options
+	fs := newFlagSet("accounts remove", &opts)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	a, err := findAccount(&opts, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	tokens, err := opts.store()
+	if err != nil {
+		return err
+	}
+	for _, e := range a.entries {
+		if err := tokens.Delete(e.Key); err != nil {
+			return fmt.Errorf("failed to update token cache: %w", err)
+		}
+	}
+
+	// Forget the account if it was the active one
+	if a.subject != "" && activeAccount(&opts) == a.subject {
+		if err := setActiveAccount(&opts, ""); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Removed %s\n", accountName(a.subject, a.email))
+	return nil
+}
+
+// cachedAccounts groups the cached tokens of the current provider and
+// client by account
+func cachedAccounts(opts *options) ([]*account, error) {
+	tokens, err := opts.store()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := accountEntries(opts, tokens, false)
+	if err != nil {
+		return nil, err
+	}
+
+	bySubject := make(map[string]*account)
+	for _, e := range entries {
+		a, ok := bySubject[e.Key.Account]
+		if !ok {
+			a = &account{subject: e.Key.Account}
+			bySubject[e.Key.Account] = a
+		}
+		// Take the display details from the most recent token
+		if len(a.entries) == 0 || e.UpdatedAt.After(a.updated) {
+			a.email, a.name, a.updated = e.Email, e.Name, e.UpdatedAt
+		}
+		a.entries = append(a.entries, e)
+	}
+
+	accounts := make([]*account, 0, len(bySubject))
+	for _, a := range bySubject {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].subject < accounts[j].subject
+	})
+	return accounts, nil
+}
+
+// findAccount returns the cached account given by subject or email
+func findAccount(opts *options, name string) (*account, error) {
+	if name == "" {
+		name = opts.account
+	}
+	if name == "" {
+		return nil, usageErrorf("an account is required, by subject or email")
+	}
+
+	accounts, err := cachedAccounts(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if a.subject == "" {
+			continue
+		}
+		for _, e := range a.entries {
+			if matchesAccount(e, name) {
+				return a, nil
+			}
+		}
+	}
+	return nil, usageErrorf("no cached account %q for %s; log in with --account first", name, opts.provider())
+}
+
+// accountName returns a readable name for an account
+func accountName(subject, email string) string {
+	if email != "" {
+		return fmt.Sprintf("%s (%s)", email, subject)
+	}
+	return subject
+}
+
+// dash replaces an empty table cell
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// cachedEntry returns the cached token of the selected account, or nil
+func cachedEntry(opts *options) (*store.Entry, error) {
+	tokens, err := opts.store()
+	if err != nil {
+		return nil, err
+	}
+	return selectEntry(opts, tokens)
+}
+
+// selectEntry picks the cached token for the current settings. The account
+// is the one given with --account, else the active one, else the only one.
+func selectEntry(opts *options, tokens store.TokenStore) (*store.Entry, error) {
+	entries, err := accountEntries(opts, tokens, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.account != "" {
+		for _, e := range entries {
+			if matchesAccount(e, opts.account) {
+				return e, nil
+			}
+		}
+		return nil, nil
+	}
+
+	if active := activeAccount(opts); active != "" {
+		for _, e := range entries {
+			if e.Key.Account == active {
+				return e, nil
+			}
+		}
+		return nil, nil
+	}
+
+	switch len(entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, usageErrorf("%d accounts are cached; choose one with --account or 'oauth2cli accounts switch'", len(entries))
+	}
+}
+
+// accountEntries returns the cached tokens of every account of the current
+// provider and client. If sameScopes is set, only tokens for the current
+// scopes are returned.
+func accountEntries(opts *options, tokens store.TokenStore, sameScopes bool) ([]*store.Entry, error) {
+	list, err := tokens.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token cache: %w", err)
+	}
+
+	want := opts.cacheKey("")
+	var entries []*store.Entry
+	for _, e := range list {
+		if e.Key.Provider != want.Provider || e.Key.ClientID != want.ClientID {
+			continue
+		}
+		if sameScopes && strings.Join(e.Key.Scopes, " ") != strings.Join(want.Scopes, " ") {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// matchesAccount reports whether an entry belongs to the account given by
+// subject or email
+func matchesAccount(e *store.Entry, account string) bool {
+	return e.Key.Account == account ||
+		e.Subject == account ||
+		(e.Email != "" && strings.EqualFold(e.Email, account))
+}
+
+// saveToken stores a token in the cache under the account it belongs to.
+// Identity fields missing from a refreshed token are carried over from the
+// previous entry.
+func saveToken(opts *options, token *auth.TokenResponse, claims *auth.IDTokenClaims, previous *store.Entry) (*store.Entry, error) {
+	tokens, err := opts.store()
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts are keyed by subject, which unlike the email never changes
+	account := opts.account
+	switch {
+	case claims != nil && claims.Subject != "":
+		account = claims.Subject
+	case previous != nil:
+		account = previous.Key.Account
+	}
+
+	entry := store.NewEntry(opts.cacheKey(account), token, claims)
+	if previous != nil {
+		entry.CreatedAt = previous.CreatedAt
+		if claims == nil {
+			entry.Issuer = previous.Issuer
+			entry.Subject = previous.Subject
+			entry.SessionID = previous.SessionID
+			entry.Email = previous.Email
+			entry.Name = previous.Name
+			if token.IDToken == "" {
+				token.IDToken = previous.Token.IDToken
+			}
+		}
+	}
+
+	if err := tokens.Put(entry); err != nil {
+		return nil, fmt.Errorf("failed to write token cache: %w", err)
+	}
+
+	recordToken(entry)
+
+	// Replace an entry cached before the account was known
+	if previous != nil && previous.Key.String() != entry.Key.String() {
+		if err := tokens.Delete(previous.Key); err != nil {
+			return nil, fmt.Errorf("failed to update token cache: %w", err)
+		}
+	}
+
+	// A fresh login becomes the active account, unless it was a one-off for --account
+	if previous == nil && opts.account == "" && account != "" {
+		if err := setActiveAccount(opts, account); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// deleteToken removes the token of the selected account from the cache
+func deleteToken(opts *options) error {
+	tokens, err := opts.store()
+	if err != nil {
+		return err
+	}
+
+	entry, err := selectEntry(opts, tokens)
+	if err != nil || entry == nil {
+		return err
+	}
+	if err := tokens.Delete(entry.Key); err != nil {
+		return fmt.Errorf("failed to update token cache: %w", err)
+	}
+	return nil
+}
+
+// activeAccount returns the subject of the active account of the current
+// provider and client, or "" if none was chosen
+func activeAccount(opts *options) string {
+	if opts.noCache {
+		return ""
+	}
+
+	state, err := config.LoadState(opts.statePath())
+	if err != nil {
+		logger.Warn("Ignoring the active account: %v", err)
+		return ""
+	}
+	return state.ActiveAccounts[config.AccountScope(opts.provider(), opts.clientID)]
+}
+
+// setActiveAccount remembers the active account of the current provider
+// and client; an empty account forgets it
+func setActiveAccount(opts *options, account string) error {
+	if opts.noCache {
+		return nil
+	}
+
+	path := opts.statePath()
+	state, err := config.LoadState(path)
+	if err != nil {
+		return err
+	}
+
+	scope := config.AccountScope(opts.provider(), opts.clientID)
+	if account == "" {
+		delete(state.ActiveAccounts, scope)
+	} else {
+		state.ActiveAccounts[scope] = account
+	}
+	return state.Save(path)
+}
+
+// ensureToken returns a valid token, using the cache, a refresh or an
+// interactive login, in that order. opts must have been applied.
+func ensureToken(ctx context.Context, opts *loginOptions) (*store.Entry, error) {
+	entry, err := freshToken(ctx, &opts.options)
+	if err != nil || entry != nil {
+		return entry, err
+	}
+
+	result, err := login(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return saveToken(&opts.options, result.token, result.claims, nil)
+}
+
+// freshToken returns a valid token from the cache, refreshing it if needed,
+// or nil if only a login would get one
+func freshToken(ctx context.Context, opts *options) (*store.Entry, error) {
+	entry, err := cachedEntry(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil && entry.Valid() {
+		logger.Info("Using cached token (expires %s)", entry.ExpiresAt.Format("15:04:05"))
+		return entry, nil
+	}
+
+	// Try a refresh before bothering the user
+	if entry != nil && entry.Token.RefreshToken != "" {
+		refreshed, err := refreshEntry(ctx, opts, entry)
+		if err == nil {
+			return refreshed, nil
+		}
+		logger.Warn("Refreshing the cached token failed: %v", err)
+	}
+	return nil, nil
+}
+
+// expireToken forgets the cached access token of the selected account but
+// keeps the refresh token, so the next use gets a new access token. A token
+// that a parallel refresh replaced in the meantime is kept.
+func expireToken(opts *options) error {
+	tokens, err := opts.store()
+	if err != nil {
+		return err
+	}
+	selected, err := selectEntry(opts, tokens)
+	if err != nil || selected == nil {
+		return err
+	}
+
+	rejected := selected.Token.AccessToken
+	err = tokens.Update(selected.Key, func(entry *store.Entry) (bool, error) {
+		if entry.Token == nil || entry.Token.AccessToken != rejected {
+			return false, nil
+		}
+		entry.Token.AccessToken = ""
+		entry.ExpiresAt = time.Now()
+		entry.UpdatedAt = time.Now()
+		return true, nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// refreshEntry refreshes a cached token and stores the result
+func refreshEntry(ctx context.Context, opts *options, entry *store.Entry) (*store.Entry, error) {
+	client, err := opts.client()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	token, err := client.RefreshToken(ctx, entry.Token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// A refreshed ID token must pass the same checks as the one of a login
+	var claims *auth.IDTokenClaims
+	if token.IDToken != "" {
+		if claims, err = validateIDToken(ctx, client, token.IDToken, opts.clientID); err != nil {
+			return nil, err
+		}
+	}
+
+	return saveToken(opts, token, claims, entry)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// newTestOptions parses args into options backed by a temporary config dir
+func newTestOptions(t *testing.T, config string, args ...string) *options {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("GOOGLE_CLIENT_ID", "")
+	t.Setenv("OAUTH2_ISSUER", "")
+	t.Setenv("OAUTH2_PROFILE", "")
+	t.Setenv("OAUTH2_ACCOUNT", "")
+	t.Setenv("REDIRECT_URI", "")
+
+	configPath := filepath.Join(dir, "config.json")
+	if config != "" {
+		if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var opts options
+	fs := newFlagSet("test", &opts)
+	args = append([]string{"--config", configPath, "--token-cache", filepath.Join(dir, "tokens.json")}, args...)
+	if err := parseFlags(fs, args); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if err := opts.apply(); err != nil {
+		t.Fatalf("Failed to apply options: %v", err)
+	}
+	return &opts
+}
+
+func TestApplyProfile(t *testing.T) {
+	config := `{
+		"default_profile": "work",
+		"profiles": {
+			"work": {
+				"issuer": "https://idp.example.com",
+				"auth_url": "https://idp.example.com/authorize",
+				"token_url": "https://idp.example.com/token",
+				"client_id": "work-client",
+				"scopes": ["openid", "email"],
+				"redirect_uri": "http://localhost:9000/cb"
+			},
+			"personal": {"provider": "google", "client_id": "personal-client"}
+		}
+	}`
+
+	opts := newTestOptions(t, config)
+	if opts.clientID != "work-client" || opts.endpoint.Issuer != "https://idp.example.com" {
+		t.Errorf("Default profile not applied: %+v", opts)
+	}
+	if opts.port != 9000 || opts.callbackPath != "/cb" {
+		t.Errorf("Redirect settings not applied: %d %s", opts.port, opts.callbackPath)
+	}
+	// Endpoints the profile doesn't set must not fall back to Google
+	if opts.endpoint.RevocationURL != "" {
+		t.Errorf("Unexpected revocation URL: %s", opts.endpoint.RevocationURL)
+	}
+
+	// Flags take precedence over the profile
+	opts = newTestOptions(t, config, "--profile", "personal", "--client-id", "flag-client")
+	if opts.clientID != "flag-client" || opts.endpoint.Issuer != auth.GoogleIssuer {
+		t.Errorf("Flags did not override the profile: %+v", opts)
+	}
+}
+
+func TestSelectAccount(t *testing.T) {
+	opts := newTestOptions(t, "", "--client-id", "client")
+
+	login := func(sub, email string) {
+		claims := &auth.IDTokenClaims{Subject: sub, Email: email}
+		token := &auth.TokenResponse{AccessToken: "at-" + sub, ExpiresIn: 3600}
+		if _, err := saveToken(opts, token, claims, nil); err != nil {
+			t.Fatalf("Failed to save token: %v", err)
+		}
+	}
+
+	// The last login is the active account
+	login("1", "alice@example.com")
+	login("2", "admin@example.com")
+	entry, err := cachedEntry(opts)
+	if err != nil || entry == nil || entry.Key.Account != "2" {
+		t.Fatalf("Unexpected active entry: %+v, %v", entry, err)
+	}
+
+	// --account selects by email
+	opts.account = "ALICE@example.com"
+	if entry, err = cachedEntry(opts); err != nil || entry == nil || entry.Key.Account != "1" {
+		t.Errorf("Unexpected entry for --account: %+v, %v", entry, err)
+	}
+
+	// Switching changes the active account
+	opts.account = ""
+	if err := setActiveAccount(opts, "1"); err != nil {
+		t.Fatalf("Failed to switch account: %v", err)
+	}
+	if entry, err = cachedEntry(opts); err != nil || entry == nil || entry.Key.Account != "1" {
+		t.Errorf("Unexpected entry after switch: %+v, %v", entry, err)
+	}
+
+	// Without an active account, several accounts are ambiguous
+	if err := setActiveAccount(opts, ""); err != nil {
+		t.Fatalf("Failed to clear the active account: %v", err)
+	}
+	if _, err := cachedEntry(opts); exitCode(err) != exitUsage {
+		t.Errorf("Expected a usage error for ambiguous accounts, got %v", err)
+	}
+}
+
+func TestTokenFlag(t *testing.T) {
+	var buf bytes.Buffer
+	logger.DefaultLogger.SetWriter(&buf)
+	defer logger.DefaultLogger.SetWriter(os.Stdout)
+
+	parse := func(args ...string) string {
+		t.Helper()
+		fs := newFlagSet("test", &options{})
+		token := tokenFlag(fs, "token", "OAUTH2_TOKEN", "Token")
+		if err := parseFlags(fs, args); err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		value, err := token.Value()
+		if err != nil {
+			t.Fatalf("Failed to resolve the token: %v", err)
+		}
+		return value
+	}
+
+	t.Setenv("OAUTH2_TOKEN", "env-token")
+	if got := parse(); got != "env-token" || buf.Len() != 0 {
+		t.Errorf("Token from the environment is incorrect: got %q, log %q", got, buf.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := parse("--token", "file:"+path); got != "file-token" || buf.Len() != 0 {
+		t.Errorf("Token from a file is incorrect: got %q, log %q", got, buf.String())
+	}
+
+	// A literal token on the command line works, with a warning
+	if got := parse("--token", "argv-token"); got != "argv-token" || !strings.Contains(buf.String(), "--token puts the token in the process list") {
+		t.Errorf("Literal token is incorrect: got %q, log %q", got, buf.String())
+	}
+}
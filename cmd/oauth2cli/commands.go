@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// runRefresh implements the refresh command
+func runRefresh(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("refresh", &opts)
+	refreshFlag := tokenFlag(fs, "refresh-token", "OAUTH2_REFRESH_TOKEN", "Refresh token to exchange; defaults to the cached one")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	refreshToken, err := refreshFlag.Value()
+	if err != nil {
+		return err
+	}
+	entry, err := cachedEntry(&opts)
+	if err != nil {
+		return err
+	}
+
+	// An explicit refresh token replaces the cached one
+	if refreshToken != "" {
+		if entry == nil {
+			entry = &store.Entry{}
+		}
+		entry.Token = &auth.TokenResponse{RefreshToken: refreshToken}
+	}
+	if entry == nil || entry.Token.RefreshToken == "" {
+		return usageErrorf("a refresh token is required (--refresh-token, OAUTH2_REFRESH_TOKEN or a cached login)")
+	}
+
+	refreshed, err := refreshEntry(ctx, &opts, entry)
+	if err != nil {
+		return err
+	}
+
+	// A refresh may return a new ID token as well
+	var claims *auth.IDTokenClaims
+	if refreshed.Token.IDToken != "" {
+		if claims, err = auth.ParseIDToken(refreshed.Token.IDToken); err != nil {
+			return err
+		}
+	}
+
+	fmt.Print(auth.FormatTokenInfo(refreshed.Token, claims))
+	return nil
+}
+
+// runRevoke implements the revoke command
+func runRevoke(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("revoke", &opts)
+	tokenArg := tokenFlag(fs, "token", "OAUTH2_TOKEN", "Access or refresh token to revoke; defaults to the cached one")
+	hint := fs.String("token-type-hint", "", "access_token or refresh_token")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	token, err := tokenArg.Value()
+	if err != nil {
+		return err
+	}
+
+	// Without an explicit token, revoke the cached login and forget it.
+	// Revoking the refresh token also invalidates the access tokens issued with it.
+	fromCache := token == ""
+	if fromCache {
+		entry, err := cachedEntry(&opts)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return usageErrorf("a token is required (--token, OAUTH2_TOKEN or a cached login)")
+		}
+		token, *hint = entry.Token.AccessToken, "access_token"
+		if entry.Token.RefreshToken != "" {
+			token, *hint = entry.Token.RefreshToken, "refresh_token"
+		}
+	}
+
+	client, err := opts.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	if err := client.RevokeToken(ctx, token, *hint); err != nil {
+		return err
+	}
+	if fromCache {
+		if err := deleteToken(&opts); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Token revoked")
+	return nil
+}
+
+// runIntrospect implements the introspect command
+func runIntrospect(ctx context.Context, args []string) error {
+	var opts loginOptions
+	fs := newLoginFlagSet("introspect", &opts)
+	tokenArg := tokenFlag(fs, "token", "OAUTH2_TOKEN", "Token to introspect; defaults to the cached access token")
+	hint := fs.String("token-type-hint", "", "access_token or refresh_token")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	token, err := tokenArg.Value()
+	if err != nil {
+		return err
+	}
+	if err := accessToken(ctx, &opts, &token); err != nil {
+		return err
+	}
+
+	client, err := opts.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	resp, err := client.IntrospectToken(ctx, token, *hint)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(resp.Raw)
+}
+
+// runUserInfo implements the userinfo command
+func runUserInfo(ctx context.Context, args []string) error {
+	var opts loginOptions
+	var out outputOptions
+	fs := newLoginFlagSet("userinfo", &opts)
+	out.register(fs, outputJSON)
+	tokenArg := tokenFlag(fs, "token", "OAUTH2_TOKEN", "Access token to call the userinfo endpoint with; defaults to the cached one")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := out.apply(); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	token, err := tokenArg.Value()
+	if err != nil {
+		return err
+	}
+	if err := accessToken(ctx, &opts, &token); err != nil {
+		return err
+	}
+
+	client, err := opts.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	claims, err := client.GetUserInfo(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	return out.print(claims, func() error {
+		return printClaims(claims)
+	})
+}
+
+// runDecode implements the decode command
+func runDecode(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("decode", &opts)
+	tokenArg := tokenFlag(fs, "token", "OAUTH2_TOKEN", "JWT to decode; '-' or a positional argument also work")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	token, err := tokenArg.Value()
+	if err != nil {
+		return err
+	}
+
+	// Take the token from the argument or stdin if it wasn't given as a flag
+	value := token
+	if fs.NArg() > 0 {
+		value = fs.Arg(0)
+	}
+	if value == "-" || value == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return usageErrorf("a token is required (argument, --token, OAUTH2_TOKEN or stdin)")
+		}
+		value = strings.TrimSpace(line)
+	}
+
+	header, payload, err := auth.DecodeJWT(value)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Header:")
+	if err := printIndented(header); err != nil {
+		return err
+	}
+	fmt.Println("Payload:")
+	if err := printIndented(payload); err != nil {
+		return err
+	}
+	fmt.Println("The signature was not verified.")
+	return nil
+}
+
+// accessToken fills in *token with a valid access token from the cache,
+// a refresh or a login if it wasn't given on the command line. opts must
+// have been applied.
+func accessToken(ctx context.Context, opts *loginOptions, token *string) error {
+	if *token != "" {
+		return nil
+	}
+
+	entry, err := ensureToken(ctx, opts)
+	if err != nil {
+		return err
+	}
+	*token = entry.Token.AccessToken
+	return nil
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// printClaims prints claims as sorted "name: value" lines
+func printClaims(claims map[string]interface{}) error {
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := claims[name]
+		switch v := value.(type) {
+		case float64:
+			// Timestamps would otherwise be printed in exponent form
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case map[string]interface{}, []interface{}:
+			out, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to encode output: %w", err)
+			}
+			value = string(out)
+		}
+		fmt.Printf("%s: %v\n", name, value)
+	}
+	return nil
+}
+
+// printIndented prints raw JSON indented
+func printIndented(raw []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return fmt.Errorf("token part is not valid JSON: %w", err)
+	}
+	fmt.Println(buf.String())
+	return nil
+}
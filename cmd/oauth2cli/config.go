@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/korjavin/oauth2example/internal/config"
+)
+
+// runConfig implements the config command, which shows the effective
+// settings and where each one came from
+func runConfig(ctx context.Context, args []string) error {
+	var opts loginOptions
+	fs := newLoginFlagSet("config", &opts)
+	changed := fs.Bool("changed", false, "Only show settings that aren't at their default")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(opts.configPath); err != nil {
+		fmt.Printf("Config file: %s (not found)\n", opts.configPath)
+	} else {
+		fmt.Printf("Config file: %s\n", opts.configPath)
+	}
+	if opts.profile != "" {
+		fmt.Printf("Profile:     %s\n", opts.profile)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE\tORIGIN")
+	for _, v := range opts.values.All() {
+		if *changed && v.Source == config.SourceDefault {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Setting.Name(), dash(v.Display()), v.Source, dash(v.Origin))
+	}
+	return w.Flush()
+}
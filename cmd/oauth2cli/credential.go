@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/store"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// dockerHelperName is the name Docker runs credential helpers by. When the
+// binary is installed under it, it acts as the Docker credential helper.
+const dockerHelperName = "docker-credential-oauth2cli"
+
+// errDockerNotFound is the message Docker expects when a helper has no
+// credentials for a server
+const errDockerNotFound = "credentials not found in native keychain"
+
+// credentialOptions holds the flags of the credential helpers
+type credentialOptions struct {
+	loginOptions
+
+	hosts    []string
+	username string
+	noLogin  bool
+}
+
+// apply resolves the settings of the credential helpers
+func (o *credentialOptions) apply() error {
+	if err := o.loginOptions.apply(); err != nil {
+		return err
+	}
+	// Git and docker write the request to stdin
+	if err := o.checkStdin("credential-helper"); err != nil {
+		return err
+	}
+	o.hosts = o.values.List("credential_hosts")
+	o.username = o.values.String("credential_username")
+	if len(o.hosts) == 0 {
+		logger.Warn("No hosts are set with --hosts or OAUTH2_CREDENTIAL_HOSTS; no credentials are given out")
+	}
+	return nil
+}
+
+// allowed reports whether the helpers answer for host. Without a list of
+// hosts they answer for none, so the token never leaks to a server it
+// wasn't meant for.
+func (o *credentialOptions) allowed(host string) bool {
+	for _, h := range o.hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// token returns a valid token, logging in only if that is allowed. It
+// returns nil if there is no token and no login is allowed.
+func (o *credentialOptions) token(ctx context.Context) (*store.Entry, error) {
+	if o.noLogin {
+		return freshToken(ctx, &o.options)
+	}
+	return ensureToken(ctx, &o.loginOptions)
+}
+
+// runCredentialHelper implements the credential-helper command, which lets
+// git and docker get their credentials from the token cache
+func runCredentialHelper(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("a helper is required: oauth2cli credential-helper git|docker [flags] <action>")
+	}
+	mode, args := args[0], args[1:]
+	if mode != "git" && mode != "docker" {
+		return usageErrorf("unknown credential helper %q (git or docker)", mode)
+	}
+
+	var opts credentialOptions
+	fs := newLoginFlagSet("credential-helper "+mode, &opts.loginOptions)
+	opts.registerGroup(fs, config.GroupCredential)
+	fs.BoolVar(&opts.noLogin, "no-login", false, "Only use the cached token or a refresh, never log in")
+
+	// Git and docker show our stderr, so keep it to warnings unless asked
+	if err := fs.Set("quiet", "true"); err != nil {
+		return err
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("an action is required: oauth2cli credential-helper %s [flags] <action>", mode)
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	// Git asks not to prompt in scripts, and a login is a prompt
+	if mode == "git" && !utils.GetEnvBool("GIT_TERMINAL_PROMPT", true) {
+		opts.noLogin = true
+	}
+
+	if mode == "git" {
+		return gitCredential(ctx, &opts, fs.Arg(0), os.Stdin, os.Stdout)
+	}
+	return dockerCredential(ctx, &opts, fs.Arg(0), os.Stdin, os.Stdout)
+}
+
+// gitCredential implements the git credential helper protocol: git writes
+// key=value lines describing the request to stdin, and for get reads the
+// credential back from stdout. Unknown actions are ignored, as git expects.
+func gitCredential(ctx context.Context, opts *credentialOptions, action string, in io.Reader, out io.Writer) error {
+	attrs, capabilities, err := readGitAttributes(in)
+	if err != nil {
+		return err
+	}
+
+	host := attrs["host"]
+	if !opts.allowed(host) {
+		logger.Debug("Not answering for host %s", host)
+		return nil
+	}
+
+	switch action {
+	case "get":
+		if attrs["protocol"] != "https" {
+			logger.Warn("Not sending a token over %s to %s", attrs["protocol"], host)
+			return nil
+		}
+		entry, err := opts.token(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		// Git 2.46 and later can send the token as a bearer token
+		if capabilities["authtype"] {
+			fmt.Fprintln(out, "capability[]=authtype")
+			fmt.Fprintln(out, "authtype=Bearer")
+			fmt.Fprintf(out, "credential=%s\n", entry.Token.AccessToken)
+		} else {
+			fmt.Fprintf(out, "username=%s\n", opts.username)
+			fmt.Fprintf(out, "password=%s\n", entry.Token.AccessToken)
+		}
+		// Tells git not to store the token in other helpers past its expiry
+		if !entry.ExpiresAt.IsZero() {
+			fmt.Fprintf(out, "password_expiry_utc=%d\n", entry.ExpiresAt.Unix())
+		}
+		return nil
+
+	case "erase":
+		// The server rejected the token, so the next get must not return it
+		logger.Info("Forgetting the access token rejected by %s", host)
+		return expireToken(&opts.options)
+
+	default:
+		// store needs nothing: the token already is in the cache
+		return nil
+	}
+}
+
+// readGitAttributes reads the key=value lines git sends, up to a blank line.
+// capability[] attributes are returned separately, since they repeat.
+func readGitAttributes(in io.Reader) (map[string]string, map[string]bool, error) {
+	attrs := make(map[string]string)
+	capabilities := make(map[string]bool)
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid credential attribute %q", line)
+		}
+		if key == "capability[]" {
+			capabilities[value] = true
+			continue
+		}
+		attrs[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read credential request: %w", err)
+	}
+	return attrs, capabilities, nil
+}
+
+// dockerCredentials is the credential a Docker helper returns for get
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerCredential implements the Docker credential helper protocol: get
+// and erase read a server URL from stdin, store reads a JSON credential and
+// list prints the servers the helper has credentials for. Errors are
+// reported on stdout, which is where Docker reads them from.
+func dockerCredential(ctx context.Context, opts *credentialOptions, action string, in io.Reader, out io.Writer) error {
+	switch action {
+	case "get":
+		serverURL, err := readDockerServerURL(in)
+		if err != nil {
+			return dockerError(out, err.Error())
+		}
+		if !opts.allowed(dockerHost(serverURL)) {
+			return dockerError(out, errDockerNotFound)
+		}
+
+		entry, err := opts.token(ctx)
+		if err != nil {
+			return dockerError(out, err.Error())
+		}
+		if entry == nil {
+			return dockerError(out, errDockerNotFound)
+		}
+		return json.NewEncoder(out).Encode(dockerCredentials{
+			ServerURL: serverURL,
+			Username:  opts.username,
+			Secret:    entry.Token.AccessToken,
+		})
+
+	case "erase":
+		serverURL, err := readDockerServerURL(in)
+		if err != nil {
+			return dockerError(out, err.Error())
+		}
+		if !opts.allowed(dockerHost(serverURL)) {
+			return nil
+		}
+		if err := expireToken(&opts.options); err != nil {
+			return dockerError(out, err.Error())
+		}
+		return nil
+
+	case "store":
+		// docker login hands over a password, but the tokens come from the
+		// OAuth2 flow, so there is nothing to keep
+		if _, err := io.Copy(io.Discard, in); err != nil {
+			return dockerError(out, err.Error())
+		}
+		logger.Warn("Ignoring the credentials from docker login; tokens come from 'oauth2cli login'")
+		return nil
+
+	case "list":
+		servers := make(map[string]string)
+		for _, host := range opts.hosts {
+			servers[host] = opts.username
+		}
+		return json.NewEncoder(out).Encode(servers)
+
+	default:
+		return dockerError(out, fmt.Sprintf("unknown docker credential helper action %q (get, store, erase or list)", action))
+	}
+}
+
+// readDockerServerURL reads the server URL Docker writes to stdin
+func readDockerServerURL(in io.Reader) (string, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", fmt.Errorf("failed to read server URL: %w", err)
+	}
+	serverURL := strings.TrimSpace(string(data))
+	if serverURL == "" {
+		return "", errors.New("no server URL")
+	}
+	return serverURL, nil
+}
+
+// dockerHost returns the host of a registry, which Docker gives either as
+// a bare host or as a URL
+func dockerHost(serverURL string) string {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return serverURL
+	}
+	return u.Host
+}
+
+// dockerError reports an error on stdout, where Docker reads it from, and
+// makes the helper exit with 1
+func dockerError(out io.Writer, message string) error {
+	fmt.Fprintln(out, message)
+	return &exitError{code: exitFailure}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// newTestCredentialOptions returns credential helper options with a cached token
+func newTestCredentialOptions(t *testing.T) *credentialOptions {
+	t.Helper()
+	opts := &credentialOptions{
+		loginOptions: loginOptions{options: *newTestOptions(t, "", "--client-id", "client")},
+		hosts:        []string{"git.example.com", "registry.example.com:5000"},
+		username:     "oauth2",
+		noLogin:      true,
+	}
+
+	claims := &auth.IDTokenClaims{Subject: "1"}
+	token := &auth.TokenResponse{AccessToken: "at-1", ExpiresIn: 3600}
+	if _, err := saveToken(&opts.options, token, claims, nil); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+	return opts
+}
+
+func TestGitCredential(t *testing.T) {
+	opts := newTestCredentialOptions(t)
+	ctx := context.Background()
+
+	get := func(request string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := gitCredential(ctx, opts, "get", strings.NewReader(request), &out); err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		return out.String()
+	}
+
+	if got := get("protocol=https\nhost=git.example.com\n\n"); !strings.HasPrefix(got, "username=oauth2\npassword=at-1\npassword_expiry_utc=") {
+		t.Errorf("Basic credential is incorrect: got %q", got)
+	}
+	if got := get("capability[]=authtype\nprotocol=https\nhost=git.example.com\n"); !strings.HasPrefix(got, "capability[]=authtype\nauthtype=Bearer\ncredential=at-1\n") {
+		t.Errorf("Bearer credential is incorrect: got %q", got)
+	}
+
+	// Other hosts and plain HTTP get nothing
+	if got := get("protocol=https\nhost=github.com\n"); got != "" {
+		t.Errorf("Answered for another host: %q", got)
+	}
+	if got := get("protocol=http\nhost=git.example.com\n"); got != "" {
+		t.Errorf("Answered over plain HTTP: %q", got)
+	}
+
+	// After a rejection the token is not offered again
+	if err := gitCredential(ctx, opts, "erase", strings.NewReader("protocol=https\nhost=git.example.com\n"), &bytes.Buffer{}); err != nil {
+		t.Fatalf("erase failed: %v", err)
+	}
+	if got := get("protocol=https\nhost=git.example.com\n"); got != "" {
+		t.Errorf("Answered with an erased token: %q", got)
+	}
+}
+
+func TestDockerCredential(t *testing.T) {
+	opts := newTestCredentialOptions(t)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := dockerCredential(ctx, opts, "get", strings.NewReader("https://registry.example.com:5000\n"), &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	var creds dockerCredentials
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		t.Fatalf("Invalid get output %q: %v", out.String(), err)
+	}
+	if creds.Username != "oauth2" || creds.Secret != "at-1" || creds.ServerURL != "https://registry.example.com:5000" {
+		t.Errorf("Credentials are incorrect: %+v", creds)
+	}
+
+	// Docker expects this exact message for servers the helper doesn't know
+	out.Reset()
+	err := dockerCredential(ctx, opts, "get", strings.NewReader("docker.io"), &out)
+	if exitCode(err) != exitFailure || strings.TrimSpace(out.String()) != errDockerNotFound {
+		t.Errorf("Unexpected result for an unknown server: %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	if err := dockerCredential(ctx, opts, "list", strings.NewReader(""), &out); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"git.example.com":"oauth2"`) {
+		t.Errorf("List is incorrect: %s", out.String())
+	}
+
+	// Docker reads errors from stdout, including the ones for actions it
+	// added after this helper was written
+	out.Reset()
+	err = dockerCredential(ctx, opts, "version", strings.NewReader(""), &out)
+	if exitCode(err) != exitFailure || !strings.Contains(out.String(), `unknown docker credential helper action "version"`) {
+		t.Errorf("Unexpected result for an unknown action: %q, %v", out.String(), err)
+	}
+}
+
+func TestCredentialWithoutHosts(t *testing.T) {
+	opts := newTestCredentialOptions(t)
+	opts.hosts = nil
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := gitCredential(ctx, opts, "get", strings.NewReader("protocol=https\nhost=git.example.com\n"), &out); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if out.String() != "" {
+		t.Errorf("Answered without a list of hosts: %q", out.String())
+	}
+
+	out.Reset()
+	err := dockerCredential(ctx, opts, "get", strings.NewReader("https://registry.example.com:5000\n"), &out)
+	if exitCode(err) != exitFailure || strings.TrimSpace(out.String()) != errDockerNotFound {
+		t.Errorf("Unexpected result without a list of hosts: %q, %v", out.String(), err)
+	}
+}
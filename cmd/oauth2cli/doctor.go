@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// Severities of doctor checks
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// doctorReport collects the results of the doctor checks
+type doctorReport struct {
+	failures int
+}
+
+// report prints the result of a check
+func (r *doctorReport) report(severity, format string, args ...interface{}) {
+	if severity == checkFail {
+		r.failures++
+	}
+	fmt.Printf("[%-4s] %s\n", severity, fmt.Sprintf(format, args...))
+}
+
+// runDoctor implements the doctor command
+func runDoctor(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("doctor", &opts)
+	rekey := fs.Bool("rekey", false, "Re-encrypt the token cache with the current key")
+	generateKey := fs.String("generate-key", "", "Write a new random cache key to this file and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	if *generateKey != "" {
+		if err := store.GenerateKeyFile(*generateKey); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote a new cache key to %s\n", *generateKey)
+		fmt.Printf("Use it with --cache-key-file %s or OAUTH2_CACHE_KEY_FILE\n", *generateKey)
+		return nil
+	}
+
+	var r doctorReport
+
+	if opts.profile != "" {
+		r.report(checkOK, "Using profile %q from %s", opts.profile, opts.configPath)
+	}
+	if opts.clientID == "" {
+		r.report(checkWarn, "No client ID configured (--client-id or GOOGLE_CLIENT_ID)")
+	} else {
+		r.report(checkOK, "Client ID configured")
+	}
+	checkSecrets(&r, &opts)
+
+	if err := checkTokenCache(&r, &opts, *rekey); err != nil {
+		return err
+	}
+
+	if r.failures > 0 {
+		return fmt.Errorf("%d check(s) failed", r.failures)
+	}
+	return nil
+}
+
+// checkSecrets warns about secrets given literally in the environment or
+// the config file, where other processes and backups can read them
+func checkSecrets(r *doctorReport, opts *options) {
+	for _, v := range opts.values.All() {
+		if !v.Setting.Secret || v.Setting.Key == "" || v.Raw == "" {
+			continue
+		}
+		if config.IsSecretRef(v.Raw) {
+			r.report(checkOK, "%s is read from %s", v.Setting.Name(), v.Display())
+			continue
+		}
+		if v.Source == config.SourceFlag {
+			continue
+		}
+		r.report(checkWarn, "%s is set literally in %s; use a file:, cmd: or stdin: reference instead", v.Setting.Name(), v.Origin)
+	}
+}
+
+// checkTokenCache reports how the token cache is stored, and rewrites it
+// with the current key if rekey is set
+func checkTokenCache(r *doctorReport, opts *options, rekey bool) error {
+	if opts.noCache {
+		r.report(checkOK, "Token cache disabled")
+		if rekey {
+			return usageErrorf("--rekey needs the token cache")
+		}
+		return nil
+	}
+
+	tokens, err := opts.fileStore()
+	if err != nil {
+		return err
+	}
+
+	if rekey {
+		if !tokens.Encrypted() {
+			return usageErrorf("--rekey needs a cache key (OAUTH2_CACHE_PASSPHRASE or --cache-key-file)")
+		}
+		n, err := tokens.Rekey()
+		if err != nil {
+			return err
+		}
+		r.report(checkOK, "Re-encrypted %d token cache entries with the current key", n)
+	}
+
+	stats, err := tokens.Stats()
+	if err != nil {
+		r.report(checkFail, "%v", err)
+		return nil
+	}
+	if !stats.Exists {
+		r.report(checkOK, "Token cache %s is empty", tokens.Path())
+	} else {
+		r.report(checkOK, "Token cache %s holds %d entries", tokens.Path(), stats.Plaintext+stats.Encrypted)
+		checkPermissions(r, tokens.Path())
+	}
+
+	// Tokens in plaintext are readable by anything running as the user
+	switch {
+	case !tokens.Encrypted() && stats.Encrypted > 0:
+		r.report(checkFail, "Token cache is encrypted, but no key is configured (OAUTH2_CACHE_PASSPHRASE or --cache-key-file)")
+	case !tokens.Encrypted():
+		r.report(checkWarn, "Tokens are stored unencrypted; set OAUTH2_CACHE_PASSPHRASE or --cache-key-file to encrypt them")
+	case stats.Plaintext > 0:
+		r.report(checkWarn, "%d token cache entries are stored unencrypted; run 'oauth2cli doctor --rekey' to encrypt them", stats.Plaintext)
+	default:
+		r.report(checkOK, "Token cache encryption enabled")
+	}
+
+	// Make sure the configured key can actually read the cache
+	if tokens.Encrypted() && stats.Encrypted > 0 {
+		if _, err := tokens.List(); errors.Is(err, store.ErrWrongKey) {
+			r.report(checkFail, "The configured key can't decrypt the token cache")
+		} else if err != nil {
+			r.report(checkFail, "%v", err)
+		} else {
+			r.report(checkOK, "The configured key decrypts the token cache")
+		}
+	}
+
+	return nil
+}
+
+// checkPermissions warns if the token file is readable by other users
+func checkPermissions(r *doctorReport, path string) {
+	// Windows doesn't have Unix permission bits
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		r.report(checkFail, "Can't check token cache permissions: %v", err)
+		return
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		r.report(checkWarn, "Token cache is readable by other users (mode %o); run chmod 600 %s", perm, path)
+		return
+	}
+	r.report(checkOK, "Token cache is only readable by the user")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+	"golang.org/x/term"
+)
+
+// Default names of the environment variables exec sets for the child
+const (
+	defaultAccessTokenEnv = "ACCESS_TOKEN"
+	defaultIDTokenEnv     = "ID_TOKEN"
+)
+
+// forwardedSignals are passed on to the child of exec
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// terminalSignals are the signals a terminal sends for Ctrl-C and Ctrl-\ to
+// its whole foreground process group, the child included
+var terminalSignals = []os.Signal{os.Interrupt, syscall.SIGQUIT}
+
+// onTerminal reports whether we run on a terminal. The child then gets
+// terminalSignals from the terminal itself, and forwarding them too would
+// deliver them twice.
+var onTerminal = func() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		if term.IsTerminal(int(f.Fd())) {
+			return true
+		}
+	}
+	return false
+}
+
+// exitError makes the CLI exit with a child's exit code, without a message
+type exitError struct {
+	code int
+}
+
+// Error implements the error interface
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// runExec implements the exec command, which runs a program with a valid
+// token in its environment
+func runExec(ctx context.Context, args []string) error {
+	var opts loginOptions
+	fs := newLoginFlagSet("exec", &opts)
+	accessEnv := fs.String("access-token-env", defaultAccessTokenEnv, "Variable to export the access token in; empty to skip it")
+	idEnv := fs.String("id-token-env", defaultIDTokenEnv, "Variable to export the ID token in; empty to skip it")
+	toFile := fs.Bool("file", false, "Write the tokens to temporary files and export their paths in <VAR>_FILE instead")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oauth2cli exec [flags] -- command [args...]")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("a command to run is required: oauth2cli exec [flags] -- command [args...]")
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	entry, err := ensureToken(ctx, &opts)
+	if err != nil {
+		return err
+	}
+
+	tokens := []struct{ env, value string }{
+		{*accessEnv, entry.Token.AccessToken},
+		{*idEnv, entry.Token.IDToken},
+	}
+	env := os.Environ()
+	for _, t := range tokens {
+		if t.env == "" || t.value == "" {
+			continue
+		}
+		if !*toFile {
+			env = append(env, t.env+"="+t.value)
+			continue
+		}
+
+		// Files keep the token out of the child's environment, which its own
+		// children and crash reports would inherit
+		path, err := writeTokenFile(t.value)
+		if err != nil {
+			return err
+		}
+		defer removeTokenFile(path)
+		env = append(env, t.env+"_FILE="+path)
+	}
+
+	code, err := runChild(fs.Arg(0), fs.Args()[1:], env)
+	if err != nil {
+		return err
+	}
+	if code != exitOK {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// writeTokenFile writes a token to a new temporary file only the user can read
+func writeTokenFile(token string) (string, error) {
+	f, err := os.CreateTemp("", "oauth2cli-*.token")
+	if err != nil {
+		return "", fmt.Errorf("failed to create token file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(token); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write token file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// removeTokenFile deletes a token file once the child has exited
+func removeTokenFile(path string) {
+	if err := os.Remove(path); err != nil {
+		logger.Warn("Failed to remove token file %s: %v", path, err)
+	}
+}
+
+// runChild runs a program with our stdio, forwarding signals to it, and
+// returns its exit code. A child killed by a signal exits with 128 plus the
+// signal number, like it would in a shell. Signals the terminal already
+// sends the child are only caught, so we outlive the child.
+func runChild(name string, args []string, env []string) (int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Catch the signals before starting, so none is lost in between
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	terminal := onTerminal()
+
+	logger.Debug("Running %s", name)
+	if err := cmd.Start(); err != nil {
+		return exitFailure, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if terminal && slices.Contains(terminalSignals, sig) {
+					continue
+				}
+				// The child decides how to handle it, like it would without us
+				if err := cmd.Process.Signal(sig); err != nil {
+					logger.Debug("Failed to forward %s to %s: %v", sig, name, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return exitOK, nil
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	default:
+		return exitFailure, fmt.Errorf("failed to wait for %s: %w", name, err)
+	}
+}
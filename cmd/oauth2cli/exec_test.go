@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunChild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{"success", "exit 0", exitOK},
+		{"exit code", "exit 7", 7},
+		{"environment", `test "$ACCESS_TOKEN" = at-123`, exitOK},
+		{"killed", "kill -TERM $$", 128 + 15},
+	}
+
+	env := append(os.Environ(), "ACCESS_TOKEN=at-123")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runChild("sh", []string{"-c", tt.script}, env)
+			if err != nil {
+				t.Fatalf("Failed to run child: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Exit code is incorrect: got %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := runChild("oauth2cli-no-such-command", nil, env); err == nil {
+		t.Errorf("Expected an error for a missing command")
+	}
+}
+
+func TestRunChildInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell and signals")
+	}
+	defer func(f func() bool) { onTerminal = f }(onTerminal)
+
+	// The child counts the interrupts it gets until it's told to stop
+	script := `trap 'echo int >> "$DIR/ints"' INT
+echo $$ > "$DIR/pid"
+while [ ! -f "$DIR/stop" ]; do sleep 0.05; done`
+
+	tests := []struct {
+		name     string
+		terminal bool
+	}{
+		// Sent to us alone, as by kill: forwarded
+		{"kill", false},
+		// Sent by the terminal to the whole process group: not forwarded
+		{"terminal", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onTerminal = func() bool { return tt.terminal }
+			dir := t.TempDir()
+
+			done := make(chan int, 1)
+			go func() {
+				code, err := runChild("sh", []string{"-c", script}, append(os.Environ(), "DIR="+dir))
+				if err != nil {
+					t.Errorf("Failed to run child: %v", err)
+				}
+				done <- code
+			}()
+
+			var pid int
+			for deadline := time.Now().Add(5 * time.Second); pid == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				data, _ := os.ReadFile(filepath.Join(dir, "pid"))
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+			}
+			if pid == 0 {
+				t.Fatal("Child did not start")
+			}
+
+			signal := func(pid int) {
+				p, err := os.FindProcess(pid)
+				if err == nil {
+					err = p.Signal(os.Interrupt)
+				}
+				if err != nil {
+					t.Fatalf("Failed to interrupt %d: %v", pid, err)
+				}
+			}
+			// Apart, so the shell doesn't merge them into one
+			signal(os.Getpid())
+			time.Sleep(200 * time.Millisecond)
+			if tt.terminal {
+				signal(pid)
+			}
+			time.Sleep(200 * time.Millisecond)
+			os.WriteFile(filepath.Join(dir, "stop"), nil, 0o600)
+			<-done
+
+			data, _ := os.ReadFile(filepath.Join(dir, "ints"))
+			if got := strings.Count(string(data), "int"); got != 1 {
+				t.Errorf("Child got the interrupt %d times, want once", got)
+			}
+		})
+	}
+}
+
+func TestWriteTokenFile(t *testing.T) {
+	path, err := writeTokenFile("at-123")
+	if err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "at-123" {
+		t.Errorf("Token file is incorrect: got %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("Token file mode is incorrect: got %o, want 600", info.Mode().Perm())
+	}
+
+	removeTokenFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Token file was not removed")
+	}
+}
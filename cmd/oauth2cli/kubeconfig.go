@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// execCredentialAPIVersion is the version of the kubectl exec credential API
+const execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+
+// execCredential is the object kubectl exchanges with exec credential plugins
+type execCredential struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Spec       execCredentialSpec    `json:"spec"`
+	Status     *execCredentialStatus `json:"status,omitempty"`
+}
+
+// execCredentialSpec describes the request kubectl makes
+type execCredentialSpec struct {
+	// Interactive is set when the plugin may prompt the user
+	Interactive bool `json:"interactive,omitempty"`
+}
+
+// execCredentialStatus holds the credential returned to kubectl
+type execCredentialStatus struct {
+	ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+	Token               string     `json:"token"`
+}
+
+// runKubeconfigCredential implements the kubeconfig-credential command, a
+// kubectl exec credential plugin returning the ID token for OIDC clusters
+func runKubeconfigCredential(ctx context.Context, args []string) error {
+	var opts loginOptions
+	fs := newLoginFlagSet("kubeconfig-credential", &opts)
+	noLogin := fs.Bool("no-login", false, "Only use the cached token or a refresh, never log in")
+
+	// kubectl shows our stderr, so keep it to warnings unless asked
+	if err := fs.Set("quiet", "true"); err != nil {
+		return err
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	// stdin belongs to kubectl
+	if err := opts.checkStdin("kubeconfig-credential"); err != nil {
+		return err
+	}
+
+	// kubectl describes the request in KUBERNETES_EXEC_INFO
+	if info := os.Getenv("KUBERNETES_EXEC_INFO"); info != "" {
+		var req execCredential
+		if err := json.Unmarshal([]byte(info), &req); err != nil {
+			return fmt.Errorf("failed to parse KUBERNETES_EXEC_INFO: %w", err)
+		}
+		if req.APIVersion != execCredentialAPIVersion {
+			return fmt.Errorf("unsupported exec credential API version %q; use %s in the kubeconfig", req.APIVersion, execCredentialAPIVersion)
+		}
+		// There is no one at a terminal to open the browser for, but the
+		// device flow only needs the code shown on stderr, which kubectl
+		// passes on
+		if !req.Spec.Interactive {
+			if opts.endpoint.DeviceAuthURL != "" {
+				opts.device = true
+			} else {
+				*noLogin = true
+			}
+		}
+	}
+
+	entry, claims, err := idTokenEntry(ctx, &opts, *noLogin)
+	if err != nil {
+		return err
+	}
+	return writeExecCredential(os.Stdout, entry.Token.IDToken, time.Unix(claims.Expiration, 0))
+}
+
+// idTokenEntry returns a cached token with a valid ID token, refreshing it
+// or logging in as needed. Clusters authenticate with the ID token, so a
+// valid access token alone isn't enough.
+func idTokenEntry(ctx context.Context, opts *loginOptions, noLogin bool) (*store.Entry, *auth.IDTokenClaims, error) {
+	entry, err := cachedEntry(&opts.options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims := validIDToken(entry, opts.clientID); claims != nil {
+		logger.Info("Using cached ID token (expires %s)", time.Unix(claims.Expiration, 0).Format("15:04:05"))
+		return entry, claims, nil
+	}
+
+	// A refresh usually returns a new ID token too
+	if entry != nil && entry.Token.RefreshToken != "" {
+		refreshed, err := refreshEntry(ctx, &opts.options, entry)
+		if err != nil {
+			logger.Warn("Refreshing the cached token failed: %v", err)
+		} else if claims := validIDToken(refreshed, opts.clientID); claims != nil {
+			return refreshed, claims, nil
+		} else {
+			logger.Warn("The provider did not return a new ID token with the refresh")
+		}
+	}
+
+	if noLogin {
+		return nil, nil, fmt.Errorf("no valid ID token is cached and logging in is not allowed; run 'oauth2cli login'")
+	}
+
+	result, err := login(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry, err = saveToken(&opts.options, result.token, result.claims, nil); err != nil {
+		return nil, nil, err
+	}
+	claims := validIDToken(entry, opts.clientID)
+	if claims == nil {
+		return nil, nil, fmt.Errorf("the provider did not return an ID token; is the openid scope requested?")
+	}
+	return entry, claims, nil
+}
+
+// validIDToken returns the claims of an entry's ID token, or nil if there
+// is none, it expires within store.ExpirySkew or it wasn't issued to clientID
+func validIDToken(entry *store.Entry, clientID string) *auth.IDTokenClaims {
+	if entry == nil || entry.Token == nil || entry.Token.IDToken == "" {
+		return nil
+	}
+	claims, err := auth.ParseIDToken(entry.Token.IDToken)
+	if err != nil {
+		logger.Warn("Ignoring the cached ID token: %v", err)
+		return nil
+	}
+	if time.Now().Add(store.ExpirySkew).Unix() >= claims.Expiration {
+		return nil
+	}
+	if err := auth.ValidateIDToken(claims, clientID); err != nil {
+		logger.Warn("Ignoring the cached ID token: %v", err)
+		return nil
+	}
+	return claims
+}
+
+// writeExecCredential writes the ExecCredential kubectl reads from stdout
+func writeExecCredential(out io.Writer, token string, expiresAt time.Time) error {
+	expiresAt = expiresAt.UTC()
+	cred := execCredential{
+		APIVersion: execCredentialAPIVersion,
+		Kind:       "ExecCredential",
+		Status: &execCredentialStatus{
+			ExpirationTimestamp: &expiresAt,
+			Token:               token,
+		},
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(cred); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
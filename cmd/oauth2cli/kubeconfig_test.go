@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// unsignedIDToken creates an ID token for the client "client" expiring at
+// exp. Its signature is never checked here.
+func unsignedIDToken(exp time.Time) string {
+	return unsignedIDTokenFor("client", exp)
+}
+
+// unsignedIDTokenFor creates an ID token for the audience aud expiring at exp
+func unsignedIDTokenFor(aud string, exp time.Time) string {
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "1", "aud": aud, "exp": exp.Unix(), "iat": time.Now().Unix()})
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func TestIDTokenEntry(t *testing.T) {
+	opts := &loginOptions{options: *newTestOptions(t, "", "--client-id", "client")}
+	ctx := context.Background()
+
+	save := func(idToken string) {
+		token := &auth.TokenResponse{AccessToken: "at-1", ExpiresIn: 3600, IDToken: idToken}
+		if _, err := saveToken(&opts.options, token, &auth.IDTokenClaims{Subject: "1"}, nil); err != nil {
+			t.Fatalf("Failed to save token: %v", err)
+		}
+	}
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	save(unsignedIDToken(exp))
+	entry, claims, err := idTokenEntry(ctx, opts, true)
+	if err != nil {
+		t.Fatalf("Failed to get the cached ID token: %v", err)
+	}
+	if claims.Expiration != exp.Unix() || entry.Token.IDToken == "" {
+		t.Errorf("Unexpected ID token: %+v", claims)
+	}
+
+	// A valid access token doesn't help if the ID token has expired
+	save(unsignedIDToken(time.Now().Add(-time.Minute)))
+	if _, _, err := idTokenEntry(ctx, opts, true); err == nil {
+		t.Errorf("Expected an error for an expired ID token without a login")
+	}
+
+	// An ID token issued to another client counts as missing
+	save(unsignedIDTokenFor("other-client", exp))
+	if _, _, err := idTokenEntry(ctx, opts, true); err == nil {
+		t.Errorf("Expected an error for an ID token of another client without a login")
+	}
+}
+
+func TestIDTokenEntryDeviceFlow(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/device":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"device_code": "dc", "user_code": "ABCD-EFGH", "verification_uri": "https://example.com/device",
+				"expires_in": 60, "interval": 1,
+			})
+		case "/token":
+			if r.PostFormValue("device_code") != "dc" {
+				t.Errorf("Unexpected token request: %v", r.PostForm)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "at-1", "expires_in": 3600, "id_token": unsignedIDToken(exp),
+			})
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer provider.Close()
+
+	opts := &loginOptions{options: *newTestOptions(t, "", "--client-id", "client",
+		"--device-auth-url", provider.URL+"/device", "--token-url", provider.URL+"/token")}
+	opts.device = true
+
+	entry, claims, err := idTokenEntry(context.Background(), opts, false)
+	if err != nil {
+		t.Fatalf("Device flow failed: %v", err)
+	}
+	if claims.Expiration != exp.Unix() || entry.Token.AccessToken != "at-1" {
+		t.Errorf("Unexpected ID token: %+v", claims)
+	}
+
+	// The token is cached for the next call
+	if cached, err := cachedEntry(&opts.options); err != nil || cached == nil {
+		t.Errorf("Token was not cached: %v", err)
+	}
+}
+
+func TestWriteExecCredential(t *testing.T) {
+	var out bytes.Buffer
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	if err := writeExecCredential(&out, "id-token", exp); err != nil {
+		t.Fatalf("Failed to write credential: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid output %q: %v", out.String(), err)
+	}
+	status, _ := got["status"].(map[string]interface{})
+	if got["apiVersion"] != execCredentialAPIVersion || got["kind"] != "ExecCredential" {
+		t.Errorf("Unexpected header: %v", got)
+	}
+	if status["token"] != "id-token" || status["expirationTimestamp"] != "2030-01-02T02:04:05Z" {
+		t.Errorf("Unexpected status: %v", status)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/server"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// loginOptions holds the flags of the interactive login flow
+type loginOptions struct {
+	options
+
+	noBrowser bool
+	manual    bool
+	device    bool
+	tls       bool
+	tlsCert   string
+	tlsKey    string
+	pagesDir  string
+	autoClose bool
+	prompt    string
+	health    bool
+	metrics   bool
+}
+
+// register adds the login flags to fs
+func (o *loginOptions) register(fs *flag.FlagSet) {
+	o.registerGroup(fs, config.GroupLogin)
+}
+
+// apply resolves the common and the login settings
+func (o *loginOptions) apply() error {
+	if err := o.options.apply(); err != nil {
+		return err
+	}
+
+	v := o.values
+	o.noBrowser = v.Bool("no_browser")
+	o.manual = v.Bool("manual")
+	o.device = v.Bool("device")
+	o.tls = v.Bool("tls")
+	o.tlsCert = v.String("tls_cert")
+	o.tlsKey = v.String("tls_key")
+	o.pagesDir = v.String("pages_dir")
+	o.autoClose = v.Bool("auto_close")
+	o.prompt = v.String("prompt")
+	o.health = v.Bool("health_checks")
+	o.metrics = v.Bool("metrics")
+
+	if o.manual && o.device {
+		return usageErrorf("--manual and --device are different ways to log in; use one of them")
+	}
+
+	// The pasted redirect URL is read from stdin too
+	if o.manual {
+		if o.tutor {
+			return usageErrorf("--manual and --tutor both read stdin; use one of them")
+		}
+		if err := o.checkStdin("--manual"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newLoginFlagSet creates the flag set of a command that may log in
+func newLoginFlagSet(name string, opts *loginOptions) *flag.FlagSet {
+	fs := newFlagSet(name, &opts.options)
+	opts.register(fs)
+	return fs
+}
+
+// loginResult holds the outcome of a successful login
+type loginResult struct {
+	token  *auth.TokenResponse
+	claims *auth.IDTokenClaims
+}
+
+// runLogin implements the login command
+func runLogin(ctx context.Context, args []string) error {
+	var opts loginOptions
+	var out outputOptions
+	fs := newLoginFlagSet("login", &opts)
+	out.register(fs, outputText)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := out.apply(); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	result, err := login(ctx, &opts)
+	if err != nil {
+		return err
+	}
+	entry, err := saveToken(&opts.options, result.token, result.claims, nil)
+	if err != nil {
+		return err
+	}
+
+	info, err := out.tokenInfo(entry)
+	if err != nil {
+		return err
+	}
+	return out.print(info, func() error {
+		fmt.Print(auth.FormatTokenInfo(result.token, result.claims))
+		return nil
+	})
+}
+
+// runToken implements the token command
+func runToken(ctx context.Context, args []string) error {
+	var opts loginOptions
+	var out outputOptions
+	fs := newLoginFlagSet("token", &opts)
+	out.register(fs, outputText)
+	idToken := fs.Bool("id-token", false, "Print the ID token instead of the access token in the text output")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := out.apply(); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+
+	entry, err := ensureToken(ctx, &opts)
+	if err != nil {
+		return err
+	}
+
+	info, err := out.tokenInfo(entry)
+	if err != nil {
+		return err
+	}
+	// The text output is the bare token, which the command is asked for
+	return out.print(info, func() error {
+		if *idToken {
+			if entry.Token.IDToken == "" {
+				return fmt.Errorf("the provider did not return an ID token")
+			}
+			fmt.Println(entry.Token.IDToken)
+			return nil
+		}
+		fmt.Println(entry.Token.AccessToken)
+		return nil
+	})
+}
+
+// login runs the interactive Authorization Code Flow with PKCE, or the
+// device flow with --device. opts must have been applied.
+func login(ctx context.Context, opts *loginOptions) (_ *loginResult, err error) {
+	if opts.device {
+		return deviceLogin(ctx, opts)
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	// Prepare the callback server so the redirect URI is known
+	srv := server.NewCallbackServer(opts.port, opts.callbackPath)
+	if opts.tls || opts.tlsCert != "" {
+		if err := srv.EnableTLS(opts.tlsCert, opts.tlsKey); err != nil {
+			return nil, err
+		}
+	}
+	if opts.pagesDir != "" || opts.autoClose {
+		pages, err := server.NewPages(server.PageOptions{Dir: opts.pagesDir, AutoClose: opts.autoClose})
+		if err != nil {
+			return nil, err
+		}
+		srv.SetPages(pages)
+	}
+	if opts.health {
+		srv.EnableHealthChecks()
+	}
+	if opts.metrics {
+		srv.SetMetrics(server.NewMetrics())
+	}
+	cfg.RedirectURI = srv.GetRedirectURI()
+	cfg.Prompt = opts.prompt
+
+	// Let the provider preselect the account asked for by email
+	if strings.Contains(opts.account, "@") {
+		cfg.LoginHint = opts.account
+	}
+
+	client, err := auth.NewOAuth2Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	srv.SetExpectedState(client.GetState())
+
+	// Tag the lines of this flow, including the server's, with its ID
+	log := client.Logger()
+	srv.SetLogger(log)
+
+	// The flow is the root span; the server's callback span is its child
+	ctx, span := client.StartSpan(ctx, "oauth2.login",
+		slog.String(logger.GrantTypeKey, "authorization_code"),
+		slog.Int(logger.ScopeCountKey, len(cfg.Scopes)))
+	defer func() { span.End(err) }()
+	srv.SetTraceContext(ctx)
+
+	_, urlSpan := client.StartSpan(ctx, "oauth2.authorization_url")
+	authURL := client.GetAuthorizationURL()
+	urlSpan.End(nil)
+
+	log.Step(2, "Generate PKCE Code Verifier and Challenge",
+		"Created a random code verifier and derived the code challenge from it")
+	log.Debug("Code verifier: %s", client.GetCodeVerifier())
+	log.Debug("Code challenge: %s", client.GetCodeChallenge())
+	log.Teach(catalog.TopicPKCE)
+
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	defer srv.Stop()
+
+	// Send the user to the provider, and wait until the browser comes back
+	_, waitSpan := client.StartSpan(ctx, "oauth2.browser_wait")
+	log.Step(4, "Open Browser",
+		"Sending the user to the OAuth2 provider to authenticate and authorize the application")
+	if opts.noBrowser {
+		fmt.Fprintf(os.Stderr, "\nOpen this URL in your browser:\n\n  %s\n\n", authURL)
+	} else if err := utils.OpenBrowser(authURL); err != nil {
+		log.Warn("Could not open the browser: %v", err)
+		fmt.Fprintf(os.Stderr, "\nOpen this URL in your browser:\n\n  %s\n\n", authURL)
+	}
+
+	if opts.manual {
+		fmt.Fprintln(os.Stderr, "If the browser can't reach this machine, paste the URL it was redirected to here:")
+		srv.AcceptPastedInput(os.Stdin)
+	}
+
+	log.Step(5, "Wait for Authorization",
+		"Waiting for the user to log in and approve the requested scopes")
+
+	code, err := srv.WaitForCode(ctx)
+	waitSpan.End(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange the code for tokens
+	started := time.Now()
+	token, err := client.ExchangeCodeForToken(ctx, code)
+	srv.Metrics().ObserveTokenExchange(time.Since(started))
+	if err != nil {
+		return nil, err
+	}
+	return newLoginResult(ctx, client, opts, token)
+}
+
+// deviceLogin runs the Device Authorization Grant (RFC 8628), which needs
+// neither a browser on this machine nor a callback reaching it. The code
+// to enter goes to stderr, so it is shown even with --quiet and stays out
+// of the output of the command. opts must have been applied.
+func deviceLogin(ctx context.Context, opts *loginOptions) (_ *loginResult, err error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	client, err := auth.NewOAuth2Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	ctx, span := client.StartSpan(ctx, "oauth2.login",
+		slog.String(logger.GrantTypeKey, auth.DeviceCodeGrantType),
+		slog.Int(logger.ScopeCountKey, len(cfg.Scopes)))
+	defer func() { span.End(err) }()
+
+	device, err := client.RequestDeviceAuthorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "\nTo log in, open this URL in a browser on any device:\n\n  %s\n\nand enter the code %s\n\n", device.VerificationURI, device.UserCode)
+	if device.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "Or open this URL, which fills in the code:\n\n  %s\n\n", device.VerificationURIComplete)
+	}
+
+	token, err := client.PollDeviceToken(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	return newLoginResult(ctx, client, opts, token)
+}
+
+// newLoginResult validates the ID token of a login, if there is one, and
+// warns if the user logged in with another account than opts asked for
+func newLoginResult(ctx context.Context, client *auth.OAuth2Client, opts *loginOptions, token *auth.TokenResponse) (*loginResult, error) {
+	result := &loginResult{token: token}
+	if token.IDToken != "" {
+		claims, err := validateIDToken(ctx, client, token.IDToken, opts.clientID)
+		if err != nil {
+			return nil, err
+		}
+		result.claims = claims
+
+		// The user may have picked another account in the browser
+		if opts.account != "" && opts.account != claims.Subject && !strings.EqualFold(opts.account, claims.Email) {
+			client.Logger().Warn("Logged in as %s, not as the requested account %s", accountName(claims.Subject, claims.Email), opts.account)
+		}
+	}
+
+	return result, nil
+}
+
+// validateIDToken parses and validates the ID token of a login, in a span
+// of the flow
+func validateIDToken(ctx context.Context, client *auth.OAuth2Client, idToken, clientID string) (_ *auth.IDTokenClaims, err error) {
+	_, span := client.StartSpan(ctx, "oauth2.id_token_validation")
+	defer func() { span.End(err) }()
+
+	claims, err := client.ParseIDToken(idToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.ValidateIDToken(claims, clientID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/server"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// runLogout implements the logout command
+func runLogout(ctx context.Context, args []string) error {
+	var opts options
+	fs := newFlagSet("logout", &opts)
+	idTokenArg := tokenFlag(fs, "id-token-hint", "OAUTH2_ID_TOKEN", "ID token identifying the session to end; defaults to the cached one")
+	logoutPath := fs.String("logout-path", defaultLogoutPath, "Path the provider redirects to after the logout")
+	noWait := fs.Bool("no-wait", false, "Don't wait for the provider to redirect back")
+	noBrowser := fs.Bool("no-browser", false, "Print the logout URL instead of opening a browser")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	if opts.endpoint.EndSessionURL == "" {
+		return usageErrorf("the provider has no end session endpoint (--end-session-url or OAUTH2_END_SESSION_URL)")
+	}
+
+	// Use the cached ID token as the hint. The cached login is only
+	// forgotten once the logout went through, so a failed one can be retried.
+	entry, err := cachedEntry(&opts)
+	if err != nil {
+		return err
+	}
+	idToken, err := idTokenArg.Value()
+	if err != nil {
+		return err
+	}
+	if idToken == "" && entry != nil {
+		idToken = entry.Token.IDToken
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	state, err := auth.GenerateState()
+	if err != nil {
+		return err
+	}
+
+	// Serve the post-logout redirect unless we don't wait for it
+	req := auth.EndSessionRequest{
+		IDTokenHint: idToken,
+		State:       state,
+		ClientID:    opts.clientID,
+	}
+	var srv *server.CallbackServer
+	if !*noWait {
+		srv = server.NewCallbackServer(opts.port, opts.callbackPath)
+		srv.SetLogger(logger.With(logger.FlowKey, logger.NewFlowID()))
+		srv.EnableLogout(server.LogoutOptions{ReturnPath: *logoutPath})
+		srv.SetLogoutState(state)
+		req.PostLogoutRedirectURI = srv.GetPostLogoutRedirectURI()
+
+		if err := srv.Start(); err != nil {
+			return err
+		}
+		defer srv.Stop()
+	}
+
+	logoutURL, err := auth.BuildEndSessionURL(opts.endpoint.EndSessionURL, req)
+	if err != nil {
+		return err
+	}
+
+	if *noBrowser {
+		fmt.Fprintf(os.Stderr, "\nOpen this URL in your browser:\n\n  %s\n\n", logoutURL)
+	} else if err := utils.OpenBrowser(logoutURL); err != nil {
+		logger.Warn("Could not open the browser: %v", err)
+		fmt.Fprintf(os.Stderr, "\nOpen this URL in your browser:\n\n  %s\n\n", logoutURL)
+	}
+
+	// Without the redirect back, the URL being handed out is all we know
+	if srv == nil {
+		return deleteToken(&opts)
+	}
+
+	if err := srv.WaitForLogout(ctx); err != nil {
+		return err
+	}
+	if err := deleteToken(&opts); err != nil {
+		return err
+	}
+
+	fmt.Println("Logged out")
+	return nil
+}
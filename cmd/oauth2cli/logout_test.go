@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+func TestLogoutKeepsTokenUntilLoggedOut(t *testing.T) {
+	opts := newTestOptions(t, "", "--client-id", "client")
+	claims := &auth.IDTokenClaims{Subject: "1"}
+	token := &auth.TokenResponse{AccessToken: "at", IDToken: "id-token", ExpiresIn: 3600}
+	if _, err := saveToken(opts, token, claims, nil); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	args := []string{"--config", opts.configPath, "--token-cache", opts.tokenCache, "--client-id", "client",
+		"--end-session-url", "https://idp.example.com/logout", "--no-browser", "--quiet"}
+
+	// The provider never redirects back, so the logout isn't confirmed
+	err = runLogout(context.Background(), append(args, "--timeout", "100ms", "--port", strconv.Itoa(port)))
+	if err == nil {
+		t.Fatal("Logout without the redirect back succeeded")
+	}
+	if entry, err := cachedEntry(opts); err != nil || entry == nil {
+		t.Fatalf("Failed logout forgot the cached token: %+v, %v", entry, err)
+	}
+
+	// Without waiting, handing out the URL is the logout
+	if err := runLogout(context.Background(), append(args, "--no-wait")); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if entry, err := cachedEntry(opts); err != nil || entry != nil {
+		t.Errorf("Logout kept the cached token: %+v, %v", entry, err)
+	}
+}
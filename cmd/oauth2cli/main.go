@@ -0,0 +1,224 @@
+// Command oauth2cli demonstrates the OAuth2 Authorization Code Flow with
+// PKCE, and the operations around it, with detailed educational logging.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/server"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// Exit codes
+const (
+	// exitOK means the command succeeded
+	exitOK = 0
+	// exitFailure means a local failure, like a network error or a bad file
+	exitFailure = 1
+	// exitUsage means the command line was invalid
+	exitUsage = 2
+	// exitCancelled means the user cancelled the flow
+	exitCancelled = 3
+	// exitProviderError means the OAuth2 provider returned an error
+	exitProviderError = 4
+)
+
+// command is a subcommand of the CLI
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands lists the subcommands by name
+var commands = map[string]command{}
+
+// currentCommand is the name of the running subcommand
+var currentCommand string
+
+// register adds a subcommand
+func register(cmd command) {
+	commands[cmd.name] = cmd
+}
+
+func init() {
+	register(command{"login", "Log in through the browser and show the tokens", runLogin})
+	register(command{"token", "Print a valid access token, logging in only if needed", runToken})
+	register(command{"refresh", "Exchange a refresh token for new tokens", runRefresh})
+	register(command{"revoke", "Revoke an access or refresh token", runRevoke})
+	register(command{"introspect", "Ask the provider whether a token is active", runIntrospect})
+	register(command{"userinfo", "Fetch the user's claims from the userinfo endpoint", runUserInfo})
+	register(command{"exec", "Run a program with a valid token in its environment", runExec})
+	register(command{"credential-helper", "Act as a git or docker credential helper", runCredentialHelper})
+	register(command{"kubeconfig-credential", "Print an ExecCredential with the ID token for kubectl", runKubeconfigCredential})
+	register(command{"decode", "Decode a JWT without verifying it", runDecode})
+	register(command{"logout", "End the session at the OpenID provider", runLogout})
+	register(command{"accounts", "List, switch or remove cached accounts", runAccounts})
+	register(command{"config", "Show the effective settings and where they came from", runConfig})
+	register(command{"doctor", "Check the configuration and the token cache", runDoctor})
+	register(command{"replay", "Show a recorded transcript, serve its responses or write a walkthrough report of it", runReplay})
+}
+
+func main() {
+	args := os.Args[1:]
+
+	// Docker runs its credential helpers as docker-credential-<name> <action>
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name == dockerHelperName {
+		args = append([]string{"credential-helper", "docker"}, args...)
+	}
+
+	os.Exit(run(args))
+}
+
+// run executes the CLI and returns the exit code
+func run(args []string) int {
+	// Results go to stdout, so logs go to stderr to keep them apart
+	logger.DefaultLogger.SetWriter(os.Stderr)
+
+	// Without a subcommand, log in, as the original single-command CLI did
+	name := "login"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "help":
+		printUsage()
+		return exitOK
+	case "-h", "--help":
+		printUsage()
+		return exitOK
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		return exitUsage
+	}
+
+	// Cancel the flow on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	currentCommand = name
+	err := cmd.run(ctx, args)
+	code := exitCode(err)
+	finishTutor(err)
+	finishRecording(code, err)
+	finishSpans()
+	var exitErr *exitError
+	if err != nil && !errors.Is(err, flag.ErrHelp) && !errors.As(err, &exitErr) {
+		// Keep stderr parseable when the logs are structured
+		if logger.DefaultLogger.Format() != logger.TextFormat {
+			logger.Error("oauth2cli %s: %v", name, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "oauth2cli %s: %s\n", name, logger.Redact(err.Error()))
+		}
+	}
+	return code
+}
+
+// printUsage prints the list of subcommands
+func printUsage() {
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: oauth2cli <command> [flags]")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-22s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Run 'oauth2cli <command> --help' for the flags of a command.")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Exit codes:")
+	fmt.Fprintf(out, "  %d  success\n", exitOK)
+	fmt.Fprintf(out, "  %d  local failure\n", exitFailure)
+	fmt.Fprintf(out, "  %d  invalid command line\n", exitUsage)
+	fmt.Fprintf(out, "  %d  cancelled by the user\n", exitCancelled)
+	fmt.Fprintf(out, "  %d  error returned by the OAuth2 provider\n", exitProviderError)
+	fmt.Fprintln(out, "  exec exits with the exit code of its command")
+	fmt.Fprintln(out, "")
+	utils.PrintEnvHelp(out, config.EnvHelp())
+}
+
+// usageError is an invalid command line
+type usageError struct {
+	msg string
+}
+
+// Error implements the error interface
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// usageErrorf creates a usageError
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// exitCode maps an error to the exit code that describes it
+func exitCode(err error) int {
+	var usageErr *usageError
+	var exitErr *exitError
+	var callbackErr *server.OAuthError
+	var providerErr *auth.ProviderError
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &callbackErr) && callbackErr.Cancelled():
+		return exitCancelled
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.As(err, &callbackErr), errors.As(err, &providerErr):
+		return exitProviderError
+	default:
+		return exitFailure
+	}
+}
+
+// parseFlags parses the flags of a subcommand. Flag errors are usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of a subcommand with the common flags
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	opts.register(fs)
+	return fs
+}
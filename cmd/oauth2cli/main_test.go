@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/server"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"help", flag.ErrHelp, exitOK},
+		{"usage", usageErrorf("bad flag"), exitUsage},
+		{"user denied consent", &server.OAuthError{Code: "access_denied"}, exitCancelled},
+		{"interrupted", fmt.Errorf("waiting: %w", context.Canceled), exitCancelled},
+		{"callback error", &server.OAuthError{Code: "invalid_scope"}, exitProviderError},
+		{"token endpoint error", fmt.Errorf("exchange: %w", &auth.ProviderError{StatusCode: 400}), exitProviderError},
+		{"child exit code", &exitError{code: 7}, 7},
+		{"local failure", errors.New("port 8080 is not available"), exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("Exit code is incorrect: got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStdinReaders(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_SECRET", "stdin:")
+	t.Setenv("KUBERNETES_EXEC_INFO", "")
+	flags := func(args ...string) []string {
+		config := filepath.Join(t.TempDir(), "config.json")
+		return append([]string{"--config", config, "--client-id", "client"}, args...)
+	}
+
+	// Every command that reads stdin itself refuses a secret from stdin
+	tests := []struct {
+		reader string
+		run    func(context.Context, []string) error
+		args   []string
+	}{
+		{"--manual", runLogin, flags("--manual")},
+		{"--tutor", runLogin, flags("--tutor")},
+		{"credential-helper", runCredentialHelper, append([]string{"git"}, flags("get")...)},
+		{"kubeconfig-credential", runKubeconfigCredential, flags()},
+		{"--step", runReplay, flags("--step", "transcript.json")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reader, func(t *testing.T) {
+			err := tt.run(context.Background(), tt.args)
+			want := tt.reader + " reads stdin, so client_secret can't come from stdin"
+			if exitCode(err) != exitUsage || err.Error() != want {
+				t.Errorf("Error is incorrect: got %v, want %q", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/config"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/store"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// Default values for the common flags
+const (
+	defaultPort         = 8080
+	defaultCallbackPath = "/oauth/callback"
+	defaultLogoutPath   = "/oauth/logout"
+)
+
+// options holds the flags shared by every subcommand
+type options struct {
+	// flags holds the schema flags registered for the command by setting name
+	flags map[string]*settingFlag
+	// values holds the effective settings once applied
+	values *config.Values
+
+	configPath string
+	profile    string
+	account    string
+
+	clientID string
+	// Secrets may be file:, cmd: or stdin: references, so they are only
+	// resolved by the commands that need them
+	clientSecret *config.Secret
+	scopes       string
+	audience     string
+
+	port         int
+	callbackPath string
+	timeout      time.Duration
+	debug        bool
+	quiet        bool
+	logFormat    logger.Format
+	color        logger.Color
+	compact      bool
+	unsafeLog    bool
+	lang         string
+	trace        []string
+	tutor        bool
+	record       string
+	report       string
+	spans        string
+	// replaying is set by the replay command, which reads a transcript
+	// instead of recording one
+	replaying bool
+
+	tokenCache string
+	noCache    bool
+
+	cachePassphrase    *config.Secret
+	cacheKeyFile       string
+	cacheOldPassphrase *config.Secret
+	cacheOldKeyFile    string
+
+	endpoint auth.Endpoint
+}
+
+// register adds the common flags to fs. Defaults, the config file and the
+// environment are applied after parsing, by apply.
+func (o *options) register(fs *flag.FlagSet) {
+	o.registerGroup(fs, config.GroupCommon)
+}
+
+// registerGroup adds the flags of a group of settings to fs
+func (o *options) registerGroup(fs *flag.FlagSet, group string) {
+	if o.flags == nil {
+		o.flags = make(map[string]*settingFlag)
+	}
+
+	for _, s := range config.Schema {
+		if s.Group != group || s.Flag == "" {
+			continue
+		}
+
+		f := &settingFlag{setting: s}
+		o.flags[s.Name()] = f
+
+		usage := s.Usage
+		if s.Env != "" {
+			usage += fmt.Sprintf(" (env %s)", s.Env)
+		}
+		fs.Var(f, s.Flag, usage)
+	}
+}
+
+// apply resolves the settings, validates them and configures the logger
+func (o *options) apply() error {
+	if err := o.resolve(); err != nil {
+		return err
+	}
+
+	logger.SetDefaultFormat(o.logFormat)
+	logger.SetDefaultColor(o.color)
+	logger.SetDefaultCompact(o.compact)
+	switch {
+	case o.quiet:
+		logger.SetDefaultLogLevel(logger.WarnLevel)
+	case o.debug:
+		logger.SetDefaultLogLevel(logger.DebugLevel)
+	default:
+		logger.SetDefaultLogLevel(logger.InfoLevel)
+	}
+	logger.SetDefaultUnsafe(o.unsafeLog)
+	if o.unsafeLog {
+		logger.Warn("Unsafe logging is on: tokens, codes and secrets appear in the logs")
+	}
+	if o.profile != "" {
+		logger.Debug("Using profile %q from %s", o.profile, o.configPath)
+	}
+
+	// An explicit language must exist; one from the environment may not
+	if o.lang != "" {
+		if err := catalog.SetLocale(o.lang); err != nil {
+			return usageErrorf("%v", err)
+		}
+	} else if locale := catalog.FromEnv(); catalog.SetLocale(locale) != nil {
+		logger.Debug("No educational notes in the language %q of the environment, using English", locale)
+	}
+
+	if o.port < 1 || o.port > 65535 {
+		return usageErrorf("--port must be between 1 and 65535")
+	}
+	if !strings.HasPrefix(o.callbackPath, "/") {
+		return usageErrorf("--callback-path must start with /")
+	}
+	if o.timeout <= 0 {
+		return usageErrorf("--timeout must be positive")
+	}
+	if o.cachePassphrase.IsSet() && o.cacheKeyFile != "" {
+		return usageErrorf("use either OAUTH2_CACHE_PASSPHRASE or --cache-key-file, not both")
+	}
+
+	if o.tutor {
+		if err := o.checkStdin("--tutor"); err != nil {
+			return err
+		}
+		startTutor()
+	}
+
+	if o.spans != "" {
+		startSpans(o.spans)
+	}
+
+	if (o.record != "" || o.report != "") && !o.replaying {
+		return startRecording(o.record, o.report)
+	}
+	return nil
+}
+
+// stdinSecret returns the name of a secret setting read from stdin, if any
+func (o *options) stdinSecret() string {
+	for _, value := range o.values.All() {
+		if value.Setting.Secret && value.Raw == config.SecretStdinPrefix {
+			return value.Setting.Name()
+		}
+	}
+	return ""
+}
+
+// checkStdin returns a usage error if a secret is to be read from stdin,
+// since reader, such as a flag or a command, reads stdin itself
+func (o *options) checkStdin(reader string) error {
+	if name := o.stdinSecret(); name != "" {
+		return usageErrorf("%s reads stdin, so %s can't come from stdin", reader, name)
+	}
+	return nil
+}
+
+// resolve layers the defaults, the config file, the environment and the
+// flags, and fills in the options from the result
+func (o *options) resolve() error {
+	flags := make(map[string]string)
+	for name, f := range o.flags {
+		if f.set {
+			flags[name] = f.value
+		}
+	}
+
+	// The config file can't name itself, so only flags and the environment count
+	o.configPath = flags["config"]
+	if o.configPath == "" {
+		o.configPath = utils.GetEnv(config.Lookup("config").Env, "")
+	}
+	if o.configPath == "" {
+		var err error
+		if o.configPath, err = config.DefaultPath(); err != nil {
+			return err
+		}
+	}
+
+	file, err := config.Load(o.configPath)
+	if err != nil {
+		return err
+	}
+	v, err := config.Resolve(file, flags)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	o.values = v
+
+	o.profile = v.Profile
+	o.account = v.String("account")
+	o.clientID = v.String("client_id")
+	o.clientSecret = v.Secret("client_secret")
+	o.scopes = strings.Join(v.List("scopes"), " ")
+	o.audience = v.String("audience")
+
+	// Explicit --port and --callback-path override the redirect URI
+	o.port, o.callbackPath = parseRedirectURI(v.String("redirect_uri"))
+	if p := v.Get("port"); p.Source != config.SourceDefault {
+		o.port = v.Int("port")
+	}
+	if p := v.Get("callback_path"); p.Source != config.SourceDefault {
+		o.callbackPath = p.Raw
+	}
+
+	o.timeout = v.Duration("timeout")
+	o.debug = v.Bool("debug")
+	o.quiet = v.Bool("quiet")
+	o.unsafeLog = v.Bool("unsafe_log")
+	o.lang = v.String("lang")
+	o.trace = v.List("trace")
+	o.tutor = v.Bool("tutor")
+	o.record = v.String("record")
+	o.report = v.String("report")
+	o.spans = v.String("spans")
+	if o.logFormat, err = logger.ParseFormat(v.String("log_format")); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if o.color, err = logger.ParseColor(v.String("color")); err != nil {
+		return usageErrorf("%v", err)
+	}
+	o.compact = v.Bool("compact")
+	o.tokenCache = v.String("token_cache")
+	o.noCache = v.Bool("no_cache")
+	o.cachePassphrase = v.Secret("cache_passphrase")
+	o.cacheKeyFile = v.String("cache_key_file")
+	o.cacheOldPassphrase = v.Secret("cache_old_passphrase")
+	o.cacheOldKeyFile = v.String("cache_old_key_file")
+	o.endpoint = v.Endpoint()
+	return nil
+}
+
+// settingFlag is a command-line flag for a schema setting. It remembers
+// whether it was given, so it can take precedence over the other layers.
+type settingFlag struct {
+	setting *config.Setting
+	value   string
+	set     bool
+}
+
+// String implements flag.Value
+func (f *settingFlag) String() string {
+	if f == nil || f.setting == nil {
+		return ""
+	}
+	if f.set {
+		return f.value
+	}
+	return f.setting.Default
+}
+
+// Set implements flag.Value
+func (f *settingFlag) Set(value string) error {
+	if err := f.setting.Validate(value); err != nil {
+		return err
+	}
+	f.value, f.set = value, true
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given without a value
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.setting != nil && f.setting.Kind == config.Bool
+}
+
+// statePath returns the location of the state file
+func (o *options) statePath() string {
+	return config.StatePath(o.configPath)
+}
+
+// config returns the OAuth2 client configuration. The client ID is always
+// required; the redirect URI is filled in by the login flow.
+func (o *options) config() (auth.OAuth2Config, error) {
+	if o.clientID == "" {
+		return auth.OAuth2Config{}, usageErrorf("a client ID is required (--client-id or GOOGLE_CLIENT_ID)")
+	}
+	clientSecret, err := o.clientSecret.Value()
+	if err != nil {
+		return auth.OAuth2Config{}, err
+	}
+
+	return auth.OAuth2Config{
+		ClientID:     o.clientID,
+		ClientSecret: clientSecret,
+		Scopes:       strings.Fields(o.scopes),
+		Audience:     o.audience,
+		Endpoint:     o.endpoint,
+		Trace:        o.trace,
+		Transport:    recordTransport(),
+		Review:       tutorReview(),
+	}, nil
+}
+
+// client creates an OAuth2 client for commands that don't need a redirect URI
+func (o *options) client() (*auth.OAuth2Client, error) {
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewOAuth2Client(cfg)
+}
+
+// store returns the token cache selected by the flags
+func (o *options) store() (store.TokenStore, error) {
+	if o.noCache {
+		return store.NewMemoryStore(), nil
+	}
+
+	return o.fileStore()
+}
+
+// fileStore returns the token cache file, encrypted if a key is configured
+func (o *options) fileStore() (*store.FileStore, error) {
+	path := o.tokenCache
+	if path == "" {
+		var err error
+		if path, err = store.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	s := store.NewFileStore(path)
+
+	enc, err := o.encryptor()
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		s.SetEncryption(enc)
+	}
+	return s, nil
+}
+
+// encryptor returns the token cache encryptor, or nil if no key is configured
+func (o *options) encryptor() (*store.Encryptor, error) {
+	var enc *store.Encryptor
+	var err error
+	switch {
+	case o.cachePassphrase.IsSet():
+		var passphrase string
+		if passphrase, err = o.cachePassphrase.Value(); err == nil {
+			enc, err = store.NewPassphraseEncryptor(passphrase)
+		}
+	case o.cacheKeyFile != "":
+		enc, err = store.NewKeyFileEncryptor(o.cacheKeyFile)
+	default:
+		if o.cacheOldPassphrase.IsSet() || o.cacheOldKeyFile != "" {
+			return nil, usageErrorf("an old cache key needs a new one (OAUTH2_CACHE_PASSPHRASE or --cache-key-file)")
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Old keys only decrypt, so entries can be moved to the new key
+	if o.cacheOldPassphrase.IsSet() {
+		passphrase, err := o.cacheOldPassphrase.Value()
+		if err != nil {
+			return nil, err
+		}
+		enc.AddOldPassphrase(passphrase)
+	}
+	if o.cacheOldKeyFile != "" {
+		if err := enc.AddOldKeyFile(o.cacheOldKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return enc, nil
+}
+
+// provider identifies the provider in cache keys
+func (o *options) provider() string {
+	if o.endpoint.Issuer != "" {
+		return o.endpoint.Issuer
+	}
+	return o.endpoint.AuthURL
+}
+
+// cacheKey returns the key of the cached token of an account for the current settings
+func (o *options) cacheKey(account string) store.Key {
+	return store.NewKey(o.provider(), o.clientID, account, strings.Fields(o.scopes))
+}
+
+// parseRedirectURI extracts the port and callback path from a redirect URI,
+// using the defaults for the parts it lacks
+func parseRedirectURI(redirectURI string) (int, string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return defaultPort, defaultCallbackPath
+	}
+
+	port := defaultPort
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		port = p
+	}
+	path := defaultCallbackPath
+	if u.Path != "" {
+		path = u.Path
+	}
+	return port, path
+}
+
+// tokenValue is a token given with a flag like --token, or else with its
+// environment variable. Like other secrets it may be a file:, cmd: or stdin:
+// reference. A literal token on the command line is visible to every local
+// user in the process list and stays in the shell history, so it is warned
+// about.
+type tokenValue struct {
+	flag     string
+	env      string
+	raw      string
+	fromFlag bool
+}
+
+// tokenFlag registers a --token flag with the environment variable of a
+// command setting as its fallback
+func tokenFlag(fs *flag.FlagSet, name, env, usage string) *tokenValue {
+	if config.LookupEnv(env) == nil {
+		panic(fmt.Sprintf("environment variable %s is not in the config schema", env))
+	}
+	t := &tokenValue{flag: name, env: env, raw: utils.GetEnv(env, "")}
+	fs.Var(t, name, fmt.Sprintf("%s; a file:, cmd: or stdin: reference keeps it off the command line (env %s)", usage, env))
+	return t
+}
+
+// String implements flag.Value, hiding the token
+func (t *tokenValue) String() string {
+	if t == nil || t.raw == "" || config.IsSecretRef(t.raw) {
+		return ""
+	}
+	return "********"
+}
+
+// Set implements flag.Value
+func (t *tokenValue) Set(value string) error {
+	t.raw, t.fromFlag = value, true
+	return nil
+}
+
+// Value resolves the token, or returns "" if none was given
+func (t *tokenValue) Value() (string, error) {
+	if t.raw == "" {
+		return "", nil
+	}
+	if t.fromFlag && !config.IsSecretRef(t.raw) {
+		logger.Warn("--%s puts the token in the process list and the shell history; use a file: or stdin: reference or %s instead", t.flag, t.env)
+	}
+	return config.NewSecret(t.flag, t.raw).Value()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/store"
+)
+
+// Output formats
+const (
+	outputText     = "text"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputEnv      = "env"
+	outputTemplate = "template"
+)
+
+// outputOptions holds the flags selecting how a command prints its result
+type outputOptions struct {
+	format        string
+	defaultFormat string
+	template      string
+	showTokens    bool
+
+	tmpl *template.Template
+}
+
+// register adds the output flags to fs
+func (o *outputOptions) register(fs *flag.FlagSet, defaultFormat string) {
+	o.defaultFormat = defaultFormat
+	fs.StringVar(&o.format, "output", "", fmt.Sprintf("Output format: text, json, yaml, env or template (default %s)", defaultFormat))
+	fs.StringVar(&o.template, "template", "", "Go text/template for the template output, e.g. '{{.access_token}}'; implies --output template")
+	fs.BoolVar(&o.showTokens, "show-tokens", false, "Include the full tokens in the json, yaml, env and template output")
+}
+
+// apply validates the output flags and parses the template
+func (o *outputOptions) apply() error {
+	if o.format == "" {
+		o.format = o.defaultFormat
+		if o.template != "" {
+			o.format = outputTemplate
+		}
+	}
+
+	switch o.format {
+	case outputText, outputJSON, outputYAML, outputEnv:
+		if o.template != "" {
+			return usageErrorf("--template needs --output template")
+		}
+	case outputTemplate:
+		if o.template == "" {
+			return usageErrorf("--output template needs --template")
+		}
+		tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=error").Parse(o.template)
+		if err != nil {
+			return usageErrorf("invalid --template: %v", err)
+		}
+		o.tmpl = tmpl
+	default:
+		return usageErrorf("unknown output format %q (text, json, yaml, env or template)", o.format)
+	}
+	return nil
+}
+
+// templateFuncs are the extra functions available to --template
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"join": func(sep string, v []interface{}) string {
+		parts := make([]string, len(v))
+		for i, p := range v {
+			parts[i] = fmt.Sprint(p)
+		}
+		return strings.Join(parts, sep)
+	},
+}
+
+// print writes v in the selected format. The text format is different for
+// every command, so text renders it.
+func (o *outputOptions) print(v interface{}, text func() error) error {
+	if o.format == outputText {
+		return text()
+	}
+	if o.format == outputJSON {
+		return printJSON(v)
+	}
+
+	// The other formats work on the JSON form, so field names are the same
+	generic, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch o.format {
+	case outputYAML:
+		writeYAML(&buf, generic, 0)
+	case outputEnv:
+		vars := make(map[string]string)
+		flattenEnv(vars, "", generic)
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "%s=%s\n", name, shellQuote(vars[name]))
+		}
+	case outputTemplate:
+		if err := o.tmpl.Execute(&buf, generic); err != nil {
+			return fmt.Errorf("failed to execute --template: %w", err)
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+	}
+
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// tokenInfo describes a cached token for the machine-readable formats
+func (o *outputOptions) tokenInfo(entry *store.Entry) (*auth.TokenInfo, error) {
+	info, err := auth.NewTokenInfo(entry.Token, entry.ExpiresAt, o.showTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a scope in the response, the provider granted what was requested
+	if len(info.Scopes) == 0 {
+		info.Scopes = entry.Key.Scopes
+	}
+	return info, nil
+}
+
+// toGeneric converts v to maps, slices and scalars through its JSON form
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode output: %w", err)
+	}
+
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to encode output: %w", err)
+	}
+	return generic, nil
+}
+
+// writeYAML writes a generic value as YAML. Maps are sorted by key.
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(buf, "%s%s:", pad, yamlScalar(k))
+			writeYAMLValue(buf, v[k], indent)
+		}
+	case []interface{}:
+		for _, item := range v {
+			fmt.Fprintf(buf, "%s-", pad)
+			writeYAMLValue(buf, item, indent)
+		}
+	default:
+		fmt.Fprintf(buf, "%s%s\n", pad, yamlScalar(v))
+	}
+}
+
+// writeYAMLValue writes the value after a key or a list dash
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		if len(c) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(c) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+	default:
+		fmt.Fprintf(buf, " %s\n", yamlScalar(v))
+		return
+	}
+	buf.WriteString("\n")
+	writeYAML(buf, v, indent+2)
+}
+
+// yamlPlain matches strings that are safe to write without quotes
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./@+-]*$`)
+
+// yamlScalar formats a scalar, quoting strings that YAML would read as
+// something else
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no", "on", "off", "y", "n", "null":
+			return strconv.Quote(v)
+		}
+		if yamlPlain.MatchString(v) {
+			return v
+		}
+		// JSON strings are valid double-quoted YAML scalars
+		out, _ := json.Marshal(v)
+		return string(out)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// envName matches the characters not allowed in variable names
+var envName = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// flattenEnv turns a generic value into variables named after its path,
+// like CLAIMS_EMAIL. Lists of scalars are joined with spaces.
+func flattenEnv(vars map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			name := envName.ReplaceAllString(strings.ToUpper(k), "_")
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flattenEnv(vars, name, item)
+		}
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				out, _ := json.Marshal(v)
+				vars[prefix] = string(out)
+				return
+			}
+			parts = append(parts, fmt.Sprint(item))
+		}
+		vars[prefix] = strings.Join(parts, " ")
+	case nil:
+		vars[prefix] = ""
+	default:
+		vars[prefix] = fmt.Sprint(v)
+	}
+}
+
+// shellQuote quotes a value for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
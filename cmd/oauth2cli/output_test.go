@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutputApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      outputOptions
+		want     string
+		wantFail bool
+	}{
+		{"default", outputOptions{defaultFormat: outputText}, outputText, false},
+		{"template implies format", outputOptions{defaultFormat: outputText, template: "{{.scopes}}"}, outputTemplate, false},
+		{"template without text", outputOptions{format: outputTemplate}, "", true},
+		{"template with json", outputOptions{format: outputJSON, template: "{{.scopes}}"}, "", true},
+		{"invalid template", outputOptions{format: outputTemplate, template: "{{.scopes"}, "", true},
+		{"unknown format", outputOptions{format: "xml"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.out.apply()
+			if tt.wantFail {
+				if exitCode(err) != exitUsage {
+					t.Errorf("Expected a usage error, got %v", err)
+				}
+				return
+			}
+			if err != nil || tt.out.format != tt.want {
+				t.Errorf("Format is incorrect: got %q, %v, want %q", tt.out.format, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	v, err := toGeneric(map[string]interface{}{
+		"token_type": "Bearer",
+		"expires_in": 3599,
+		"scopes":     []string{"openid", "email"},
+		"claims":     map[string]interface{}{"email_verified": true, "name": "O'Neil: admin", "hd": "no"},
+		"empty":      []string{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, v, 0)
+	want := `claims:
+  email_verified: true
+  hd: "no"
+  name: "O'Neil: admin"
+empty: []
+expires_in: 3599
+scopes:
+  - openid
+  - email
+token_type: Bearer
+`
+	if buf.String() != want {
+		t.Errorf("YAML is incorrect: got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestFlattenEnv(t *testing.T) {
+	v, err := toGeneric(map[string]interface{}{
+		"access_token": "it's",
+		"scopes":       []string{"openid", "email"},
+		"claims":       map[string]interface{}{"sub": "123", "https://example.com/role": "admin"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	vars := make(map[string]string)
+	flattenEnv(vars, "", v)
+	want := map[string]string{
+		"ACCESS_TOKEN":                  "it's",
+		"SCOPES":                        "openid email",
+		"CLAIMS_SUB":                    "123",
+		"CLAIMS_HTTPS_EXAMPLE_COM_ROLE": "admin",
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Errorf("%s is incorrect: got %q, want %q", name, vars[name], value)
+		}
+	}
+	if got := shellQuote(vars["ACCESS_TOKEN"]); got != `'it'\''s'` {
+		t.Errorf("Quoting is incorrect: got %s", got)
+	}
+}
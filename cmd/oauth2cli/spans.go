@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+var (
+	// tracer keeps the spans of the run, if --spans is set
+	tracer *logger.MemoryTracer
+	// spansPath is the file the spans are appended to
+	spansPath string
+)
+
+// startSpans traces the flows of the command. The spans are kept in memory
+// and appended to path when the run ends.
+func startSpans(path string) {
+	if tracer != nil {
+		return
+	}
+	tracer, spansPath = logger.NewMemoryTracer(), path
+	logger.DefaultLogger.SetTracer(tracer)
+}
+
+// finishSpans appends the spans of the run to the spans file
+func finishSpans() {
+	if tracer == nil {
+		return
+	}
+	logger.DefaultLogger.SetTracer(nil)
+	err := appendSpans(tracer, spansPath)
+	tracer = nil
+	if err != nil {
+		logger.Warn("%v", err)
+	}
+}
+
+// appendSpans appends the spans of t to the file at path as one line of
+// OTLP JSON. Runs without a flow leave the file alone.
+func appendSpans(t *logger.MemoryTracer, path string) error {
+	if len(t.Spans()) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open spans file: %w", err)
+	}
+	if err := t.WriteOTLP(f, "oauth2cli"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write spans: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/report"
+	"github.com/korjavin/oauth2example/internal/store"
+	"github.com/korjavin/oauth2example/internal/transcript"
+)
+
+var (
+	// recorder records the transcript of the run, if --record or --report
+	// is set
+	recorder *transcript.Recorder
+	// recordPath is the transcript file, if --record is set
+	recordPath string
+	// reportPath is the report file, if --report is set
+	reportPath string
+	// recordBuffer holds the transcript for the report if it isn't written
+	// to a file
+	recordBuffer *bytes.Buffer
+)
+
+// startRecording records a transcript of the command: every log record,
+// whatever the level, every HTTP exchange and the token obtained. The
+// transcript goes to path, if set, and a report is written from it to
+// report, if set, when the run ends.
+func startRecording(path, report string) error {
+	if recorder != nil {
+		return nil
+	}
+
+	if path != "" {
+		rec, err := transcript.Create(path, currentCommand)
+		if err != nil {
+			return err
+		}
+		recorder = rec
+	} else {
+		recordBuffer = &bytes.Buffer{}
+		recorder = transcript.NewRecorder(recordBuffer, currentCommand)
+	}
+	recordPath, reportPath = path, report
+	logger.DefaultLogger.SetRecorder(recorder.Handler())
+	if path != "" {
+		logger.Info("Recording a transcript of the run to %s", path)
+	}
+	return nil
+}
+
+// finishRecording ends the transcript with the outcome of the run and
+// writes the report
+func finishRecording(code int, runErr error) {
+	if recorder == nil {
+		return
+	}
+	logger.DefaultLogger.SetRecorder(nil)
+	err := recorder.Close(code, runErr)
+	recorder = nil
+	if err != nil {
+		logger.Warn("%v", err)
+		return
+	}
+	if reportPath == "" {
+		return
+	}
+
+	var t *transcript.Transcript
+	if recordPath != "" {
+		t, err = transcript.Open(recordPath)
+	} else {
+		t, err = transcript.Read(recordBuffer)
+	}
+	if err == nil {
+		err = writeReport(t, reportPath)
+	}
+	if err != nil {
+		logger.Warn("%v", err)
+	}
+}
+
+// writeReport writes the walkthrough of a transcript to path, as HTML or
+// Markdown by the extension
+func writeReport(t *transcript.Transcript, path string) error {
+	r, err := report.Build(t)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	if err := r.Write(f, report.FormatFor(path)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	logger.Info("Wrote the walkthrough of the run to %s", path)
+	return nil
+}
+
+// recordTransport returns the transport for provider requests, which
+// records them while a transcript is recorded
+func recordTransport() http.RoundTripper {
+	if recorder == nil {
+		return nil
+	}
+	return recorder.Transport(nil)
+}
+
+// recordToken records the metadata of a token the run obtained
+func recordToken(entry *store.Entry) {
+	if recorder == nil {
+		return
+	}
+	info, err := auth.NewTokenInfo(entry.Token, entry.ExpiresAt, false)
+	if err != nil {
+		logger.Warn("Not recording the token: %v", err)
+		return
+	}
+	recorder.RecordToken(info)
+}
+
+// runReplay implements the replay command
+func runReplay(ctx context.Context, args []string) error {
+	opts := options{replaying: true}
+	fs := newFlagSet("replay", &opts)
+	step := fs.Bool("step", false, "Pause before every step until Enter is pressed")
+	serve := fs.String("serve", "", "Serve the recorded responses on this address, e.g. localhost:9000, instead of showing the transcript")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("a transcript is required: oauth2cli replay [flags] <file>")
+	}
+	if err := opts.apply(); err != nil {
+		return err
+	}
+	// Enter is read from stdin
+	if *step {
+		if err := opts.checkStdin("--step"); err != nil {
+			return err
+		}
+	}
+
+	t, err := transcript.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *serve != "" {
+		return serveReplay(ctx, t, *serve)
+	}
+	if opts.report != "" {
+		return writeReport(t, opts.report)
+	}
+	return renderTranscript(t, *step, os.Stdin)
+}
+
+// renderTranscript shows a transcript through the logger, with the
+// original times, optionally pausing before every step
+func renderTranscript(t *transcript.Transcript, step bool, in io.Reader) error {
+	start := t.Start()
+	logger.Info("Replaying the transcript of 'oauth2cli %s' recorded at %s", start.Command, start.Time.Local().Format(time.RFC1123))
+
+	input := bufio.NewReader(in)
+	for _, e := range t.Events[1:] {
+		switch e.Type {
+		case transcript.TypeLog:
+			r, err := e.Record()
+			if err != nil {
+				return err
+			}
+			if _, ok := e.Attrs[logger.StepNameKey]; ok && step {
+				fmt.Fprint(os.Stderr, "Press Enter for the next step...")
+				if _, err := input.ReadString('\n'); err != nil {
+					step = false
+				}
+			}
+			logger.DefaultLogger.Replay(r)
+
+		case transcript.TypeHTTP:
+			x := e.Exchange
+			replayMessage(e.Time.Add(-x.Duration), "HTTP request\n"+x.DumpRequest())
+			if x.Error != "" {
+				replayMessage(e.Time, x.Summary())
+			} else {
+				replayMessage(e.Time, x.Summary()+"\n"+x.DumpResponse())
+			}
+
+		case transcript.TypeToken:
+			if err := printJSON(e.Token); err != nil {
+				return err
+			}
+
+		case transcript.TypeEnd:
+			message := fmt.Sprintf("The run ended with exit code %d", e.ExitCode)
+			if e.Error != "" {
+				message += ": " + e.Error
+			}
+			replayMessage(e.Time, message)
+		}
+	}
+	return nil
+}
+
+// replayMessage shows a message about a recorded event at its original time
+func replayMessage(at time.Time, message string) {
+	logger.DefaultLogger.Replay(slog.NewRecord(at, slog.LevelInfo, message, 0))
+}
+
+// serveReplay serves the recorded responses on addr until the context is
+// cancelled, standing in for the provider
+func serveReplay(ctx context.Context, t *transcript.Transcript, addr string) error {
+	replayer := transcript.NewReplayer(t)
+	paths := replayer.Paths()
+	if len(paths) == 0 {
+		return fmt.Errorf("the transcript has no recorded HTTP responses")
+	}
+	sort.Strings(paths)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: replayer}
+
+	logger.Info("Serving the recorded responses on http://%s; point the endpoint flags, like --token-url, at it", listener.Addr())
+	for _, p := range paths {
+		logger.Info("  %s", p)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(listener) }()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to serve the recorded responses: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/tutor"
+)
+
+// activeTutor pauses the flow, if --tutor is set
+var activeTutor *tutor.Tutor
+
+// startTutor turns on tutor mode: the flow pauses at every step, and the
+// parameters of every request can be inspected and changed on stdin
+func startTutor() {
+	if activeTutor != nil {
+		return
+	}
+
+	activeTutor = tutor.New(os.Stdin, os.Stderr)
+	logger.DefaultLogger.SetStepHook(activeTutor.Step)
+	logger.Info("Tutor mode: the flow pauses at every step and before every request")
+}
+
+// tutorReview returns the hook reviewing the parameters of provider
+// requests in tutor mode
+func tutorReview() func(method, endpoint string, params url.Values) {
+	if activeTutor == nil {
+		return nil
+	}
+	return activeTutor.Review
+}
+
+// finishTutor explains a failed run in tutor mode
+func finishTutor(err error) {
+	if activeTutor == nil {
+		return
+	}
+	logger.DefaultLogger.SetStepHook(nil)
+	activeTutor.Explain(err)
+	activeTutor = nil
+}
@@ -1,3 +1,10 @@
 module github.com/korjavin/oauth2example
 
 go 1.24
+
+require (
+	golang.org/x/crypto v0.36.0
+	golang.org/x/term v0.30.0
+)
+
+require golang.org/x/sys v0.31.0 // indirect
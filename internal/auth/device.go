@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// DeviceCodeGrantType is the grant type of the device flow's token requests
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DefaultDeviceInterval is how long to wait between token requests when the
+// provider doesn't say (RFC 8628, section 3.2)
+const DefaultDeviceInterval = 5 * time.Second
+
+// DeviceAuthorization is the response of the device authorization endpoint
+// (RFC 8628, section 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// UnmarshalJSON also accepts verification_url, which Google returns
+// instead of verification_uri
+func (d *DeviceAuthorization) UnmarshalJSON(data []byte) error {
+	type plain DeviceAuthorization
+	var v struct {
+		plain
+		VerificationURL string `json:"verification_url"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*d = DeviceAuthorization(v.plain)
+	if d.VerificationURI == "" {
+		d.VerificationURI = v.VerificationURL
+	}
+	return nil
+}
+
+// RequestDeviceAuthorization starts the Device Authorization Grant
+// (RFC 8628): the provider returns a user code for the user to enter at
+// the verification URI, and a device code to poll for the tokens with
+func (c *OAuth2Client) RequestDeviceAuthorization(ctx context.Context) (_ *DeviceAuthorization, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.device_authorization")
+	defer func() { span.End(err) }()
+
+	c.log.Step(1, "Request Device Code",
+		"Asking the OAuth2 provider for a code the user can enter on another device")
+
+	if c.config.Endpoint.DeviceAuthURL == "" {
+		return nil, fmt.Errorf("the provider has no device authorization endpoint configured")
+	}
+
+	// Prepare the device authorization request
+	data := url.Values{}
+	data.Set("scope", strings.Join(c.config.Scopes, " "))
+	if c.config.Audience != "" {
+		data.Set("audience", c.config.Audience)
+	}
+
+	c.log.Teach(catalog.TopicDeviceAuthorization)
+
+	body, err := c.postForm(ctx, c.config.Endpoint.DeviceAuthURL, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the response
+	var resp DeviceAuthorization
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse device authorization response: %w", err)
+	}
+	if resp.DeviceCode == "" || resp.UserCode == "" || resp.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response lacks device_code, user_code or verification_uri")
+	}
+
+	// The device code is redeemed for the tokens, so it must not be logged
+	c.addSecret(resp.DeviceCode)
+
+	c.log.Debug("User code: %s", resp.UserCode)
+	c.log.Debug("Verification URI: %s", resp.VerificationURI)
+
+	return &resp, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approves or
+// denies the device authorization, or it expires (RFC 8628, section 3.4)
+func (c *OAuth2Client) PollDeviceToken(ctx context.Context, device *DeviceAuthorization) (_ *TokenResponse, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.device_token_poll", slog.String(logger.GrantTypeKey, DeviceCodeGrantType))
+	defer func() { span.End(err) }()
+
+	c.log.Step(2, "Wait for Authorization",
+		"Polling the token endpoint while the user logs in and approves the requested scopes")
+
+	if device.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(device.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	interval := DefaultDeviceInterval
+	if device.Interval > 0 {
+		interval = time.Duration(device.Interval) * time.Second
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", DeviceCodeGrantType)
+	data.Set("device_code", device.DeviceCode)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("the login was not approved in time: %w", ctx.Err())
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tokenResp, err := c.requestToken(ctx, data)
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			switch providerErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				c.log.Debug("The provider asked to slow down; polling every %s", interval)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.log.Step(3, "Tokens Received",
+			"Successfully received tokens from the OAuth2 provider")
+		c.log.Teach(catalog.TopicOAuth2Tokens)
+
+		return tokenResp, nil
+	}
+}
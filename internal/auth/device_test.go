@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	polls := 0
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/device":
+			if r.PostFormValue("client_id") != "client-123" || r.PostFormValue("scope") != "openid email" {
+				t.Errorf("Unexpected device authorization request: %v", r.PostForm)
+			}
+			// Google calls it verification_url
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"device_code": "dc", "user_code": "ABCD-EFGH", "verification_url": "https://example.com/device",
+				"expires_in": 60, "interval": 1,
+			})
+		case "/token":
+			if r.PostFormValue("grant_type") != DeviceCodeGrantType || r.PostFormValue("device_code") != "dc" {
+				t.Errorf("Unexpected token request: %v", r.PostForm)
+			}
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"authorization_pending"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "expires_in": 3600})
+		}
+	})
+	client.config.Scopes = []string{"openid", "email"}
+	ctx := context.Background()
+
+	device, err := client.RequestDeviceAuthorization(ctx)
+	if err != nil {
+		t.Fatalf("Device authorization failed: %v", err)
+	}
+	if device.UserCode != "ABCD-EFGH" || device.VerificationURI != "https://example.com/device" {
+		t.Errorf("Device authorization is incorrect: %+v", device)
+	}
+
+	token, err := client.PollDeviceToken(ctx, device)
+	if err != nil {
+		t.Fatalf("Polling failed: %v", err)
+	}
+	if token.AccessToken != "at" || polls != 2 {
+		t.Errorf("Unexpected result after %d polls: %+v", polls, token)
+	}
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"access_denied"}`))
+	})
+
+	_, err := client.PollDeviceToken(context.Background(), &DeviceAuthorization{DeviceCode: "dc", Interval: 1})
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Code != "access_denied" {
+		t.Errorf("Expected access_denied, got %v", err)
+	}
+}
+
+func TestDeviceAuthorizationWithoutEndpoint(t *testing.T) {
+	client := newTestProvider(t, nil)
+	client.config.Endpoint.DeviceAuthURL = ""
+
+	if _, err := client.RequestDeviceAuthorization(context.Background()); err == nil {
+		t.Error("Device authorization succeeded without an endpoint")
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// IntrospectionResponse represents the response from the token
+// introspection endpoint (RFC 7662, section 2.2)
+type IntrospectionResponse struct {
+	Active     bool     `json:"active"`
+	Scope      string   `json:"scope,omitempty"`
+	ClientID   string   `json:"client_id,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	TokenType  string   `json:"token_type,omitempty"`
+	Expiration int64    `json:"exp,omitempty"`
+	IssuedAt   int64    `json:"iat,omitempty"`
+	NotBefore  int64    `json:"nbf,omitempty"`
+	Subject    string   `json:"sub,omitempty"`
+	Audience   Audience `json:"aud,omitempty"`
+	Issuer     string   `json:"iss,omitempty"`
+	JWTID      string   `json:"jti,omitempty"`
+
+	// Raw contains every member of the response, including non-standard ones
+	Raw map[string]interface{} `json:"-"`
+}
+
+// IntrospectToken asks the provider whether a token is active and what it
+// grants (RFC 7662)
+func (c *OAuth2Client) IntrospectToken(ctx context.Context, token string, tokenTypeHint string) (_ *IntrospectionResponse, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.token_introspection")
+	defer func() { span.End(err) }()
+
+	c.log.Step(1, "Introspect Token",
+		"Asking the OAuth2 provider whether the token is active")
+
+	if c.config.Endpoint.IntrospectionURL == "" {
+		return nil, fmt.Errorf("the provider has no introspection endpoint configured")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("token is empty")
+	}
+
+	// Prepare the introspection request
+	data := url.Values{}
+	data.Set("token", token)
+	if tokenTypeHint != "" {
+		data.Set("token_type_hint", tokenTypeHint)
+	}
+
+	c.log.Teach(catalog.TopicTokenIntrospection)
+
+	body, err := c.postForm(ctx, c.config.Endpoint.IntrospectionURL, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the response
+	var resp IntrospectionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	if err := json.Unmarshal(body, &resp.Raw); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+
+	return &resp, nil
+}
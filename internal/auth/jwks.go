@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GoogleJWKSURL is the Google endpoint publishing the keys that sign ID tokens
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// KeySet looks up the public keys used to verify token signatures
+type KeySet interface {
+	PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeySet is a fixed set of public keys indexed by key ID
+type StaticKeySet map[string]crypto.PublicKey
+
+// PublicKey returns the key with the given key ID
+func (s StaticKeySet) PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey converts the JWK to an RSA or ECDSA public key
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLDecode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64URLDecode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %q", k.Crv)
+		}
+		x, err := base64URLDecode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64URLDecode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// RemoteKeySet fetches keys from a JWKS endpoint and caches them. Unknown
+// key IDs trigger a refetch, at most once per minute, to pick up rotated keys.
+type RemoteKeySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewRemoteKeySet creates a key set backed by the given JWKS URL
+func NewRemoteKeySet(jwksURL string) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:        jwksURL,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// PublicKey returns the key with the given key ID, fetching the JWKS if needed
+func (s *RemoteKeySet) PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Don't hammer the provider with requests for unknown key IDs
+	if time.Since(s.fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := s.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch downloads the JWKS document and replaces the cached keys
+func (s *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("JWKS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var doc struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	// Skip keys we can't use instead of failing the whole set
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	s.keys = keys
+	s.fetched = time.Now()
+	return nil
+}
+
+// JWTHeader is the JOSE header of a signed JWT
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// VerifyJWT checks the signature of a compact JWT and returns its header
+// and decoded payload. RS256/384/512, PS256/384/512 and ES256/384/512 are
+// supported; unsigned tokens are always rejected.
+func VerifyJWT(ctx context.Context, token string, keys KeySet) (JWTHeader, []byte, error) {
+	var header JWTHeader
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, fmt.Errorf("invalid JWT format: expected 3 parts, got %d", len(parts))
+	}
+
+	rawHeader, err := base64URLDecode(parts[0])
+	if err != nil {
+		return header, nil, fmt.Errorf("failed to decode JWT header: %w", err)
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return header, nil, fmt.Errorf("failed to parse JWT header: %w", err)
+	}
+
+	signature, err := base64URLDecode(parts[2])
+	if err != nil {
+		return header, nil, fmt.Errorf("failed to decode JWT signature: %w", err)
+	}
+
+	key, err := keys.PublicKey(ctx, header.Kid)
+	if err != nil {
+		return header, nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := verifySignature(header.Alg, key, signed, signature); err != nil {
+		return header, nil, err
+	}
+
+	payload, err := base64URLDecode(parts[1])
+	if err != nil {
+		return header, nil, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+
+	return header, payload, nil
+}
+
+// verifySignature verifies a JWS signature with the given algorithm
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid JWT signature: %w", err)
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		// JWS encodes ECDSA signatures as the fixed-size concatenation of r and s
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid JWT signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// BackChannelLogoutEvent is the event type that identifies a logout token
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// EndSessionRequest contains the parameters of an RP-initiated logout request
+// (OpenID Connect RP-Initiated Logout 1.0, section 2)
+type EndSessionRequest struct {
+	IDTokenHint           string
+	PostLogoutRedirectURI string
+	State                 string
+	ClientID              string
+}
+
+// BuildEndSessionURL returns the URL of the provider's end_session_endpoint
+// that the user has to visit to log out
+func BuildEndSessionURL(endpoint string, req EndSessionRequest) (string, error) {
+	logger.Step(1, "Generate Logout URL",
+		"Creating the URL that ends the user's session at the OpenID provider")
+
+	if endpoint == "" {
+		return "", fmt.Errorf("the provider has no end_session_endpoint configured")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse end session endpoint: %w", err)
+	}
+
+	// Add query parameters, keeping any the endpoint already has
+	q := u.Query()
+	if req.IDTokenHint != "" {
+		q.Set("id_token_hint", req.IDTokenHint)
+	}
+	if req.ClientID != "" {
+		q.Set("client_id", req.ClientID)
+	}
+	if req.PostLogoutRedirectURI != "" {
+		q.Set("post_logout_redirect_uri", req.PostLogoutRedirectURI)
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+
+	logger.Teach(catalog.TopicRPInitiatedLogout)
+
+	return u.String(), nil
+}
+
+// Audience is the aud claim of a JWT, which may be a string or an array
+type Audience []string
+
+// UnmarshalJSON accepts both the string and the array form
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*a = nil
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = Audience(multiple)
+	return nil
+}
+
+// MarshalJSON writes a single audience as a string, as most providers do
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains reports whether the audience includes the given value
+func (a Audience) Contains(value string) bool {
+	for _, aud := range a {
+		if aud == value {
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutTokenClaims represents the claims in a back-channel logout token
+type LogoutTokenClaims struct {
+	Issuer     string                     `json:"iss"`
+	Subject    string                     `json:"sub,omitempty"`
+	Audience   Audience                   `json:"aud"`
+	IssuedAt   int64                      `json:"iat"`
+	Expiration int64                      `json:"exp,omitempty"`
+	JWTID      string                     `json:"jti"`
+	SessionID  string                     `json:"sid,omitempty"`
+	Events     map[string]json.RawMessage `json:"events"`
+}
+
+// LogoutTokenValidator validates back-channel logout tokens
+// (OpenID Connect Back-Channel Logout 1.0, section 2.6)
+type LogoutTokenValidator struct {
+	Issuer   string
+	ClientID string
+	Keys     KeySet
+
+	// MaxAge is how old a logout token may be (default: 5 minutes)
+	MaxAge time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Validate verifies the signature and claims of a logout token
+func (v *LogoutTokenValidator) Validate(ctx context.Context, token string) (*LogoutTokenClaims, error) {
+	header, payload, err := VerifyJWT(ctx, token, v.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// The typ header is optional, but if present it must identify a logout token
+	if header.Typ != "" && header.Typ != "logout+jwt" && header.Typ != "JWT" {
+		return nil, fmt.Errorf("unexpected logout token type %q", header.Typ)
+	}
+
+	var claims LogoutTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse logout token claims: %w", err)
+	}
+
+	// A nonce would make the token usable as an ID token, so it is forbidden
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse logout token claims: %w", err)
+	}
+	if _, ok := raw["nonce"]; ok {
+		return nil, fmt.Errorf("logout token must not contain a nonce")
+	}
+
+	if claims.Issuer != v.Issuer {
+		return nil, fmt.Errorf("logout token issuer %q does not match %q", claims.Issuer, v.Issuer)
+	}
+	if !claims.Audience.Contains(v.ClientID) {
+		return nil, fmt.Errorf("logout token audience does not include the client ID")
+	}
+
+	// Check the token age and expiration
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = 5 * time.Minute
+	}
+	now := time.Now()
+	if claims.IssuedAt == 0 || now.Sub(time.Unix(claims.IssuedAt, 0)) > maxAge {
+		return nil, fmt.Errorf("logout token is too old or has no iat")
+	}
+	if claims.Expiration != 0 && claims.Expiration < now.Unix() {
+		return nil, fmt.Errorf("logout token is expired")
+	}
+
+	// The events claim must contain the back-channel logout event as a JSON object
+	event, ok := claims.Events[BackChannelLogoutEvent]
+	if !ok {
+		return nil, fmt.Errorf("logout token has no back-channel logout event")
+	}
+	var eventObj map[string]json.RawMessage
+	if err := json.Unmarshal(event, &eventObj); err != nil {
+		return nil, fmt.Errorf("back-channel logout event must be a JSON object")
+	}
+
+	if claims.SessionID == "" && claims.Subject == "" {
+		return nil, fmt.Errorf("logout token must contain a sid or sub claim")
+	}
+
+	// Reject replayed tokens
+	if claims.JWTID == "" {
+		return nil, fmt.Errorf("logout token has no jti")
+	}
+	if err := v.markSeen(claims.JWTID, now.Add(maxAge)); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// markSeen records a token ID until it expires and rejects repeated IDs
+func (v *LogoutTokenValidator) markSeen(jti string, until time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for id, exp := range v.seen {
+		if exp.Before(now) {
+			delete(v.seen, id)
+		}
+	}
+
+	if _, ok := v.seen[jti]; ok {
+		return fmt.Errorf("logout token %q was already used", jti)
+	}
+	v.seen[jti] = until
+	return nil
+}
+
+// Session is a login session established with the OpenID provider
+type Session struct {
+	Issuer    string
+	Subject   string
+	SessionID string
+	Token     *TokenResponse
+	CreatedAt time.Time
+}
+
+// SessionCache keeps login sessions in memory so they can be ended by a
+// back-channel or front-channel logout
+type SessionCache struct {
+	mu       sync.Mutex
+	sessions []Session
+}
+
+// NewSessionCache creates an empty session cache
+func NewSessionCache() *SessionCache {
+	return &SessionCache{}
+}
+
+// Add records a session
+func (c *SessionCache) Add(session Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = append(c.sessions, session)
+}
+
+// List returns a copy of the cached sessions
+func (c *SessionCache) List() []Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Session(nil), c.sessions...)
+}
+
+// PurgeSessions removes the sessions of the issuer matching sid, or sub if
+// sid is empty, and returns how many were removed
+func (c *SessionCache) PurgeSessions(issuer, sid, sub string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := c.sessions[:0]
+	purged := 0
+	for _, s := range c.sessions {
+		if MatchesLogout(s.Issuer, s.SessionID, s.Subject, issuer, sid, sub) {
+			purged++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	c.sessions = kept
+
+	return purged, nil
+}
+
+// MatchesLogout reports whether a session with the given issuer, sid and sub
+// is ended by a logout for issuer, sid and sub. The sid is preferred; the sub
+// is used when the logout names no session, and both must match if both are given.
+func MatchesLogout(sessIssuer, sessSID, sessSub, issuer, sid, sub string) bool {
+	if sessIssuer != issuer {
+		return false
+	}
+	if sid != "" && sessSID != sid {
+		return false
+	}
+	if sub != "" && sessSub != sub {
+		return false
+	}
+	return sid != "" || sub != ""
+}
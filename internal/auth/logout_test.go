@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// signTestJWT creates an ES256 JWT signed with key
+func signTestJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": kid, "typ": "logout+jwt"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to encode claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// logoutClaims returns valid logout token claims
+func logoutClaims(jti string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    "https://issuer.example",
+		"aud":    []string{"client-123"},
+		"iat":    time.Now().Unix(),
+		"jti":    jti,
+		"sid":    "session-1",
+		"events": map[string]interface{}{BackChannelLogoutEvent: map[string]interface{}{}},
+	}
+}
+
+func TestBuildEndSessionURL(t *testing.T) {
+	endSessionURL, err := BuildEndSessionURL("https://issuer.example/logout?tenant=a", EndSessionRequest{
+		IDTokenHint:           "id-token",
+		PostLogoutRedirectURI: "http://localhost:8080/oauth/logout",
+		State:                 "xyz",
+	})
+	if err != nil {
+		t.Fatalf("Failed to build end session URL: %v", err)
+	}
+
+	u, err := url.Parse(endSessionURL)
+	if err != nil {
+		t.Fatalf("Failed to parse end session URL: %v", err)
+	}
+
+	// Check that existing parameters are kept and the new ones are added
+	q := u.Query()
+	want := map[string]string{
+		"tenant":                   "a",
+		"id_token_hint":            "id-token",
+		"post_logout_redirect_uri": "http://localhost:8080/oauth/logout",
+		"state":                    "xyz",
+	}
+	for name, value := range want {
+		if got := q.Get(name); got != value {
+			t.Errorf("Parameter %s is incorrect: got %q, want %q", name, got, value)
+		}
+	}
+
+	// An endpoint is required
+	if _, err := BuildEndSessionURL("", EndSessionRequest{}); err == nil {
+		t.Error("Expected an error for a missing end session endpoint")
+	}
+}
+
+func TestLogoutTokenValidator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	v := &LogoutTokenValidator{
+		Issuer:   "https://issuer.example",
+		ClientID: "client-123",
+		Keys:     StaticKeySet{"k1": &key.PublicKey},
+	}
+	ctx := context.Background()
+
+	// A valid token is accepted
+	claims, err := v.Validate(ctx, signTestJWT(t, key, "k1", logoutClaims("jti-1")))
+	if err != nil {
+		t.Fatalf("Valid logout token rejected: %v", err)
+	}
+	if claims.SessionID != "session-1" {
+		t.Errorf("Session ID is incorrect: got %s, want session-1", claims.SessionID)
+	}
+
+	// Replaying the same token is rejected
+	if _, err := v.Validate(ctx, signTestJWT(t, key, "k1", logoutClaims("jti-1"))); err == nil {
+		t.Error("Replayed logout token was accepted")
+	}
+
+	// A nonce is forbidden
+	withNonce := logoutClaims("jti-2")
+	withNonce["nonce"] = "n"
+	if _, err := v.Validate(ctx, signTestJWT(t, key, "k1", withNonce)); err == nil {
+		t.Error("Logout token with a nonce was accepted")
+	}
+
+	// The events claim is required
+	noEvents := logoutClaims("jti-3")
+	delete(noEvents, "events")
+	if _, err := v.Validate(ctx, signTestJWT(t, key, "k1", noEvents)); err == nil {
+		t.Error("Logout token without events was accepted")
+	}
+
+	// Either sid or sub is required
+	noSubject := logoutClaims("jti-4")
+	delete(noSubject, "sid")
+	if _, err := v.Validate(ctx, signTestJWT(t, key, "k1", noSubject)); err == nil {
+		t.Error("Logout token without sid and sub was accepted")
+	}
+
+	// A token signed by another key is rejected
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := v.Validate(ctx, signTestJWT(t, otherKey, "k1", logoutClaims("jti-5"))); err == nil {
+		t.Error("Logout token with an invalid signature was accepted")
+	}
+}
+
+func TestSessionCachePurge(t *testing.T) {
+	cache := NewSessionCache()
+	cache.Add(Session{Issuer: "iss", Subject: "alice", SessionID: "s1"})
+	cache.Add(Session{Issuer: "iss", Subject: "alice", SessionID: "s2"})
+	cache.Add(Session{Issuer: "iss", Subject: "bob", SessionID: "s3"})
+
+	// Purge a single session by sid
+	if n, _ := cache.PurgeSessions("iss", "s1", ""); n != 1 {
+		t.Errorf("Purged session count is incorrect: got %d, want 1", n)
+	}
+
+	// Purge all remaining sessions of a subject
+	if n, _ := cache.PurgeSessions("iss", "", "alice"); n != 1 {
+		t.Errorf("Purged session count is incorrect: got %d, want 1", n)
+	}
+
+	if sessions := cache.List(); len(sessions) != 1 || sessions[0].Subject != "bob" {
+		t.Errorf("Unexpected remaining sessions: %+v", sessions)
+	}
+}
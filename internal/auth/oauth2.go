@@ -7,11 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/korjavin/oauth2example/internal/catalog"
 	"github.com/korjavin/oauth2example/internal/logger"
 )
 
@@ -22,10 +25,59 @@ const (
 	// GoogleTokenURL is the Google OAuth2 token endpoint
 	GoogleTokenURL = "https://oauth2.googleapis.com/token"
 
+	// GoogleDeviceAuthURL is the Google OAuth2 device authorization endpoint
+	GoogleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+	// GoogleRevocationURL is the Google OAuth2 token revocation endpoint
+	GoogleRevocationURL = "https://oauth2.googleapis.com/revoke"
+
+	// GoogleUserInfoURL is the Google OpenID Connect userinfo endpoint
+	GoogleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+	// GoogleIssuer is the issuer of Google ID tokens
+	GoogleIssuer = "https://accounts.google.com"
+
 	// DefaultTimeout is the default timeout for HTTP requests
 	DefaultTimeout = 30 * time.Second
 )
 
+// Endpoint contains the URLs of an OAuth2 provider. Optional endpoints
+// the provider doesn't offer are left empty.
+type Endpoint struct {
+	Issuer           string
+	AuthURL          string
+	TokenURL         string
+	DeviceAuthURL    string
+	RevocationURL    string
+	IntrospectionURL string
+	UserInfoURL      string
+	EndSessionURL    string
+	JWKSURL          string
+}
+
+// Provider returns the host of the provider, from the issuer or else the
+// token or authorization endpoint, to tell providers apart in traces
+func (e Endpoint) Provider() string {
+	for _, raw := range []string{e.Issuer, e.TokenURL, e.AuthURL} {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	return ""
+}
+
+// GoogleEndpoint contains the endpoints of Google's OAuth2 provider.
+// Google has neither a token introspection nor an end session endpoint.
+var GoogleEndpoint = Endpoint{
+	Issuer:        GoogleIssuer,
+	AuthURL:       GoogleAuthURL,
+	TokenURL:      GoogleTokenURL,
+	DeviceAuthURL: GoogleDeviceAuthURL,
+	RevocationURL: GoogleRevocationURL,
+	UserInfoURL:   GoogleUserInfoURL,
+	JWKSURL:       GoogleJWKSURL,
+}
+
 // OAuth2Config contains the configuration for the OAuth2 client
 type OAuth2Config struct {
 	ClientID     string
@@ -33,6 +85,65 @@ type OAuth2Config struct {
 	RedirectURI  string
 	Scopes       []string
 	Audience     string
+
+	// LoginHint tells the provider which account to log in with (OIDC Core, section 3.1.2.1)
+	LoginHint string
+	// Prompt asks the provider to re-authenticate or show its account chooser,
+	// e.g. "login" or "select_account"
+	Prompt string
+
+	// Endpoint defaults to GoogleEndpoint if neither AuthURL nor TokenURL is set
+	Endpoint Endpoint
+
+	// Trace names the endpoints whose requests and responses are logged in
+	// full, from TraceEndpoints, or TraceAll
+	Trace []string
+	// Transport sends the requests to the provider; nil means
+	// http.DefaultTransport
+	Transport http.RoundTripper
+	// Review, if set, is called with the parameters of the authorization
+	// URL and of every form posted to the provider, and may change them
+	// before they are sent, as tutor mode does
+	Review func(method, endpoint string, params url.Values)
+}
+
+// ProviderError is an error response from one of the provider's endpoints
+// (RFC 6749, section 5.2)
+type ProviderError struct {
+	StatusCode  int
+	Code        string
+	Description string
+	Body        []byte
+}
+
+// Error implements the error interface
+func (e *ProviderError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("provider returned %s (status %d): %s", e.Code, e.StatusCode, e.Description)
+	}
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// ErrorCode returns the OAuth error code, for the spans of failed requests
+func (e *ProviderError) ErrorCode() string {
+	return e.Code
+}
+
+// newProviderError creates a ProviderError from an error response body
+func newProviderError(statusCode int, body []byte) *ProviderError {
+	var errResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	// Not every provider returns JSON errors, so a parse failure is fine
+	json.Unmarshal(body, &errResp)
+
+	return &ProviderError{
+		StatusCode:  statusCode,
+		Code:        errResp.Error,
+		Description: errResp.ErrorDescription,
+		Body:        body,
+	}
 }
 
 // TokenResponse represents the response from the token endpoint
@@ -52,6 +163,12 @@ type OAuth2Client struct {
 	verifier   PKCECodeVerifier
 	challenge  PKCECodeChallenge
 	state      string
+	flowID     string
+	log        *logger.Logger
+
+	// secrets are the values only this flow needs masked, which Close drops
+	mu      sync.Mutex
+	secrets []string
 }
 
 // NewOAuth2Client creates a new OAuth2 client
@@ -65,32 +182,87 @@ func NewOAuth2Client(config OAuth2Config) (*OAuth2Client, error) {
 	challenge := verifier.CreateCodeChallenge()
 
 	// Generate random state parameter
+	state, err := GenerateState()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Endpoint.AuthURL == "" && config.Endpoint.TokenURL == "" {
+		config.Endpoint = GoogleEndpoint
+	}
+
+	// Every line of this flow carries its ID, so concurrent flows can be
+	// told apart in the log
+	flowID := logger.NewFlowID()
+	log := logger.With(logger.FlowKey, flowID)
+
+	httpClient := &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: config.Transport,
+	}
+	if len(config.Trace) > 0 {
+		transport, err := newTracingTransport(config.Transport, config.Endpoint, config.Trace, log)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport = transport
+	}
+
+	client := &OAuth2Client{
+		config:     config,
+		httpClient: httpClient,
+		verifier:   verifier,
+		challenge:  challenge,
+		state:      state,
+		flowID:     flowID,
+		log:        log,
+	}
+
+	// The verifier proves possession of the code, so it must not be logged
+	client.addSecret(string(verifier))
+
+	return client, nil
+}
+
+// addSecret masks a value in the logs until the flow is closed
+func (c *OAuth2Client) addSecret(value string) {
+	logger.AddSecret(value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.secrets = append(c.secrets, value)
+}
+
+// Close ends the flow, so its own secrets, such as the PKCE verifier, are
+// no longer looked for in every log line. The tokens it received stay
+// masked, since they outlive the flow.
+func (c *OAuth2Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, secret := range c.secrets {
+		logger.RemoveSecret(secret)
+	}
+	c.secrets = nil
+}
+
+// GenerateState creates a random value for the state parameter
+func GenerateState() (string, error) {
 	stateBytes := make([]byte, 16)
 	if _, err := rand.Read(stateBytes); err != nil {
-		return nil, fmt.Errorf("failed to generate state parameter: %w", err)
+		return "", fmt.Errorf("failed to generate state parameter: %w", err)
 	}
-	state := base64.URLEncoding.EncodeToString(stateBytes)
-
-	return &OAuth2Client{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: DefaultTimeout,
-		},
-		verifier:  verifier,
-		challenge: challenge,
-		state:     state,
-	}, nil
+	return base64.URLEncoding.EncodeToString(stateBytes), nil
 }
 
 // GetAuthorizationURL returns the URL to redirect the user to for authorization
 func (c *OAuth2Client) GetAuthorizationURL() string {
-	logger.Step(1, "Generate Authorization URL",
+	c.log.Step(1, "Generate Authorization URL",
 		"Creating the URL that the user will visit to authenticate and authorize the application")
 
 	// Build the authorization URL
-	u, err := url.Parse(GoogleAuthURL)
+	u, err := url.Parse(c.config.Endpoint.AuthURL)
 	if err != nil {
-		logger.Error("Failed to parse Google auth URL: %v", err)
+		c.log.Error("Failed to parse auth URL: %v", err)
 		return ""
 	}
 
@@ -109,29 +281,34 @@ func (c *OAuth2Client) GetAuthorizationURL() string {
 		q.Set("audience", c.config.Audience)
 	}
 
+	// Add the account selection parameters if specified
+	if c.config.LoginHint != "" {
+		q.Set("login_hint", c.config.LoginHint)
+	}
+	if c.config.Prompt != "" {
+		q.Set("prompt", c.config.Prompt)
+	}
+
+	if c.config.Review != nil {
+		c.config.Review(http.MethodGet, c.config.Endpoint.AuthURL, q)
+	}
 	u.RawQuery = q.Encode()
 
 	authURL := u.String()
 
-	logger.Educational("Authorization URL",
-		"The authorization URL contains several important parameters:\n\n"+
-			"- client_id: Identifies your application to the OAuth2 provider\n"+
-			"- redirect_uri: Where the provider will send the user after authorization\n"+
-			"- response_type: 'code' indicates we're using the authorization code flow\n"+
-			"- scope: The permissions your application is requesting\n"+
-			"- state: A random value to prevent CSRF attacks\n"+
-			"- code_challenge: The PKCE code challenge derived from the code verifier\n"+
-			"- code_challenge_method: The method used to create the code challenge (S256)\n"+
-			"- audience (optional): The intended recipient of the token (for JWT tokens)")
+	c.log.Teach(catalog.TopicAuthorizationURL)
 
-	logger.Debug("Authorization URL: %s", authURL)
+	c.log.Debug("Authorization URL: %s", authURL)
 
 	return authURL
 }
 
 // ExchangeCodeForToken exchanges the authorization code for tokens
-func (c *OAuth2Client) ExchangeCodeForToken(ctx context.Context, code string) (*TokenResponse, error) {
-	logger.Step(7, "Exchange Code for Token",
+func (c *OAuth2Client) ExchangeCodeForToken(ctx context.Context, code string) (_ *TokenResponse, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.token_exchange", slog.String(logger.GrantTypeKey, "authorization_code"))
+	defer func() { span.End(err) }()
+
+	c.log.Step(7, "Exchange Code for Token",
 		"Exchanging the authorization code for access and ID tokens")
 
 	// Verify that the code is not empty
@@ -141,31 +318,69 @@ func (c *OAuth2Client) ExchangeCodeForToken(ctx context.Context, code string) (*
 
 	// Prepare the token request
 	data := url.Values{}
-	data.Set("client_id", c.config.ClientID)
-	data.Set("client_secret", c.config.ClientSecret)
 	data.Set("code", code)
 	data.Set("code_verifier", string(c.verifier))
 	data.Set("grant_type", "authorization_code")
 	data.Set("redirect_uri", c.config.RedirectURI)
 
-	logger.Educational("Token Exchange",
-		"The token exchange request includes:\n\n"+
-			"- client_id: Identifies your application\n"+
-			"- client_secret: Authenticates your application to the OAuth2 provider\n"+
-			"- code: The authorization code received from the provider\n"+
-			"- code_verifier: The original PKCE verifier that corresponds to the challenge\n"+
-			"- grant_type: 'authorization_code' indicates we're exchanging a code for tokens\n"+
-			"- redirect_uri: Must match the redirect URI used in the authorization request")
+	c.log.Teach(catalog.TopicTokenExchange)
+
+	tokenResp, err := c.requestToken(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log.Step(8, "Tokens Received",
+		"Successfully received tokens from the OAuth2 provider")
+
+	c.log.Teach(catalog.TopicOAuth2Tokens)
+
+	return tokenResp, nil
+}
+
+// requestToken sends a request to the token endpoint and parses the response
+func (c *OAuth2Client) requestToken(ctx context.Context, data url.Values) (*TokenResponse, error) {
+	body, err := c.postForm(ctx, c.config.Endpoint.TokenURL, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the response
+	var tokenResp TokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	// Mask the tokens wherever they are logged later, not only where they
+	// look like tokens. They are used after the flow, so Close keeps them.
+	logger.AddSecret(tokenResp.AccessToken)
+	logger.AddSecret(tokenResp.RefreshToken)
+	logger.AddSecret(tokenResp.IDToken)
+
+	return &tokenResp, nil
+}
+
+// postForm sends an authenticated form POST to one of the provider's
+// endpoints and returns the body of a successful response
+func (c *OAuth2Client) postForm(ctx context.Context, endpoint string, data url.Values) ([]byte, error) {
+	// Authenticate the client. Public clients using PKCE have no secret.
+	data.Set("client_id", c.config.ClientID)
+	if c.config.ClientSecret != "" {
+		data.Set("client_secret", c.config.ClientSecret)
+	}
+	if c.config.Review != nil {
+		c.config.Review(http.MethodPost, endpoint, data)
+	}
 
 	// Create the HTTP request
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		GoogleTokenURL,
+		endpoint,
 		strings.NewReader(data.Encode()),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create token request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
@@ -173,43 +388,25 @@ func (c *OAuth2Client) ExchangeCodeForToken(ctx context.Context, code string) (*
 	req.Header.Set("Accept", "application/json")
 
 	// Send the request
-	logger.Debug("Sending token request to %s", GoogleTokenURL)
+	c.log.Debug("Sending request to %s", endpoint)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("token request failed: %w", err)
+		return nil, fmt.Errorf("request to %s failed: %w", endpoint, err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read token response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	// Check for error response
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, body)
-	}
-
-	// Parse the response
-	var tokenResp TokenResponse
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return nil, fmt.Errorf("failed to parse token response: %w", err)
+		return nil, newProviderError(resp.StatusCode, body)
 	}
 
-	logger.Step(8, "Tokens Received",
-		"Successfully received tokens from the OAuth2 provider")
-
-	logger.Educational("OAuth2 Tokens",
-		"The OAuth2 provider returns several tokens:\n\n"+
-			"- access_token: Used to access protected resources on behalf of the user\n"+
-			"- token_type: Usually 'Bearer', indicates how to use the access token\n"+
-			"- expires_in: The lifetime of the access token in seconds\n"+
-			"- refresh_token: Used to obtain new access tokens when they expire\n"+
-			"- id_token: A JWT containing claims about the user (OpenID Connect)\n"+
-			"- scope: The scopes that were actually granted (may differ from requested)")
-
-	return &tokenResp, nil
+	return body, nil
 }
 
 // VerifyState verifies that the state parameter matches
@@ -220,6 +417,33 @@ func (c *OAuth2Client) VerifyState(state string) bool {
 	return state == c.state
 }
 
+// FlowID returns the ID tagging the log lines of this flow
+func (c *OAuth2Client) FlowID() string {
+	return c.flowID
+}
+
+// Logger returns the logger of this flow, for the code taking part in it
+func (c *OAuth2Client) Logger() *logger.Logger {
+	return c.log
+}
+
+// StartSpan starts a span of this flow, as a child of the span in ctx if
+// there is one, with the flow ID and the provider as attributes
+func (c *OAuth2Client) StartSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, logger.Span) {
+	attrs = append([]slog.Attr{slog.String(logger.ProviderKey, c.config.Endpoint.Provider())}, attrs...)
+	return c.log.StartSpan(ctx, name, attrs...)
+}
+
+// GetEndpoint returns the provider endpoints used by the client
+func (c *OAuth2Client) GetEndpoint() Endpoint {
+	return c.config.Endpoint
+}
+
+// GetState returns the state parameter sent in the authorization request
+func (c *OAuth2Client) GetState() string {
+	return c.state
+}
+
 // GetCodeVerifier returns the PKCE code verifier
 func (c *OAuth2Client) GetCodeVerifier() string {
 	return string(c.verifier)
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// newTestProvider starts a fake OAuth2 provider and returns a client using it
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *OAuth2Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client, err := NewOAuth2Client(OAuth2Config{
+		ClientID:    "client-123",
+		RedirectURI: "http://localhost:8080/oauth/callback",
+		Endpoint: Endpoint{
+			AuthURL:          srv.URL + "/auth",
+			TokenURL:         srv.URL + "/token",
+			DeviceAuthURL:    srv.URL + "/device",
+			RevocationURL:    srv.URL + "/revoke",
+			IntrospectionURL: srv.URL + "/introspect",
+			UserInfoURL:      srv.URL + "/userinfo",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestExchangeCodeForToken(t *testing.T) {
+	var client *OAuth2Client
+	client = newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		// Check the PKCE verifier and that no secret is sent for a public client
+		if r.PostFormValue("code_verifier") != client.GetCodeVerifier() {
+			t.Error("Token request does not contain the code verifier")
+		}
+		if _, ok := r.PostForm["client_secret"]; ok {
+			t.Error("Token request contains a client secret for a public client")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at", "token_type": "Bearer", "expires_in": 3600,
+		})
+	})
+
+	token, err := client.ExchangeCodeForToken(context.Background(), "code")
+	if err != nil {
+		t.Fatalf("Token exchange failed: %v", err)
+	}
+	if token.AccessToken != "at" {
+		t.Errorf("Access token is incorrect: got %s, want at", token.AccessToken)
+	}
+}
+
+func TestReview(t *testing.T) {
+	var client *OAuth2Client
+	client = newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code_verifier") != "tampered" || r.PostFormValue("client_id") != "client-123" {
+			t.Errorf("Token request is not the reviewed one: %v", r.PostForm)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at"})
+	})
+
+	var reviewed []string
+	client.config.Review = func(method, endpoint string, params url.Values) {
+		reviewed = append(reviewed, method+" "+endpoint)
+		if params.Has("code_verifier") {
+			params.Set("code_verifier", "tampered")
+		} else {
+			params.Set("state", "tampered")
+		}
+	}
+
+	authURL, err := url.Parse(client.GetAuthorizationURL())
+	if err != nil {
+		t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	if got := authURL.Query().Get("state"); got != "tampered" {
+		t.Errorf("State is incorrect: got %q, want %q", got, "tampered")
+	}
+	if _, err := client.ExchangeCodeForToken(context.Background(), "code"); err != nil {
+		t.Fatalf("Token exchange failed: %v", err)
+	}
+
+	endpoint := client.GetEndpoint()
+	if len(reviewed) != 2 || reviewed[0] != "GET "+endpoint.AuthURL || reviewed[1] != "POST "+endpoint.TokenURL {
+		t.Errorf("Reviewed requests are incorrect: %q", reviewed)
+	}
+}
+
+func TestProviderError(t *testing.T) {
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Bad Request"}`))
+	})
+
+	_, err := client.ExchangeCodeForToken(context.Background(), "code")
+
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) {
+		t.Fatalf("Expected a ProviderError, got %v", err)
+	}
+	if providerErr.Code != "invalid_grant" || providerErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unexpected provider error: %+v", providerErr)
+	}
+}
+
+func TestRefreshTokenKeepsRefreshToken(t *testing.T) {
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("refresh_token") != "rt" {
+			t.Errorf("Unexpected refresh request: %v", r.PostForm)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "new-at", "expires_in": 3600})
+	})
+
+	token, err := client.RefreshToken(context.Background(), "rt")
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if token.AccessToken != "new-at" || token.RefreshToken != "rt" {
+		t.Errorf("Unexpected refreshed token: %+v", token)
+	}
+}
+
+func TestRevokeIntrospectAndUserInfo(t *testing.T) {
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/revoke":
+			if r.PostFormValue("token") != "at" {
+				t.Errorf("Unexpected revocation request: %v", r.PostForm)
+			}
+		case "/introspect":
+			w.Write([]byte(`{"active":true,"scope":"openid","aud":["a","b"],"custom":"x"}`))
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer at" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"sub":"alice"}`))
+		}
+	})
+	ctx := context.Background()
+
+	if err := client.RevokeToken(ctx, "at", "access_token"); err != nil {
+		t.Errorf("Revocation failed: %v", err)
+	}
+
+	resp, err := client.IntrospectToken(ctx, "at", "")
+	if err != nil {
+		t.Fatalf("Introspection failed: %v", err)
+	}
+	if !resp.Active || !resp.Audience.Contains("b") || resp.Raw["custom"] != "x" {
+		t.Errorf("Unexpected introspection response: %+v", resp)
+	}
+
+	claims, err := client.GetUserInfo(ctx, "at")
+	if err != nil {
+		t.Fatalf("UserInfo request failed: %v", err)
+	}
+	if claims["sub"] != "alice" {
+		t.Errorf("Unexpected userinfo claims: %v", claims)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	tracer := logger.NewMemoryTracer()
+	logger.DefaultLogger.SetTracer(tracer)
+	t.Cleanup(func() { logger.DefaultLogger.SetTracer(nil) })
+
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	})
+
+	ctx, flow := client.StartSpan(context.Background(), "flow")
+	if _, err := client.RefreshToken(ctx, "rt", "openid", "email"); err == nil {
+		t.Fatal("Refresh with a bad token succeeded")
+	}
+	flow.End(nil)
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	refresh, root := spans[0], spans[1]
+	if refresh.Name != "oauth2.token_refresh" || refresh.ParentID != root.SpanID || refresh.TraceID != root.TraceID {
+		t.Errorf("Refresh span is not a child of the flow: %+v, %+v", refresh, root)
+	}
+	if !refresh.Failed || root.Failed {
+		t.Errorf("Span status is incorrect: refresh %v, flow %v", refresh.Failed, root.Failed)
+	}
+
+	attrs := make(map[string]string)
+	for _, a := range refresh.Attrs {
+		attrs[a.Key] = a.Value.String()
+	}
+	tokenURL, _ := url.Parse(client.GetEndpoint().TokenURL)
+	want := map[string]string{
+		logger.FlowKey:       client.FlowID(),
+		logger.ProviderKey:   tokenURL.Host,
+		logger.GrantTypeKey:  "refresh_token",
+		logger.ScopeCountKey: "2",
+		logger.ErrorCodeKey:  "invalid_grant",
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Errorf("Attribute %s is incorrect: got %q, want %q", key, attrs[key], value)
+		}
+	}
+}
+
+func TestCloseDropsFlowSecrets(t *testing.T) {
+	client := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "closed-flow-token"})
+	})
+	verifier := client.GetCodeVerifier()
+	if got := logger.Redact(verifier); got == verifier {
+		t.Fatal("Verifier is not masked during the flow")
+	}
+	if _, err := client.ExchangeCodeForToken(context.Background(), "code"); err != nil {
+		t.Fatalf("Token exchange failed: %v", err)
+	}
+
+	// The verifier is useless after the flow, but the tokens are still used
+	client.Close()
+	if got := logger.Redact(verifier); got != verifier {
+		t.Errorf("Verifier is still masked after Close: %s", got)
+	}
+	if got := logger.Redact("closed-flow-token"); got == "closed-flow-token" {
+		t.Error("Token is no longer masked after Close")
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// RefreshToken uses a refresh token to obtain a new access token
+// (RFC 6749, section 6). The provider may or may not issue a new refresh
+// token; if it doesn't, the old one is carried over to the response.
+func (c *OAuth2Client) RefreshToken(ctx context.Context, refreshToken string, scopes ...string) (_ *TokenResponse, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.token_refresh", slog.String(logger.GrantTypeKey, "refresh_token"))
+	defer func() { span.End(err) }()
+
+	c.log.Step(1, "Refresh Access Token",
+		"Using the refresh token to obtain a new access token without user interaction")
+
+	if refreshToken == "" {
+		return nil, fmt.Errorf("refresh token is empty")
+	}
+
+	// Prepare the refresh request
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+
+	// Scopes may only be narrowed, never extended, during a refresh
+	if len(scopes) > 0 {
+		data.Set("scope", strings.Join(scopes, " "))
+		span.SetAttributes(slog.Int(logger.ScopeCountKey, len(scopes)))
+	}
+
+	c.log.Teach(catalog.TopicRefreshTokenGrant)
+
+	tokenResp, err := c.requestToken(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the old refresh token if the provider didn't rotate it
+	if tokenResp.RefreshToken == "" {
+		tokenResp.RefreshToken = refreshToken
+	}
+
+	c.log.Step(2, "Tokens Refreshed",
+		"Successfully received a new access token from the OAuth2 provider")
+
+	return tokenResp, nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// RevokeToken revokes an access or refresh token (RFC 7009). The hint may
+// be "access_token", "refresh_token" or empty.
+func (c *OAuth2Client) RevokeToken(ctx context.Context, token string, tokenTypeHint string) (err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.token_revocation")
+	defer func() { span.End(err) }()
+
+	c.log.Step(1, "Revoke Token",
+		"Asking the OAuth2 provider to invalidate the token")
+
+	if c.config.Endpoint.RevocationURL == "" {
+		return fmt.Errorf("the provider has no revocation endpoint configured")
+	}
+	if token == "" {
+		return fmt.Errorf("token is empty")
+	}
+
+	// Prepare the revocation request
+	data := url.Values{}
+	data.Set("token", token)
+	if tokenTypeHint != "" {
+		data.Set("token_type_hint", tokenTypeHint)
+	}
+
+	c.log.Teach(catalog.TopicTokenRevocation)
+
+	if _, err := c.postForm(ctx, c.config.Endpoint.RevocationURL, data); err != nil {
+		return err
+	}
+
+	c.log.Step(2, "Token Revoked", "The OAuth2 provider accepted the revocation request")
+
+	return nil
+}
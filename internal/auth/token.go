@@ -1,23 +1,26 @@
 package auth
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/korjavin/oauth2example/internal/catalog"
 	"github.com/korjavin/oauth2example/internal/logger"
 )
 
 // IDTokenClaims represents the claims in an ID token
 type IDTokenClaims struct {
 	// Standard claims
-	Issuer     string `json:"iss"`
-	Subject    string `json:"sub"`
-	Audience   string `json:"aud"`
-	Expiration int64  `json:"exp"`
-	IssuedAt   int64  `json:"iat"`
+	Issuer     string   `json:"iss"`
+	Subject    string   `json:"sub"`
+	Audience   Audience `json:"aud"`
+	Expiration int64    `json:"exp"`
+	IssuedAt   int64    `json:"iat"`
+	SessionID  string   `json:"sid,omitempty"`
 
 	// OpenID Connect claims
 	Name          string `json:"name,omitempty"`
@@ -36,7 +39,18 @@ type IDTokenClaims struct {
 
 // ParseIDToken parses an ID token and returns the claims
 func ParseIDToken(idToken string) (*IDTokenClaims, error) {
-	logger.Step(9, "Parse ID Token",
+	return parseIDToken(logger.DefaultLogger, idToken)
+}
+
+// ParseIDToken parses an ID token received in this flow and returns the
+// claims, logging as part of the flow
+func (c *OAuth2Client) ParseIDToken(idToken string) (*IDTokenClaims, error) {
+	return parseIDToken(c.log, idToken)
+}
+
+// parseIDToken parses an ID token, explaining it in log
+func parseIDToken(log *logger.Logger, idToken string) (*IDTokenClaims, error) {
+	log.Step(9, "Parse ID Token",
 		"Parsing and validating the ID token to extract user information")
 
 	// Split the token into parts
@@ -67,19 +81,7 @@ func ParseIDToken(idToken string) (*IDTokenClaims, error) {
 	claims.rawPayload = rawPayload
 	claims.rawSignature = rawSignature
 
-	logger.Educational("ID Token",
-		"The ID token is a JSON Web Token (JWT) that contains claims about the user.\n"+
-			"It consists of three parts separated by dots:\n\n"+
-			"1. Header: Contains metadata about the token (type, algorithm)\n"+
-			"2. Payload: Contains the claims (user information, expiration, etc.)\n"+
-			"3. Signature: Verifies the token's authenticity\n\n"+
-			"The claims in the ID token include:\n"+
-			"- iss (Issuer): Who issued the token\n"+
-			"- sub (Subject): The user's unique identifier\n"+
-			"- aud (Audience): Who the token is intended for\n"+
-			"- exp (Expiration): When the token expires\n"+
-			"- iat (Issued At): When the token was issued\n"+
-			"- Additional user information (name, email, etc.)")
+	log.Teach(catalog.TopicIDToken)
 
 	return &claims, nil
 }
@@ -97,28 +99,10 @@ func ValidateIDToken(claims *IDTokenClaims, expectedAudience string) error {
 		return fmt.Errorf("ID token was issued in the future (iat: %d, now: %d)", claims.IssuedAt, now)
 	}
 
-	// Check the audience if expected audience is provided
-	if expectedAudience != "" {
-		// Handle both string and array audience formats
-		var audiences []string
-
-		// If the audience is a string
-		if claims.Audience != "" {
-			audiences = []string{claims.Audience}
-		}
-
-		// Check if the expected audience is in the list
-		found := false
-		for _, aud := range audiences {
-			if aud == expectedAudience {
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return fmt.Errorf("ID token audience does not match expected audience")
-		}
+	// Check the audience if expected audience is provided; it may be one
+	// of several
+	if expectedAudience != "" && !claims.Audience.Contains(expectedAudience) {
+		return fmt.Errorf("ID token audience does not match expected audience")
 	}
 
 	return nil
@@ -135,20 +119,12 @@ func FormatTokenInfo(tokenResp *TokenResponse, claims *IDTokenClaims) string {
 	sb.WriteString(fmt.Sprintf("  Type: %s\n", tokenResp.TokenType))
 	sb.WriteString(fmt.Sprintf("  Expires In: %d seconds\n", tokenResp.ExpiresIn))
 
-	// Truncate the access token for display
-	accessToken := tokenResp.AccessToken
-	if len(accessToken) > 20 {
-		accessToken = accessToken[:20] + "..." + accessToken[len(accessToken)-10:]
-	}
-	sb.WriteString(fmt.Sprintf("  Token: %s\n\n", accessToken))
+	// Truncate the tokens for display
+	sb.WriteString(fmt.Sprintf("  Token: %s\n\n", truncateToken(tokenResp.AccessToken)))
 
 	// Refresh Token
 	if tokenResp.RefreshToken != "" {
-		refreshToken := tokenResp.RefreshToken
-		if len(refreshToken) > 20 {
-			refreshToken = refreshToken[:20] + "..." + refreshToken[len(refreshToken)-10:]
-		}
-		sb.WriteString(fmt.Sprintf("Refresh Token: %s\n\n", refreshToken))
+		sb.WriteString(fmt.Sprintf("Refresh Token: %s\n\n", truncateToken(tokenResp.RefreshToken)))
 	}
 
 	// Scopes
@@ -161,7 +137,7 @@ func FormatTokenInfo(tokenResp *TokenResponse, claims *IDTokenClaims) string {
 		sb.WriteString("ID Token Claims:\n")
 		sb.WriteString(fmt.Sprintf("  Subject (sub): %s\n", claims.Subject))
 		sb.WriteString(fmt.Sprintf("  Issuer (iss): %s\n", claims.Issuer))
-		sb.WriteString(fmt.Sprintf("  Audience (aud): %s\n", claims.Audience))
+		sb.WriteString(fmt.Sprintf("  Audience (aud): %s\n", strings.Join(claims.Audience, ", ")))
 		sb.WriteString(fmt.Sprintf("  Issued At (iat): %s\n", formatUnixTime(claims.IssuedAt)))
 		sb.WriteString(fmt.Sprintf("  Expiration (exp): %s\n", formatUnixTime(claims.Expiration)))
 
@@ -191,6 +167,87 @@ func FormatTokenInfo(tokenResp *TokenResponse, claims *IDTokenClaims) string {
 	return sb.String()
 }
 
+// truncateToken shortens a token so it can be recognized but not used
+func truncateToken(token string) string {
+	if len(token) > 20 {
+		return token[:20] + "..." + token[len(token)-10:]
+	}
+	return token
+}
+
+// TokenInfo is the machine-readable form of a token response, for scripts
+type TokenInfo struct {
+	TokenType    string                 `json:"token_type,omitempty"`
+	AccessToken  string                 `json:"access_token,omitempty"`
+	RefreshToken string                 `json:"refresh_token,omitempty"`
+	IDToken      string                 `json:"id_token,omitempty"`
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`
+	ExpiresIn    int                    `json:"expires_in,omitempty"`
+	Scopes       []string               `json:"scopes"`
+	Claims       map[string]interface{} `json:"claims,omitempty"`
+}
+
+// NewTokenInfo describes a token expiring at expiresAt, which is zero if the
+// provider didn't say. The tokens themselves are only included if withTokens
+// is set, since output like this often ends up in logs and terminals.
+func NewTokenInfo(tokenResp *TokenResponse, expiresAt time.Time, withTokens bool) (*TokenInfo, error) {
+	info := &TokenInfo{
+		TokenType: tokenResp.TokenType,
+		Scopes:    strings.Fields(tokenResp.Scope),
+	}
+	if withTokens {
+		info.AccessToken = tokenResp.AccessToken
+		info.RefreshToken = tokenResp.RefreshToken
+		info.IDToken = tokenResp.IDToken
+	}
+
+	// Give the absolute expiry, and the time left as of now rather than
+	// as of when the token was issued
+	if !expiresAt.IsZero() {
+		at := expiresAt.UTC().Truncate(time.Second)
+		info.ExpiresAt = &at
+		if left := time.Until(expiresAt); left > 0 {
+			info.ExpiresIn = int(left.Seconds())
+		}
+	}
+
+	// Include every claim of the ID token, not only the ones IDTokenClaims knows
+	if tokenResp.IDToken != "" {
+		_, payload, err := DecodeJWT(tokenResp.IDToken)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&info.Claims); err != nil {
+			return nil, fmt.Errorf("failed to parse token claims: %w", err)
+		}
+	}
+
+	return info, nil
+}
+
+// DecodeJWT splits a JWT and decodes its header and payload without
+// verifying the signature. It is meant for displaying tokens only.
+func DecodeJWT(token string) (header []byte, payload []byte, err error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("invalid JWT format: expected 3 parts, got %d", len(parts))
+	}
+
+	header, err = base64URLDecode(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode token header: %w", err)
+	}
+
+	payload, err = base64URLDecode(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	return header, payload, nil
+}
+
 // base64URLDecode decodes a base64url encoded string
 func base64URLDecode(s string) ([]byte, error) {
 	// Add padding if needed
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewTokenInfo(t *testing.T) {
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "user-1", "hd": "example.com", "exp": 1700000000})
+	idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+	token := &TokenResponse{
+		AccessToken:  "access",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh",
+		IDToken:      idToken,
+		Scope:        "openid email",
+	}
+	expiresAt := time.Now().Add(time.Hour)
+
+	info, err := NewTokenInfo(token, expiresAt, false)
+	if err != nil {
+		t.Fatalf("Failed to describe token: %v", err)
+	}
+	if info.AccessToken != "" || info.RefreshToken != "" || info.IDToken != "" {
+		t.Errorf("Tokens are included without being requested: %+v", info)
+	}
+	if info.ExpiresAt == nil || !info.ExpiresAt.Equal(expiresAt.UTC().Truncate(time.Second)) {
+		t.Errorf("Expiry is incorrect: got %v, want %v", info.ExpiresAt, expiresAt)
+	}
+	if info.ExpiresIn < 3590 || info.ExpiresIn > 3600 {
+		t.Errorf("Time left is incorrect: got %d", info.ExpiresIn)
+	}
+	if len(info.Scopes) != 2 || info.Scopes[1] != "email" {
+		t.Errorf("Scopes are incorrect: got %v", info.Scopes)
+	}
+	// Claims IDTokenClaims doesn't know are kept, and numbers stay exact
+	if info.Claims["hd"] != "example.com" || info.Claims["exp"] != json.Number("1700000000") {
+		t.Errorf("Claims are incorrect: got %v", info.Claims)
+	}
+
+	info, err = NewTokenInfo(token, time.Time{}, true)
+	if err != nil {
+		t.Fatalf("Failed to describe token: %v", err)
+	}
+	if info.AccessToken != "access" || info.RefreshToken != "refresh" || info.IDToken != idToken {
+		t.Errorf("Tokens are missing: %+v", info)
+	}
+	if info.ExpiresAt != nil || info.ExpiresIn != 0 {
+		t.Errorf("Expected no expiry, got %v", info.ExpiresAt)
+	}
+}
+
+func TestIDTokenAudience(t *testing.T) {
+	tests := []struct {
+		name    string
+		aud     interface{}
+		wantErr bool
+	}{
+		{"string", "client-1", false},
+		{"array", []string{"api", "client-1"}, false},
+		{"other client", []string{"api", "client-2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]interface{}{"sub": "1", "aud": tt.aud, "exp": time.Now().Add(time.Hour).Unix()})
+			claims, err := ParseIDToken("eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig")
+			if err != nil {
+				t.Fatalf("Failed to parse ID token: %v", err)
+			}
+			if err := ValidateIDToken(claims, "client-1"); (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected validation result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// TraceAll traces the requests to every endpoint
+const TraceAll = "all"
+
+// TraceEndpoints lists the endpoints whose requests can be traced
+var TraceEndpoints = []string{"token", "device", "userinfo", "revocation", "introspection"}
+
+// maxTraceBody limits how much of a body is dumped
+const maxTraceBody = 64 << 10
+
+// Exchange is a traced HTTP request and its response
+type Exchange struct {
+	// Endpoint names the provider endpoint, if known
+	Endpoint string `json:"endpoint,omitempty"`
+
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Proto         string      `json:"proto"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"`
+
+	// Error is set instead of the response if the request failed
+	Error string `json:"error,omitempty"`
+
+	StatusCode     int         `json:"status_code,omitempty"`
+	Status         string      `json:"status,omitempty"`
+	ResponseProto  string      `json:"response_proto,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+
+	Duration time.Duration `json:"duration"`
+	// Timing and TLS describe the connection, as in the trace
+	Timing string `json:"timing,omitempty"`
+	TLS    string `json:"tls,omitempty"`
+}
+
+// TracingTransport is an http.RoundTripper that logs the requests it sends
+// and the responses it receives: the request and status lines, headers,
+// bodies, timing and TLS details. Credentials in the dump are redacted by
+// the logger like everywhere else.
+type TracingTransport struct {
+	// Base sends the requests; nil means http.DefaultTransport
+	Base http.RoundTripper
+	// Log receives the dumps; nil logs nothing
+	Log *logger.Logger
+	// Record, if set, receives every traced exchange once it is complete
+	Record func(*Exchange)
+	// Endpoints maps the URLs to trace to the names of their endpoints.
+	// Nil traces every request.
+	Endpoints map[string]string
+
+	seq atomic.Int64
+}
+
+// newTracingTransport traces the requests to the named endpoints of e
+func newTracingTransport(base http.RoundTripper, e Endpoint, names []string, log *logger.Logger) (*TracingTransport, error) {
+	urls := map[string]string{
+		"token":         e.TokenURL,
+		"device":        e.DeviceAuthURL,
+		"userinfo":      e.UserInfoURL,
+		"revocation":    e.RevocationURL,
+		"introspection": e.IntrospectionURL,
+	}
+
+	endpoints := make(map[string]string)
+	for _, name := range names {
+		if name == TraceAll {
+			for n, u := range urls {
+				if u != "" {
+					endpoints[traceKey(u)] = n
+				}
+			}
+			continue
+		}
+		u, ok := urls[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown endpoint %q to trace (%s or %s)", name, strings.Join(TraceEndpoints, ", "), TraceAll)
+		}
+		if u != "" {
+			endpoints[traceKey(u)] = name
+		}
+	}
+
+	return &TracingTransport{Base: base, Log: log, Endpoints: endpoints}, nil
+}
+
+// traceKey identifies an endpoint by its URL without the query
+func traceKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	var endpoint string
+	if t.Endpoints != nil {
+		var ok bool
+		if endpoint, ok = t.Endpoints[traceKey(req.URL.String())]; !ok {
+			return base.RoundTrip(req)
+		}
+	}
+	n := t.seq.Add(1)
+
+	// Read the body from a copy, or replace it, so it can still be sent
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetBody == nil && req.Body != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	exchange := &Exchange{
+		Endpoint:      endpoint,
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Proto:         req.Proto,
+		RequestHeader: req.Header.Clone(),
+		RequestBody:   string(body),
+	}
+	t.log("HTTP request #%d%s\n%s", n, exchange.to(), exchange.DumpRequest())
+
+	timing := &traceTiming{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timing.clientTrace()))
+
+	started := time.Now()
+	resp, err := base.RoundTrip(req)
+	exchange.Duration = time.Since(started)
+	exchange.Timing = timing.String()
+	if err != nil {
+		exchange.Error = err.Error()
+		t.log("HTTP request #%d%s failed after %s: %v\n%s", n, exchange.to(), roundDuration(exchange.Duration), err, exchange.Timing)
+		t.record(exchange)
+		return nil, err
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	exchange.StatusCode = resp.StatusCode
+	exchange.Status = resp.Status
+	exchange.ResponseProto = resp.Proto
+	exchange.ResponseHeader = resp.Header.Clone()
+	exchange.ResponseBody = string(body)
+	exchange.TLS = dumpTLS(resp.TLS)
+
+	t.log("HTTP response #%d%s: %s in %s\n%s", n, exchange.from(), resp.Status, roundDuration(exchange.Duration), exchange.DumpResponse())
+	t.record(exchange)
+	return resp, nil
+}
+
+// log logs a dump without its trailing newline
+func (t *TracingTransport) log(format string, args ...interface{}) {
+	if t.Log == nil {
+		return
+	}
+	t.Log.Info("%s", strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+}
+
+// record hands a complete exchange to Record
+func (t *TracingTransport) record(exchange *Exchange) {
+	if t.Record != nil {
+		t.Record(exchange)
+	}
+}
+
+// requestBody returns the body of a request without consuming it if the
+// request can provide a copy
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	rc := req.Body
+	if req.GetBody != nil {
+		var err error
+		if rc, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+	defer rc.Close()
+
+	body, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, nil
+}
+
+// to names the endpoint a request went to, if known
+func (e *Exchange) to() string {
+	if e.Endpoint == "" {
+		return ""
+	}
+	return " to the " + e.Endpoint + " endpoint"
+}
+
+// from names the endpoint a response came from, if known
+func (e *Exchange) from() string {
+	if e.Endpoint == "" {
+		return ""
+	}
+	return " from the " + e.Endpoint + " endpoint"
+}
+
+// Summary describes the exchange on one line, like the trace does
+func (e *Exchange) Summary() string {
+	if e.Error != "" {
+		return fmt.Sprintf("HTTP request%s failed after %s: %s", e.to(), roundDuration(e.Duration), e.Error)
+	}
+	return fmt.Sprintf("HTTP response%s: %s in %s", e.from(), e.Status, roundDuration(e.Duration))
+}
+
+// DumpRequest formats the request line, headers and body
+func (e *Exchange) DumpRequest() string {
+	target, host := e.URL, ""
+	if u, err := url.Parse(e.URL); err == nil {
+		target, host = u.RequestURI(), u.Host
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\n", e.Method, target, e.Proto)
+	fmt.Fprintf(&buf, "Host: %s\n", host)
+	writeHeaders(&buf, e.RequestHeader)
+	writeBody(&buf, e.RequestBody)
+	return buf.String()
+}
+
+// DumpResponse formats the timing, TLS details, status line, headers and
+// body
+func (e *Exchange) DumpResponse() string {
+	var buf bytes.Buffer
+	buf.WriteString(e.Timing)
+	buf.WriteString(e.TLS)
+	fmt.Fprintf(&buf, "%s %s\n", e.ResponseProto, e.Status)
+	writeHeaders(&buf, e.ResponseHeader)
+	writeBody(&buf, e.ResponseBody)
+	return buf.String()
+}
+
+// writeHeaders writes headers sorted by name
+func writeHeaders(buf *bytes.Buffer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(buf, "%s: %s\n", name, value)
+		}
+	}
+}
+
+// writeBody writes a body after a blank line, truncated to maxTraceBody
+func writeBody(buf *bytes.Buffer, body string) {
+	if len(body) == 0 {
+		return
+	}
+	buf.WriteString("\n")
+	if len(body) > maxTraceBody {
+		buf.WriteString(body[:maxTraceBody])
+		fmt.Fprintf(buf, "\n... (%d more bytes)", len(body)-maxTraceBody)
+		return
+	}
+	buf.WriteString(strings.TrimRight(body, "\n"))
+}
+
+// dumpTLS describes the TLS connection of a response
+func dumpTLS(state *tls.ConnectionState) string {
+	if state == nil {
+		return ""
+	}
+
+	parts := []string{tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)}
+	if state.NegotiatedProtocol != "" {
+		parts = append(parts, "ALPN "+state.NegotiatedProtocol)
+	}
+	if state.DidResume {
+		parts = append(parts, "resumed")
+	}
+	line := "TLS: " + strings.Join(parts, ", ") + "\n"
+
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		line += fmt.Sprintf("Certificate: %s, issued by %s, expires %s\n",
+			cert.Subject, cert.Issuer, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return line
+}
+
+// traceTiming records the phases of a request through httptrace
+type traceTiming struct {
+	mu         sync.Mutex
+	dnsStart   time.Time
+	dns        time.Duration
+	dialStart  time.Time
+	connect    time.Duration
+	tlsStart   time.Time
+	tls        time.Duration
+	wrote      time.Time
+	firstByte  time.Duration
+	reused     bool
+	remoteAddr string
+}
+
+// clientTrace returns the hooks filling in t
+func (t *traceTiming) clientTrace() *httptrace.ClientTrace {
+	record := func(f func()) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(func() { t.dns = time.Since(t.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			record(func() { t.dialStart = time.Now() })
+		},
+		ConnectDone: func(string, string, error) {
+			record(func() { t.connect = time.Since(t.dialStart) })
+		},
+		TLSHandshakeStart: func() { record(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record(func() { t.tls = time.Since(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			record(func() {
+				t.reused = info.Reused
+				if info.Conn != nil {
+					t.remoteAddr = info.Conn.RemoteAddr().String()
+				}
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { record(func() { t.wrote = time.Now() }) },
+		GotFirstResponseByte: func() {
+			record(func() {
+				if !t.wrote.IsZero() {
+					t.firstByte = time.Since(t.wrote)
+				}
+			})
+		},
+	}
+}
+
+// String describes the phases of the request on one line
+func (t *traceTiming) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var parts []string
+	if t.reused {
+		parts = append(parts, "reused connection")
+	} else {
+		// IP addresses need no lookup
+		if !t.dnsStart.IsZero() {
+			parts = append(parts, "DNS "+roundDuration(t.dns).String())
+		}
+		parts = append(parts, "connect "+roundDuration(t.connect).String())
+		if t.tls > 0 {
+			parts = append(parts, "TLS handshake "+roundDuration(t.tls).String())
+		}
+	}
+	parts = append(parts, "server "+roundDuration(t.firstByte).String())
+	if t.remoteAddr != "" {
+		parts = append(parts, "to "+t.remoteAddr)
+	}
+	return "Timing: " + strings.Join(parts, ", ") + "\n"
+}
+
+// roundDuration rounds a duration for display
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+func TestTracingTransport(t *testing.T) {
+	var buf bytes.Buffer
+	logger.DefaultLogger.SetWriter(&buf)
+	t.Cleanup(func() { logger.DefaultLogger.SetWriter(os.Stdout) })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" && r.PostFormValue("code") != "code-4711" {
+			t.Errorf("Traced request lost its body: %v", r.PostForm)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at-secret-value", "token_type": "Bearer", "expires_in": 3600, "sub": "user-1",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewOAuth2Client(OAuth2Config{
+		ClientID: "client-123",
+		Endpoint: Endpoint{
+			AuthURL:     srv.URL + "/auth",
+			TokenURL:    srv.URL + "/token",
+			UserInfoURL: srv.URL + "/userinfo",
+		},
+		Trace: []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	token, err := client.ExchangeCodeForToken(context.Background(), "code-4711")
+	if err != nil {
+		t.Fatalf("Token exchange failed: %v", err)
+	}
+	if token.AccessToken != "at-secret-value" {
+		t.Errorf("Traced response lost its body: got %q", token.AccessToken)
+	}
+	if _, err := client.GetUserInfo(context.Background(), token.AccessToken); err != nil {
+		t.Fatalf("Userinfo request failed: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"HTTP request #1 to the token endpoint",
+		"POST /token HTTP/1.1",
+		"Content-Type: application/x-www-form-urlencoded",
+		"code=" + logger.Mask("code-4711"),
+		"HTTP response #1 from the token endpoint: 200 OK",
+		"Timing: ",
+		`"access_token":"` + logger.Mask("at-secret-value") + `"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Trace does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "code-4711") || strings.Contains(out, "at-secret-value") || strings.Contains(out, client.GetCodeVerifier()) {
+		t.Errorf("Trace contains credentials:\n%s", out)
+	}
+	if strings.Contains(out, "GET /userinfo") {
+		t.Errorf("Untraced endpoint was traced:\n%s", out)
+	}
+}
+
+func TestTraceUnknownEndpoint(t *testing.T) {
+	_, err := NewOAuth2Client(OAuth2Config{ClientID: "client-123", Trace: []string{"authorize"}})
+	if err == nil || !strings.Contains(err.Error(), `unknown endpoint "authorize"`) {
+		t.Errorf("Expected an unknown endpoint error, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// GetUserInfo fetches the claims about the user from the userinfo endpoint
+// (OpenID Connect Core, section 5.3)
+func (c *OAuth2Client) GetUserInfo(ctx context.Context, accessToken string) (_ map[string]interface{}, err error) {
+	ctx, span := c.StartSpan(ctx, "oauth2.userinfo")
+	defer func() { span.End(err) }()
+
+	c.log.Step(1, "Fetch User Info",
+		"Calling the userinfo endpoint with the access token")
+
+	if c.config.Endpoint.UserInfoURL == "" {
+		return nil, fmt.Errorf("the provider has no userinfo endpoint configured")
+	}
+	if accessToken == "" {
+		return nil, fmt.Errorf("access token is empty")
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.Endpoint.UserInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create userinfo request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	c.log.Teach(catalog.TopicUserInfoEndpoint)
+
+	// Send the request
+	c.log.Debug("Sending userinfo request to %s", c.config.Endpoint.UserInfoURL)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read userinfo response: %w", err)
+	}
+
+	// Check for error response
+	if resp.StatusCode != http.StatusOK {
+		return nil, newProviderError(resp.StatusCode, body)
+	}
+
+	// Parse the response
+	var claims map[string]interface{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse userinfo response: %w", err)
+	}
+
+	return claims, nil
+}
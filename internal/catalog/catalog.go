@@ -0,0 +1,197 @@
+// Package catalog holds the educational messages, keyed by topic ID, in
+// every supported language. The messages are embedded JSON files, one per
+// language; topics a translation lacks fall back to English.
+package catalog
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultLocale is the language every topic is written in
+const DefaultLocale = "en"
+
+// Topic IDs of the educational messages
+const (
+	TopicAuthorizationCode     = "authorization_code"
+	TopicAuthorizationURL      = "authorization_url"
+	TopicBackChannelLogout     = "back_channel_logout"
+	TopicCallbackServer        = "callback_server"
+	TopicDeviceAuthorization   = "device_authorization"
+	TopicHTTPSLoopbackRedirect = "https_loopback_redirect"
+	TopicIDToken               = "id_token"
+	TopicManualRedirect        = "manual_redirect"
+	TopicOAuth2Tokens          = "oauth2_tokens"
+	TopicPKCE                  = "pkce"
+	TopicRefreshTokenGrant     = "refresh_token_grant"
+	TopicRPInitiatedLogout     = "rp_initiated_logout"
+	TopicTokenExchange         = "token_exchange"
+	TopicTokenIntrospection    = "token_introspection"
+	TopicTokenRevocation       = "token_revocation"
+	TopicUserInfoEndpoint      = "userinfo_endpoint"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Message is the educational message of a topic
+type Message struct {
+	Title string
+	Text  string
+}
+
+// entry is a topic in a catalog file. The text is a list of lines, which
+// is easier to translate than one long string.
+type entry struct {
+	Title string   `json:"title"`
+	Text  []string `json:"text"`
+}
+
+// Catalog holds the messages of one language
+type Catalog struct {
+	locale   string
+	messages map[string]Message
+	fallback *Catalog
+}
+
+// english is the catalog every other one falls back to
+var english = mustLoad(DefaultLocale)
+
+// current is the catalog of the selected language
+var current atomic.Pointer[Catalog]
+
+func init() {
+	current.Store(english)
+}
+
+// Locales returns the supported languages
+func Locales() []string {
+	files, _ := locales.ReadDir("locales")
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Normalize reduces a locale such as de_DE.UTF-8 or pt-BR to its language,
+// in lower case. C and POSIX, which name no language, become "".
+func Normalize(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "_-.@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "c" || locale == "posix" {
+		return ""
+	}
+	return locale
+}
+
+// FromEnv returns the language of the environment, from LC_ALL,
+// LC_MESSAGES or LANG, whichever is set first, or "" if none is
+func FromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return Normalize(value)
+		}
+	}
+	return ""
+}
+
+// Load returns the catalog of a language, which falls back to English
+func Load(locale string) (*Catalog, error) {
+	locale = Normalize(locale)
+	if locale == "" || locale == DefaultLocale {
+		return english, nil
+	}
+
+	c, err := load(locale)
+	if err != nil {
+		return nil, err
+	}
+	c.fallback = english
+	return c, nil
+}
+
+// load reads the catalog file of a language
+func load(locale string) (*Catalog, error) {
+	data, err := locales.ReadFile(path.Join("locales", locale+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported language %q, use one of %s", locale, strings.Join(Locales(), ", "))
+	}
+
+	var entries map[string]entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse the %s catalog: %w", locale, err)
+	}
+
+	c := &Catalog{locale: locale, messages: make(map[string]Message, len(entries))}
+	for id, e := range entries {
+		c.messages[id] = Message{Title: e.Title, Text: strings.Join(e.Text, "\n")}
+	}
+	return c, nil
+}
+
+// mustLoad loads an embedded catalog that must be valid
+func mustLoad(locale string) *Catalog {
+	c, err := load(locale)
+	if err != nil {
+		panic("catalog: " + err.Error())
+	}
+	return c
+}
+
+// Locale returns the language of the catalog
+func (c *Catalog) Locale() string {
+	return c.locale
+}
+
+// Message returns the message of a topic, in English if the catalog lacks
+// it. An unknown topic gets its ID as the title.
+func (c *Catalog) Message(id string) Message {
+	if m, ok := c.messages[id]; ok {
+		return m
+	}
+	if c.fallback != nil {
+		return c.fallback.Message(id)
+	}
+	return Message{Title: id}
+}
+
+// SetLocale selects the language of the messages returned by Get
+func SetLocale(locale string) error {
+	c, err := Load(locale)
+	if err != nil {
+		return err
+	}
+	current.Store(c)
+	return nil
+}
+
+// Locale returns the selected language
+func Locale() string {
+	return current.Load().Locale()
+}
+
+// Get returns the message of a topic in the selected language
+func Get(id string) Message {
+	return current.Load().Message(id)
+}
+
+// TopicID returns the ID of a topic from its English title, for records
+// written before topics had IDs
+func TopicID(title string) string {
+	for id, m := range english.messages {
+		if m.Title == title {
+			return id
+		}
+	}
+	return ""
+}
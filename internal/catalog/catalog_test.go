@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+)
+
+// topics are the topic IDs the code uses
+var topics = []string{
+	TopicAuthorizationCode, TopicAuthorizationURL, TopicBackChannelLogout, TopicCallbackServer,
+	TopicDeviceAuthorization, TopicHTTPSLoopbackRedirect, TopicIDToken, TopicManualRedirect, TopicOAuth2Tokens, TopicPKCE,
+	TopicRefreshTokenGrant, TopicRPInitiatedLogout, TopicTokenExchange, TopicTokenIntrospection,
+	TopicTokenRevocation, TopicUserInfoEndpoint,
+}
+
+func TestLocales(t *testing.T) {
+	if got := strings.Join(Locales(), ","); got != "de,en,ru" {
+		t.Errorf("Locales are incorrect: got %q, want %q", got, "de,en,ru")
+	}
+
+	for _, locale := range Locales() {
+		c, err := load(locale)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", locale, err)
+		}
+		for _, id := range topics {
+			m, ok := c.messages[id]
+			if !ok {
+				t.Errorf("%s lacks topic %s", locale, id)
+				continue
+			}
+			if m.Title == "" || m.Text == "" {
+				t.Errorf("%s topic %s is empty: %+v", locale, id, m)
+			}
+		}
+		if len(c.messages) != len(topics) {
+			t.Errorf("%s has %d topics, want %d", locale, len(c.messages), len(topics))
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load("de_DE.UTF-8")
+	if err != nil {
+		t.Fatalf("Failed to load German: %v", err)
+	}
+	if got := c.Message(TopicAuthorizationCode).Title; got != "Autorisierungscode" {
+		t.Errorf("German title is incorrect: got %q", got)
+	}
+
+	// Topics missing from a translation are in English
+	delete(c.messages, TopicPKCE)
+	if got := c.Message(TopicPKCE).Text; !strings.HasPrefix(got, "The code verifier") {
+		t.Errorf("Fallback is not English: %q", got)
+	}
+	if got := c.Message("unknown").Title; got != "unknown" {
+		t.Errorf("Unknown topic title is incorrect: got %q, want %q", got, "unknown")
+	}
+
+	if _, err := Load("xx"); err == nil || !strings.Contains(err.Error(), "de, en, ru") {
+		t.Errorf("Unknown language is not rejected with the supported ones: %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"de_DE.UTF-8": "de",
+		"ru_RU":       "ru",
+		"pt-BR":       "pt",
+		"EN":          "en",
+		"C":           "",
+		"POSIX":       "",
+		"C.UTF-8":     "",
+		"":            "",
+	}
+	for locale, want := range tests {
+		if got := Normalize(locale); got != want {
+			t.Errorf("Normalize(%q) is incorrect: got %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "ru_RU.UTF-8")
+	t.Setenv("LANG", "de_DE.UTF-8")
+	if got := FromEnv(); got != "ru" {
+		t.Errorf("Language is incorrect: got %q, want %q", got, "ru")
+	}
+
+	t.Setenv("LC_ALL", "C")
+	if got := FromEnv(); got != "" {
+		t.Errorf("Language is incorrect: got %q, want none", got)
+	}
+}
+
+func TestSetLocale(t *testing.T) {
+	t.Cleanup(func() { SetLocale(DefaultLocale) })
+
+	if err := SetLocale("ru"); err != nil {
+		t.Fatalf("Failed to select Russian: %v", err)
+	}
+	if Locale() != "ru" || Get(TopicTokenRevocation).Title != "Отзыв токена" {
+		t.Errorf("Russian is not selected: %s, %+v", Locale(), Get(TopicTokenRevocation))
+	}
+	if err := SetLocale("xx"); err == nil || Locale() != "ru" {
+		t.Errorf("Unknown language changed the selection: %v", err)
+	}
+	if TopicID("Token Revocation") != TopicTokenRevocation {
+		t.Errorf("Topic ID of an English title is incorrect: %q", TopicID("Token Revocation"))
+	}
+}
@@ -0,0 +1,366 @@
+// Package config loads the layered CLI configuration and persistent state.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// Providers with built-in endpoints, by the name used in the provider setting
+var Providers = map[string]auth.Endpoint{
+	"google": auth.GoogleEndpoint,
+}
+
+// fileValue is a setting read from the config file
+type fileValue struct {
+	raw  string
+	line int
+}
+
+// File is a parsed and validated config file. Settings at the top level
+// apply to every profile; a profile's settings override them.
+type File struct {
+	Path string
+
+	// DefaultProfile is used when no profile is selected
+	DefaultProfile     string
+	defaultProfileLine int
+
+	settings map[string]fileValue
+	profiles map[string]map[string]fileValue
+}
+
+// DefaultPath returns the default location of the config file, under the
+// user's config directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "oauth2cli", "config.json"), nil
+}
+
+// Load reads and validates the config file at path. A missing file is an
+// empty config.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &File{Path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return Parse(path, data)
+}
+
+// Parse parses and validates a config file. Errors name the line they were
+// found on.
+func Parse(path string, data []byte) (*File, error) {
+	p := &parser{
+		file: &File{Path: path},
+		data: data,
+		dec:  json.NewDecoder(bytes.NewReader(data)),
+	}
+	p.dec.UseNumber()
+
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.file, nil
+}
+
+// Profile returns the settings of the named profile, or of the default
+// profile if name is empty. It returns nil without an error if neither exists.
+func (f *File) Profile(name string) (map[string]fileValue, string, error) {
+	if name == "" {
+		name = f.DefaultProfile
+	}
+	if name == "" {
+		return nil, "", nil
+	}
+
+	p, ok := f.profiles[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(f.ProfileNames(), ", "))
+	}
+	return p, name, nil
+}
+
+// ProfileNames returns the names of the defined profiles in order
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.profiles))
+	for name := range f.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// origin describes where in the file a line is
+func (f *File) origin(line int) string {
+	return fmt.Sprintf("%s:%d", f.Path, line)
+}
+
+// parser walks the JSON tokens of a config file, so every setting can be
+// validated with the line it came from
+type parser struct {
+	file *File
+	data []byte
+	dec  *json.Decoder
+}
+
+// parse reads the whole file
+func (p *parser) parse() error {
+	if err := p.expectDelim('{'); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for p.dec.More() {
+		key, line, err := p.key(seen)
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "default_profile":
+			value, err := p.value(line, key)
+			if err != nil {
+				return err
+			}
+			p.file.DefaultProfile, p.file.defaultProfileLine = value, line
+		case "profiles":
+			if err := p.profiles(); err != nil {
+				return err
+			}
+		default:
+			if p.file.settings == nil {
+				p.file.settings = make(map[string]fileValue)
+			}
+			if err := p.setting(p.file.settings, key, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := p.expectDelim('}'); err != nil {
+		return err
+	}
+	if _, err := p.dec.Token(); err != io.EOF {
+		return p.errorf(p.line(), "unexpected data after the end of the config")
+	}
+
+	if p.file.DefaultProfile != "" {
+		if _, ok := p.file.profiles[p.file.DefaultProfile]; !ok {
+			return p.errorf(p.file.defaultProfileLine, "default profile %q is not defined", p.file.DefaultProfile)
+		}
+	}
+	return nil
+}
+
+// profiles reads the profiles object
+func (p *parser) profiles() error {
+	if err := p.expectDelim('{'); err != nil {
+		return err
+	}
+	p.file.profiles = make(map[string]map[string]fileValue)
+
+	names := make(map[string]bool)
+	for p.dec.More() {
+		name, _, err := p.key(names)
+		if err != nil {
+			return err
+		}
+		if err := p.expectDelim('{'); err != nil {
+			return err
+		}
+
+		settings := make(map[string]fileValue)
+		seen := make(map[string]bool)
+		for p.dec.More() {
+			key, line, err := p.key(seen)
+			if err != nil {
+				return err
+			}
+			if err := p.setting(settings, key, line); err != nil {
+				return err
+			}
+		}
+		if err := p.expectDelim('}'); err != nil {
+			return err
+		}
+		p.file.profiles[name] = settings
+	}
+
+	return p.expectDelim('}')
+}
+
+// setting reads and validates the value of a setting
+func (p *parser) setting(settings map[string]fileValue, key string, line int) error {
+	s := Lookup(key)
+	if s == nil || s.Key != key {
+		return p.errorf(line, "unknown setting %q", key)
+	}
+
+	raw, err := p.value(line, key)
+	if err != nil {
+		return err
+	}
+	if raw, err = expand(raw); err != nil {
+		return p.errorf(line, "%s: %v", key, err)
+	}
+	if err := s.Validate(raw); err != nil {
+		return p.errorf(line, "%s: %v", key, err)
+	}
+	if key == "provider" && raw != "" {
+		if _, ok := Providers[raw]; !ok {
+			return p.errorf(line, "unknown provider %q", raw)
+		}
+	}
+
+	settings[key] = fileValue{raw: raw, line: line}
+	return nil
+}
+
+// key reads an object key, rejecting duplicates
+func (p *parser) key(seen map[string]bool) (string, int, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return "", 0, p.syntaxError(err)
+	}
+	line := p.line()
+
+	key, ok := tok.(string)
+	if !ok {
+		return "", 0, p.errorf(line, "expected a key")
+	}
+	if seen[key] {
+		return "", 0, p.errorf(line, "duplicate key %q", key)
+	}
+	seen[key] = true
+	return key, line, nil
+}
+
+// value reads a scalar or an array of strings as the raw string form of a
+// setting. Arrays are joined with spaces.
+func (p *parser) value(line int, key string) (string, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return "", p.syntaxError(err)
+	}
+
+	switch v := tok.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case json.Number:
+		return v.String(), nil
+	case json.Delim:
+		if v != '[' {
+			return "", p.errorf(line, "%s: expected a value, not an object", key)
+		}
+		var items []string
+		for p.dec.More() {
+			tok, err := p.dec.Token()
+			if err != nil {
+				return "", p.syntaxError(err)
+			}
+			item, ok := tok.(string)
+			if !ok {
+				return "", p.errorf(p.line(), "%s: list items must be strings", key)
+			}
+			items = append(items, item)
+		}
+		if _, err := p.dec.Token(); err != nil {
+			return "", p.syntaxError(err)
+		}
+		return strings.Join(items, " "), nil
+	default:
+		return "", p.errorf(line, "%s: null is not a valid value", key)
+	}
+}
+
+// expectDelim reads a delimiter
+func (p *parser) expectDelim(want json.Delim) error {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return p.syntaxError(err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return p.errorf(p.line(), "expected %q", string(want))
+	}
+	return nil
+}
+
+// line returns the line of the decoder's current position
+func (p *parser) line() int {
+	return lineAt(p.data, p.dec.InputOffset())
+}
+
+// syntaxError adds the line to a JSON syntax error
+func (p *parser) syntaxError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return p.errorf(lineAt(p.data, syntaxErr.Offset), "%v", err)
+	}
+	if errors.Is(err, io.EOF) {
+		return p.errorf(lineAt(p.data, int64(len(p.data))), "unexpected end of file")
+	}
+	return p.errorf(p.line(), "%v", err)
+}
+
+// errorf formats an error at a line of the file
+func (p *parser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", p.file.origin(line), fmt.Sprintf(format, args...))
+}
+
+// lineAt returns the 1-based line of a byte offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// expand replaces ${NAME} references with environment variables. A
+// reference to an unset variable is an error, and $$ is a literal $.
+func expand(raw string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '$' {
+			b.WriteByte(raw[i])
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(raw[i:], "$$"):
+			b.WriteByte('$')
+			i++
+		case strings.HasPrefix(raw[i:], "${"):
+			end := strings.IndexByte(raw[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ reference")
+			}
+			name := raw[i+2 : i+end]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("OAUTH2_AUDIENCE", "")
+	t.Setenv("OAUTH2_SCOPES", "")
+	t.Setenv("OAUTH2_TIMEOUT", "90s")
+	t.Setenv("GOOGLE_CLIENT_ID", "")
+	t.Setenv("OAUTH2_PROFILE", "")
+	t.Setenv("TEST_AUDIENCE", "api://admin")
+
+	file, err := Parse("config.json", []byte(`{
+		"scopes": ["openid", "email"],
+		"timeout": "1m",
+		"default_profile": "admin",
+		"profiles": {
+			"admin": {
+				"provider": "google",
+				"client_id": "admin",
+				"audience": "${TEST_AUDIENCE}",
+				"token_url": "https://example.com/token"
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	v, err := Resolve(file, map[string]string{"scopes": "openid"})
+	if err != nil {
+		t.Fatalf("Failed to resolve config: %v", err)
+	}
+	if v.Profile != "admin" {
+		t.Errorf("Default profile not selected: %q", v.Profile)
+	}
+
+	tests := []struct {
+		name   string
+		want   string
+		source Source
+		origin string
+	}{
+		{"client_id", "admin", SourceProfile, "profile admin (config.json:8)"},
+		{"audience", "api://admin", SourceProfile, "profile admin (config.json:9)"},
+		{"scopes", "openid", SourceFlag, "--scopes"},
+		{"timeout", "90s", SourceEnv, "OAUTH2_TIMEOUT"},
+		{"debug", "true", SourceDefault, ""},
+		{"token_url", "https://example.com/token", SourceProfile, "profile admin (config.json:10)"},
+		{"auth_url", auth.GoogleAuthURL, SourceDefault, ""},
+	}
+	for _, tt := range tests {
+		got := v.Get(tt.name)
+		if got.Raw != tt.want || got.Source != tt.source || got.Origin != tt.origin {
+			t.Errorf("%s: got %q from %s (%s), want %q from %s (%s)",
+				tt.name, got.Raw, got.Source, got.Origin, tt.want, tt.source, tt.origin)
+		}
+	}
+}
+
+func TestResolveCustomEndpoint(t *testing.T) {
+	t.Setenv("OAUTH2_REVOCATION_URL", "")
+	t.Setenv("OAUTH2_PROVIDER", "")
+
+	file, err := Parse("config.json", []byte(`{"auth_url": "https://idp.example.com/authorize"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	v, err := Resolve(file, nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve config: %v", err)
+	}
+
+	// Endpoints from the file replace the Google defaults as a whole
+	if e := v.Endpoint(); e.AuthURL != "https://idp.example.com/authorize" || e.RevocationURL != "" {
+		t.Errorf("Unexpected endpoint: %+v", e)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    string
+	}{
+		"syntax":           {"{\n  \"scopes\": [\n}", "config.json:3:"},
+		"unknown setting":  {"{\n  \"client\": \"x\"\n}", `config.json:2: unknown setting "client"`},
+		"invalid duration": {"{\n\n  \"timeout\": \"soon\"\n}", `config.json:3: timeout: invalid duration "soon"`},
+		"invalid boolean":  {"{\"debug\": \"maybe\"}", `config.json:1: debug: invalid boolean "maybe"`},
+		"duplicate":        {"{\"audience\": \"a\",\n\"audience\": \"b\"}", `config.json:2: duplicate key "audience"`},
+		"flag only":        {"{\"port\": 8080}", `unknown setting "port"`},
+		"unknown provider": {"{\"profiles\": {\"x\": {\n\"provider\": \"nope\"}}}", `config.json:2: unknown provider "nope"`},
+		"unknown default":  {"{\"default_profile\": \"x\"}", `default profile "x" is not defined`},
+		"undefined env":    {"{\"audience\": \"${UNSET_FOR_TEST_REALLY}\"}", "UNSET_FOR_TEST_REALLY is not set"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse("config.json", []byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Unexpected error: got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	t.Setenv("TEST_NAME", "world")
+
+	got, err := expand("hello ${TEST_NAME}, $$5 and $x")
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	if want := "hello world, $5 and $x"; got != want {
+		t.Errorf("Expansion is incorrect: got %q, want %q", got, want)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	file, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("Failed to load a missing file: %v", err)
+	}
+	if p, _, err := file.Profile(""); p != nil || err != nil {
+		t.Errorf("Expected no profile, got %+v, %v", p, err)
+	}
+	if _, _, err := file.Profile("missing"); err == nil {
+		t.Errorf("Expected an error for an unknown profile")
+	}
+}
+
+func TestEnvHelp(t *testing.T) {
+	// Every environment variable in the schema must be documented once
+	seen := make(map[string]bool)
+	for _, v := range EnvHelp() {
+		if seen[v.Name] {
+			t.Errorf("%s is listed twice", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Description == "" {
+			t.Errorf("%s has no description", v.Name)
+		}
+	}
+	if !seen["GOOGLE_CLIENT_ID"] || !seen["OAUTH2_TOKEN_CACHE"] {
+		t.Errorf("Expected variables are missing from the help")
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// Source is the layer a value came from. Later layers override earlier ones.
+type Source int
+
+const (
+	// SourceDefault is the built-in default
+	SourceDefault Source = iota
+	// SourceFile is the top level of the config file
+	SourceFile
+	// SourceProfile is the selected profile in the config file
+	SourceProfile
+	// SourceEnv is an environment variable
+	SourceEnv
+	// SourceFlag is a command-line flag
+	SourceFlag
+)
+
+// String returns the name of the source
+func (s Source) String() string {
+	switch s {
+	case SourceFile:
+		return "file"
+	case SourceProfile:
+		return "profile"
+	case SourceEnv:
+		return "env"
+	case SourceFlag:
+		return "flag"
+	default:
+		return "default"
+	}
+}
+
+// Value is the effective value of a setting and where it came from
+type Value struct {
+	Setting *Setting
+	Raw     string
+	Source  Source
+	// Origin says exactly where the value was set, e.g. a file and line,
+	// an environment variable or a flag
+	Origin string
+}
+
+// Display returns the value for showing to the user, hiding secrets.
+// File and stdin references are shown, since they only say where the
+// secret is; a command line might contain the secret itself.
+func (v *Value) Display() string {
+	if !v.Setting.Secret || v.Raw == "" {
+		return v.Raw
+	}
+	switch {
+	case strings.HasPrefix(v.Raw, SecretCmdPrefix):
+		return SecretCmdPrefix + "********"
+	case IsSecretRef(v.Raw):
+		return v.Raw
+	}
+	return "********"
+}
+
+// Values holds the effective value of every setting
+type Values struct {
+	// Profile is the name of the selected profile, or ""
+	Profile string
+
+	values map[string]*Value
+}
+
+// Resolve computes the effective settings from the defaults, the config
+// file and the selected profile, the environment and the flags, in that
+// order. flags holds the flags given on the command line by setting name.
+func Resolve(file *File, flags map[string]string) (*Values, error) {
+	v := &Values{values: make(map[string]*Value)}
+
+	// Flags and the environment select the profile, falling back to the file
+	profile := &Value{Setting: Lookup("profile")}
+	if name := file.DefaultProfile; name != "" {
+		profile.Raw, profile.Source, profile.Origin = name, SourceFile, file.origin(file.defaultProfileLine)
+	}
+	if err := applyExternal(profile, flags); err != nil {
+		return nil, err
+	}
+	profileSettings, name, err := file.Profile(profile.Raw)
+	if err != nil {
+		return nil, err
+	}
+	v.Profile = name
+
+	for _, s := range Schema {
+		if s.Group == GroupCommand {
+			continue
+		}
+		if s.Name() == "profile" {
+			v.values["profile"] = profile
+			continue
+		}
+
+		value := &Value{Setting: s, Raw: s.Default, Source: SourceDefault}
+		if fv, ok := file.settings[s.Key]; ok && s.Key != "" {
+			value.Raw, value.Source, value.Origin = fv.raw, SourceFile, file.origin(fv.line)
+		}
+		if fv, ok := profileSettings[s.Key]; ok && s.Key != "" {
+			value.Raw, value.Source, value.Origin = fv.raw, SourceProfile, fmt.Sprintf("profile %s (%s)", name, file.origin(fv.line))
+		}
+		if err := applyExternal(value, flags); err != nil {
+			return nil, err
+		}
+		v.values[s.Name()] = value
+	}
+
+	if err := v.resolveEndpoint(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// applyExternal applies the environment variable and the flag of a setting.
+// Empty environment variables count as unset, as with utils.GetEnv.
+func applyExternal(value *Value, flags map[string]string) error {
+	s := value.Setting
+	if s.Env != "" {
+		if raw := os.Getenv(s.Env); raw != "" {
+			if err := s.Validate(raw); err != nil {
+				return fmt.Errorf("environment variable %s: %w", s.Env, err)
+			}
+			value.Raw, value.Source, value.Origin = raw, SourceEnv, s.Env
+		}
+	}
+	if raw, ok := flags[s.Name()]; ok {
+		value.Raw, value.Source, value.Origin = raw, SourceFlag, "--"+s.Flag
+	}
+	return nil
+}
+
+// resolveEndpoint fills in the endpoints that weren't set from the selected
+// provider. A config file that sets its own endpoints replaces the Google
+// defaults as a whole, so endpoints the provider lacks aren't left
+// pointing at Google.
+func (v *Values) resolveEndpoint() error {
+	var base auth.Endpoint
+	provider := v.values["provider"]
+	switch {
+	case provider.Raw != "":
+		var ok bool
+		if base, ok = Providers[provider.Raw]; !ok {
+			return fmt.Errorf("%s: unknown provider %q", provider.Origin, provider.Raw)
+		}
+	case v.endpointFromFile():
+		// Start from no endpoints at all
+	default:
+		return nil
+	}
+
+	for key, field := range endpointSettings(&base) {
+		if value := v.values[key]; value.Source == SourceDefault {
+			value.Raw = *field
+		}
+	}
+	return nil
+}
+
+// endpointFromFile reports whether the config file sets any endpoint
+func (v *Values) endpointFromFile() bool {
+	for key := range endpointSettings(&auth.Endpoint{}) {
+		if s := v.values[key].Source; s == SourceFile || s == SourceProfile {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of a setting
+func (v *Values) Get(name string) *Value {
+	value, ok := v.values[name]
+	if !ok {
+		panic(fmt.Sprintf("config: unknown setting %q", name))
+	}
+	return value
+}
+
+// All returns the values in schema order
+func (v *Values) All() []*Value {
+	var all []*Value
+	for _, s := range Schema {
+		if value, ok := v.values[s.Name()]; ok {
+			all = append(all, value)
+		}
+	}
+	return all
+}
+
+// String returns a setting as a string
+func (v *Values) String(name string) string {
+	return v.Get(name).Raw
+}
+
+// Bool returns a setting as a boolean
+func (v *Values) Bool(name string) bool {
+	b, _ := parseBool(v.Get(name).Raw)
+	return b
+}
+
+// Int returns a setting as an integer, or 0 if it is empty
+func (v *Values) Int(name string) int {
+	n, _ := strconv.Atoi(v.Get(name).Raw)
+	return n
+}
+
+// Duration returns a setting as a duration
+func (v *Values) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(v.Get(name).Raw)
+	return d
+}
+
+// List returns a setting as a list
+func (v *Values) List(name string) []string {
+	return strings.Fields(v.Get(name).Raw)
+}
+
+// Secret returns a secret setting, resolved when its value is first needed
+func (v *Values) Secret(name string) *Secret {
+	return NewSecret(name, v.Get(name).Raw)
+}
+
+// Endpoint returns the provider endpoints
+func (v *Values) Endpoint() auth.Endpoint {
+	var e auth.Endpoint
+	for key, field := range endpointSettings(&e) {
+		*field = v.String(key)
+	}
+	return e
+}
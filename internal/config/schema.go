@@ -0,0 +1,303 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/pkg/utils"
+)
+
+// Kind is the type of a setting's value
+type Kind int
+
+const (
+	// String is a plain string
+	String Kind = iota
+	// Bool is true or false
+	Bool
+	// Int is a decimal integer
+	Int
+	// Duration is a Go duration like 5m or 30s
+	Duration
+	// List is a space-separated list, or an array in the config file
+	List
+)
+
+// Groups of settings, by the commands that use them
+const (
+	// GroupCommon settings are flags of every command
+	GroupCommon = "common"
+	// GroupLogin settings are flags of the commands that may log in
+	GroupLogin = "login"
+	// GroupCredential settings are flags of the credential helpers
+	GroupCredential = "credential"
+	// GroupCommand settings are flags of individual commands, registered by them
+	GroupCommand = "command"
+)
+
+// Setting describes one configuration value and every place it can come from
+type Setting struct {
+	// Key is the name in the config file, or "" if it can't be set there
+	Key string
+	// Flag is the command-line flag, or "" if there is none
+	Flag string
+	// Env is the environment variable, or "" if there is none
+	Env string
+
+	Kind    Kind
+	Default string
+	// DefaultHelp describes the default in the help when the value itself
+	// is too long to be useful
+	DefaultHelp string
+	Usage       string
+	Group       string
+
+	// Choices lists the valid values, if they are limited
+	Choices []string
+
+	// Required settings are listed first in the help
+	Required bool
+	// Secret values are never shown
+	Secret bool
+}
+
+// Name returns the name of the setting used in messages
+func (s *Setting) Name() string {
+	switch {
+	case s.Key != "":
+		return s.Key
+	case s.Flag != "":
+		return strings.ReplaceAll(s.Flag, "-", "_")
+	default:
+		return strings.ToLower(s.Env)
+	}
+}
+
+// Validate checks that raw is a valid value for the setting
+func (s *Setting) Validate(raw string) error {
+	switch s.Kind {
+	case Bool:
+		if _, err := parseBool(raw); err != nil {
+			return err
+		}
+	case Int:
+		if _, err := strconv.Atoi(raw); err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+	case Duration:
+		if _, err := time.ParseDuration(raw); err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+	}
+	if len(s.Choices) == 0 {
+		return nil
+	}
+
+	// Every item of a list must be one of the choices
+	values := []string{raw}
+	if s.Kind == List {
+		values = strings.Fields(raw)
+	}
+	for _, v := range values {
+		if !slices.Contains(s.Choices, v) {
+			return fmt.Errorf("invalid value %q (%s)", v, strings.Join(s.Choices, ", "))
+		}
+	}
+	return nil
+}
+
+// endpointSettings maps the endpoint settings to their fields
+func endpointSettings(e *auth.Endpoint) map[string]*string {
+	return map[string]*string{
+		"issuer":            &e.Issuer,
+		"auth_url":          &e.AuthURL,
+		"token_url":         &e.TokenURL,
+		"device_auth_url":   &e.DeviceAuthURL,
+		"revocation_url":    &e.RevocationURL,
+		"introspection_url": &e.IntrospectionURL,
+		"userinfo_url":      &e.UserInfoURL,
+		"end_session_url":   &e.EndSessionURL,
+		"jwks_url":          &e.JWKSURL,
+	}
+}
+
+// Schema lists every setting in the order they are shown
+var Schema = []*Setting{
+	{Key: "client_id", Flag: "client-id", Env: "GOOGLE_CLIENT_ID", Group: GroupCommon, Required: true,
+		Usage: "OAuth2 client ID"},
+	{Key: "client_secret", Flag: "client-secret", Env: "GOOGLE_CLIENT_SECRET", Group: GroupCommon, Required: true, Secret: true,
+		Usage: "OAuth2 client secret, or a file:, cmd: or stdin: reference to it"},
+
+	{Flag: "config", Env: "OAUTH2_CONFIG", Group: GroupCommon,
+		Usage: "Config file with profiles", DefaultHelp: "config.json in the user config dir"},
+	{Flag: "profile", Env: "OAUTH2_PROFILE", Group: GroupCommon,
+		Usage: "Profile from the config file to use", DefaultHelp: "the config file's default_profile"},
+	{Key: "account", Flag: "account", Env: "OAUTH2_ACCOUNT", Group: GroupCommon,
+		Usage: "Cached account to use, by subject or email"},
+
+	{Key: "redirect_uri", Env: "REDIRECT_URI", Group: GroupCommon, Default: "http://localhost:8080/oauth/callback",
+		Usage: "Callback URL"},
+	{Flag: "port", Group: GroupCommon, Kind: Int,
+		Usage: "Port for the callback server; overrides the redirect URI"},
+	{Flag: "callback-path", Group: GroupCommon,
+		Usage: "Path for the callback endpoint; overrides the redirect URI"},
+	{Key: "debug", Flag: "debug", Env: "DEBUG", Group: GroupCommon, Kind: Bool, Default: "true",
+		Usage: "Enable debug logging"},
+	{Key: "quiet", Flag: "quiet", Group: GroupCommon, Kind: Bool, Default: "false",
+		Usage: "Only log warnings and errors"},
+	{Key: "log_format", Flag: "log-format", Env: "OAUTH2_LOG_FORMAT", Group: GroupCommon, Default: "text",
+		Choices: []string{"text", "json", "logfmt"},
+		Usage:   "Log format: text, json or logfmt"},
+	{Key: "color", Flag: "color", Env: "OAUTH2_COLOR", Group: GroupCommon, Default: "auto",
+		Choices: []string{"auto", "always", "never"},
+		Usage:   "Color the text logs: auto (if they go to a terminal and NO_COLOR isn't set), always or never"},
+	{Key: "compact", Flag: "compact", Env: "OAUTH2_COMPACT", Group: GroupCommon, Kind: Bool, Default: "false",
+		Usage: "Leave the educational notes out of the text logs and show every step on one line"},
+	{Key: "unsafe_log", Flag: "unsafe-log", Env: "OAUTH2_UNSAFE_LOG", Group: GroupCommon, Kind: Bool, Default: "false",
+		Usage: "Show tokens, codes and secrets in the logs; for local teaching sessions only"},
+	{Key: "lang", Flag: "lang", Env: "OAUTH2_LANG", Group: GroupCommon, DefaultHelp: "the language of LC_ALL, LC_MESSAGES or LANG, or en",
+		Usage: "Language of the educational notes: " + strings.Join(catalog.Locales(), ", ")},
+	{Key: "tutor", Flag: "tutor", Env: "OAUTH2_TUTOR", Group: GroupCommon, Kind: Bool, Default: "false",
+		Usage: "Pause at every step and show, explain and let you change the parameters of every request before it's sent; reads stdin"},
+	{Key: "trace", Flag: "trace", Env: "OAUTH2_TRACE", Group: GroupCommon, Kind: List,
+		Choices: append(append([]string(nil), auth.TraceEndpoints...), auth.TraceAll),
+		Usage:   "Space-separated endpoints whose requests and responses are logged in full: token, device, userinfo, revocation, introspection or all"},
+	{Key: "record", Flag: "record", Env: "OAUTH2_RECORD", Group: GroupCommon,
+		Usage: "Record a transcript of the run to this file, for training and bug reports; show it with 'oauth2cli replay'"},
+	{Key: "report", Flag: "report", Env: "OAUTH2_REPORT", Group: GroupCommon,
+		Usage: "Write a walkthrough of the run with its steps, exchanges and tokens to this file, as HTML if it ends in .html and Markdown otherwise"},
+	{Key: "spans", Flag: "spans", Env: "OAUTH2_SPANS", Group: GroupCommon,
+		Usage: "Append OpenTelemetry spans of the flows to this file as OTLP JSON, one line per run, for the collector's otlpjsonfile receiver"},
+	{Key: "scopes", Flag: "scopes", Env: "OAUTH2_SCOPES", Group: GroupCommon, Kind: List, Default: "openid profile email",
+		Usage: "Space-separated scopes to request"},
+	{Key: "audience", Flag: "audience", Env: "OAUTH2_AUDIENCE", Group: GroupCommon,
+		Usage: "Audience to request"},
+	{Key: "timeout", Flag: "timeout", Env: "OAUTH2_TIMEOUT", Group: GroupCommon, Kind: Duration, Default: "5m",
+		Usage: "Timeout for the authorization flow"},
+
+	{Key: "provider", Flag: "provider", Env: "OAUTH2_PROVIDER", Group: GroupCommon,
+		Usage: "Provider with built-in endpoints", DefaultHelp: "google, unless the config file sets endpoints"},
+	{Key: "issuer", Flag: "issuer", Env: "OAUTH2_ISSUER", Group: GroupCommon, Default: auth.GoogleEndpoint.Issuer, DefaultHelp: "Google",
+		Usage: "Expected ID token issuer"},
+	{Key: "auth_url", Flag: "auth-url", Env: "OAUTH2_AUTH_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.AuthURL, DefaultHelp: "Google",
+		Usage: "Authorization endpoint"},
+	{Key: "token_url", Flag: "token-url", Env: "OAUTH2_TOKEN_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.TokenURL, DefaultHelp: "Google",
+		Usage: "Token endpoint"},
+	{Key: "device_auth_url", Flag: "device-auth-url", Env: "OAUTH2_DEVICE_AUTH_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.DeviceAuthURL, DefaultHelp: "Google",
+		Usage: "Device authorization endpoint, for --device"},
+	{Key: "revocation_url", Flag: "revocation-url", Env: "OAUTH2_REVOCATION_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.RevocationURL, DefaultHelp: "Google",
+		Usage: "Token revocation endpoint"},
+	{Key: "introspection_url", Flag: "introspection-url", Env: "OAUTH2_INTROSPECTION_URL", Group: GroupCommon,
+		Usage: "Token introspection endpoint"},
+	{Key: "userinfo_url", Flag: "userinfo-url", Env: "OAUTH2_USERINFO_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.UserInfoURL, DefaultHelp: "Google",
+		Usage: "UserInfo endpoint"},
+	{Key: "end_session_url", Flag: "end-session-url", Env: "OAUTH2_END_SESSION_URL", Group: GroupCommon,
+		Usage: "End session endpoint"},
+	{Key: "jwks_url", Flag: "jwks-url", Env: "OAUTH2_JWKS_URL", Group: GroupCommon, Default: auth.GoogleEndpoint.JWKSURL, DefaultHelp: "Google",
+		Usage: "JWKS endpoint"},
+
+	{Key: "token_cache", Flag: "token-cache", Env: "OAUTH2_TOKEN_CACHE", Group: GroupCommon,
+		Usage: "Token cache file", DefaultHelp: "tokens.json in the user config dir"},
+	{Key: "no_cache", Flag: "no-cache", Env: "OAUTH2_NO_CACHE", Group: GroupCommon, Kind: Bool, Default: "false",
+		Usage: "Don't read or write the token cache"},
+	{Key: "cache_passphrase", Env: "OAUTH2_CACHE_PASSPHRASE", Group: GroupCommon, Secret: true,
+		Usage: "Encrypt the token cache with a key derived from this passphrase, or a file:, cmd: or stdin: reference to it"},
+	{Key: "cache_key_file", Flag: "cache-key-file", Env: "OAUTH2_CACHE_KEY_FILE", Group: GroupCommon,
+		Usage: "Encrypt the token cache with the 32-byte key in this file"},
+	{Key: "cache_old_passphrase", Env: "OAUTH2_CACHE_OLD_PASSPHRASE", Group: GroupCommon, Secret: true,
+		Usage: "Previous passphrase, while rotating the cache key; also accepts references"},
+	{Key: "cache_old_key_file", Flag: "cache-old-key-file", Env: "OAUTH2_CACHE_OLD_KEY_FILE", Group: GroupCommon,
+		Usage: "Previous key file, while rotating the cache key"},
+
+	{Key: "no_browser", Flag: "no-browser", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Print the authorization URL instead of opening a browser"},
+	{Key: "manual", Flag: "manual", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Also accept the redirect URL pasted into stdin"},
+	{Key: "tls", Flag: "tls", Env: "OAUTH2_TLS", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Serve the callback over HTTPS"},
+	{Key: "tls_cert", Flag: "tls-cert", Env: "OAUTH2_TLS_CERT", Group: GroupLogin,
+		Usage: "Certificate for the HTTPS callback; generated if empty"},
+	{Key: "tls_key", Flag: "tls-key", Env: "OAUTH2_TLS_KEY", Group: GroupLogin,
+		Usage: "Key for the HTTPS callback"},
+	{Key: "pages_dir", Flag: "pages-dir", Env: "OAUTH2_PAGES_DIR", Group: GroupLogin,
+		Usage: "Directory with templates overriding the callback pages"},
+	{Key: "auto_close", Flag: "auto-close", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Close the browser window after the callback"},
+	{Key: "device", Flag: "device", Env: "OAUTH2_DEVICE", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Log in with the device flow: show a code to enter in a browser on any device instead of using a callback"},
+	{Key: "prompt", Flag: "prompt", Group: GroupLogin,
+		Usage: "Prompt to request, e.g. select_account to log in with another account"},
+	{Key: "health_checks", Flag: "health-checks", Env: "OAUTH2_HEALTH_CHECKS", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Serve /healthz and /readyz on the callback server"},
+	{Key: "metrics", Flag: "metrics", Env: "OAUTH2_METRICS", Group: GroupLogin, Kind: Bool, Default: "false",
+		Usage: "Serve Prometheus metrics of the flows on /metrics of the callback server"},
+
+	{Key: "credential_hosts", Flag: "hosts", Env: "OAUTH2_CREDENTIAL_HOSTS", Group: GroupCredential, Kind: List,
+		Usage: "Space-separated hosts the credential helpers answer for; required", DefaultHelp: "none"},
+	{Key: "credential_username", Flag: "username", Env: "OAUTH2_CREDENTIAL_USERNAME", Group: GroupCredential, Default: "oauth2",
+		Usage: "Username sent with the token by the credential helpers"},
+
+	{Env: "OAUTH2_TOKEN", Group: GroupCommand, Secret: true,
+		Usage: "Token for revoke, introspect, userinfo and decode"},
+	{Env: "OAUTH2_REFRESH_TOKEN", Group: GroupCommand, Secret: true,
+		Usage: "Refresh token for refresh"},
+	{Env: "OAUTH2_ID_TOKEN", Group: GroupCommand, Secret: true,
+		Usage: "ID token hint for logout"},
+}
+
+// Lookup returns the setting with the given config file key or flag name
+func Lookup(name string) *Setting {
+	for _, s := range Schema {
+		if s.Name() == name || (s.Flag != "" && s.Flag == name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// LookupEnv returns the setting read from the given environment variable
+func LookupEnv(env string) *Setting {
+	for _, s := range Schema {
+		if s.Env == env {
+			return s
+		}
+	}
+	return nil
+}
+
+// EnvHelp describes the environment variables of every setting, for
+// utils.PrintEnvHelp
+func EnvHelp() []utils.EnvVar {
+	var vars []utils.EnvVar
+	for _, s := range Schema {
+		if s.Env == "" {
+			continue
+		}
+
+		desc := s.Usage
+		switch {
+		case s.DefaultHelp != "":
+			desc += fmt.Sprintf(" (default: %s)", s.DefaultHelp)
+		case s.Default != "":
+			desc += fmt.Sprintf(" (default: %s)", s.Default)
+		}
+		vars = append(vars, utils.EnvVar{Name: s.Env, Description: desc, Required: s.Required})
+	}
+	return vars
+}
+
+// parseBool parses a boolean the way utils.GetEnvBool does, but strictly
+func parseBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "1", "true", "yes", "y":
+		return true, nil
+	case "0", "false", "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", raw)
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+	"golang.org/x/term"
+)
+
+// Prefixes of secret references
+const (
+	// SecretFilePrefix reads the secret from a file, e.g. file:~/.secrets/client
+	SecretFilePrefix = "file:"
+	// SecretCmdPrefix runs a command and uses its output, e.g. cmd:pass show oauth2/client
+	SecretCmdPrefix = "cmd:"
+	// SecretStdinPrefix reads the secret from the first line of stdin
+	SecretStdinPrefix = "stdin:"
+)
+
+// SecretCommandTimeout limits how long a cmd: helper may run
+const SecretCommandTimeout = 30 * time.Second
+
+// Stdin is where stdin: secrets are read from
+var Stdin io.Reader = os.Stdin
+
+// stdinUsed makes sure only one secret is read from stdin
+var (
+	stdinMu   sync.Mutex
+	stdinUsed string
+)
+
+// Secret is a secret-valued setting. A reference like file:, cmd: or
+// stdin: is only resolved when the value is first needed, so commands
+// that don't use the secret never run the helper or read the file.
+type Secret struct {
+	name string
+	ref  string
+
+	once  sync.Once
+	value string
+	err   error
+}
+
+// NewSecret creates a secret named after its setting from a value or a reference
+func NewSecret(name, ref string) *Secret {
+	return &Secret{name: name, ref: ref}
+}
+
+// IsSet reports whether a value or a reference was given
+func (s *Secret) IsSet() bool {
+	return s != nil && s.ref != ""
+}
+
+// String hides the secret, so it can't leak through fmt
+func (s *Secret) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return "********"
+}
+
+// Value resolves the secret and registers it with the logger, so it is
+// redacted from all log output
+func (s *Secret) Value() (string, error) {
+	if !s.IsSet() {
+		return "", nil
+	}
+
+	s.once.Do(func() {
+		s.value, s.err = resolveSecret(s.name, s.ref)
+		if s.err == nil {
+			logger.AddSecret(s.value)
+		}
+	})
+	return s.value, s.err
+}
+
+// IsSecretRef reports whether raw refers to a secret instead of being one
+func IsSecretRef(raw string) bool {
+	return strings.HasPrefix(raw, SecretFilePrefix) || strings.HasPrefix(raw, SecretCmdPrefix) || raw == SecretStdinPrefix
+}
+
+// resolveSecret reads a secret from its reference. Values without a known
+// prefix are used literally.
+func resolveSecret(name, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, SecretFilePrefix):
+		path := expandHome(strings.TrimPrefix(ref, SecretFilePrefix))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s from file: %w", name, err)
+		}
+		return trimSecret(string(data), name)
+
+	case strings.HasPrefix(ref, SecretCmdPrefix):
+		return runSecretCommand(name, strings.TrimPrefix(ref, SecretCmdPrefix))
+
+	case ref == SecretStdinPrefix:
+		return readSecretStdin(name)
+
+	default:
+		return ref, nil
+	}
+}
+
+// runSecretCommand runs a helper like pass or op and returns its output.
+// The helper's stderr goes to ours, so it can prompt the user.
+func runSecretCommand(name, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SecretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	// Don't log the command line itself, it may contain the secret's location
+	logger.Debug("Running the helper command for %s", name)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("helper command for %s failed: %w", name, err)
+	}
+	return trimSecret(stdout.String(), name)
+}
+
+// readSecretStdin reads a secret from the first line of stdin, with the
+// echo turned off when it is a terminal
+func readSecretStdin(name string) (string, error) {
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+
+	if stdinUsed != "" {
+		return "", fmt.Errorf("%s can't be read from stdin, %s already was", name, stdinUsed)
+	}
+	stdinUsed = name
+
+	// Prompt only when a person is typing, and don't echo what they type
+	if f, ok := Stdin.(*os.File); ok {
+		if term.IsTerminal(int(f.Fd())) {
+			fmt.Fprintf(os.Stderr, "Enter %s: ", name)
+			secret, err := term.ReadPassword(int(f.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return "", fmt.Errorf("failed to read %s from the terminal: %w", name, err)
+			}
+			return trimSecret(string(secret), name)
+		}
+	}
+
+	line, err := readLine(Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from stdin: %w", name, err)
+	}
+	return trimSecret(line, name)
+}
+
+// readLine reads the first line of r one byte at a time, without reading
+// ahead, so whatever follows the line is left for the command to read
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// trimSecret removes the trailing newline that files and commands usually
+// end with, and rejects empty secrets
+func trimSecret(value, name string) (string, error) {
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	return value, nil
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+func TestSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"literal-secret", "literal-secret"},
+		{"file:" + path, "from-file"},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, struct {
+			ref  string
+			want string
+		}{"cmd:printf 'from-cmd\\n'", "from-cmd"})
+	}
+
+	for _, tt := range tests {
+		got, err := NewSecret("client_secret", tt.ref).Value()
+		if err != nil {
+			t.Errorf("%s: failed to resolve: %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: secret is incorrect: got %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestSecretErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+
+	for _, ref := range []string{"file:" + empty, "file:" + empty + ".missing"} {
+		if _, err := NewSecret("client_secret", ref).Value(); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
+
+func TestSecretStdin(t *testing.T) {
+	defer func(r io.Reader) { Stdin = r }(Stdin)
+	stdin := strings.NewReader("typed-secret\nmore\n")
+	Stdin = stdin
+	stdinUsed = ""
+	defer func() { stdinUsed = "" }()
+
+	got, err := NewSecret("cache_passphrase", "stdin:").Value()
+	if err != nil || got != "typed-secret" {
+		t.Fatalf("Stdin secret is incorrect: got %q, %v", got, err)
+	}
+
+	// The rest of stdin is left for the command
+	if rest, _ := io.ReadAll(stdin); string(rest) != "more\n" {
+		t.Errorf("Rest of stdin is incorrect: got %q, want %q", rest, "more\n")
+	}
+
+	// Only one secret can be read from stdin
+	if _, err := NewSecret("client_secret", "stdin:").Value(); err == nil || !strings.Contains(err.Error(), "cache_passphrase") {
+		t.Errorf("Expected an error for a second stdin secret, got %v", err)
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	logger.DefaultLogger.SetWriter(&buf)
+	defer logger.DefaultLogger.SetWriter(os.Stdout)
+
+	secret := NewSecret("client_secret", "redact-me-please")
+	if !strings.Contains(fmt.Sprint(secret), "***") {
+		t.Errorf("Secret is shown by fmt: %v", secret)
+	}
+	value, err := secret.Value()
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+
+	logger.Warn("client_secret=%s", value)
+	if strings.Contains(buf.String(), value) || !strings.Contains(buf.String(), logger.Mask(value)) {
+		t.Errorf("Secret is not redacted: %q", buf.String())
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State is what the CLI remembers between runs besides tokens
+type State struct {
+	// ActiveAccounts maps a provider and client ID, as returned by
+	// AccountScope, to the subject of the account in use
+	ActiveAccounts map[string]string `json:"active_accounts,omitempty"`
+}
+
+// AccountScope returns the key under which the active account of a
+// provider and client is remembered
+func AccountScope(provider, clientID string) string {
+	return provider + "|" + clientID
+}
+
+// StatePath returns the location of the state file that goes with a config file
+func StatePath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "state.json")
+}
+
+// LoadState reads the state file at path. A missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	state := &State{ActiveAccounts: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if state.ActiveAccounts == nil {
+		state.ActiveAccounts = make(map[string]string)
+	}
+	return state, nil
+}
+
+// Save writes the state file, replacing it atomically
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
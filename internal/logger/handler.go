@@ -0,0 +1,278 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Format is the encoding of log records
+type Format string
+
+const (
+	// TextFormat is the human-readable output meant for reading along
+	TextFormat Format = "text"
+	// JSONFormat writes one JSON object per record
+	JSONFormat Format = "json"
+	// LogfmtFormat writes key=value pairs per record
+	LogfmtFormat Format = "logfmt"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{TextFormat, JSONFormat, LogfmtFormat}
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown log format %q", name)
+}
+
+// Color selects when the text format is colored
+type Color string
+
+const (
+	// ColorAuto colors the output if it is a terminal, unless NO_COLOR is
+	// set or TERM is dumb
+	ColorAuto Color = "auto"
+	// ColorAlways colors the output even if it is a pipe or a file
+	ColorAlways Color = "always"
+	// ColorNever writes plain output
+	ColorNever Color = "never"
+)
+
+// Colors lists the supported color modes
+var Colors = []Color{ColorAuto, ColorAlways, ColorNever}
+
+// ParseColor returns the color mode with the given name
+func ParseColor(name string) (Color, error) {
+	for _, c := range Colors {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown color mode %q", name)
+}
+
+// Attribute keys of the structured records
+const (
+	// StepKey is the number of a step of the flow
+	StepKey = "step"
+	// StepNameKey is the name of a step of the flow
+	StepNameKey = "step_name"
+	// TopicKey is the topic of an educational message, in the language of
+	// the message
+	TopicKey = "topic"
+	// TopicIDKey is the ID of the topic of an educational message from the
+	// catalog, the same in every language
+	TopicIDKey = "topic_id"
+	// FlowKey is the ID of the flow a record belongs to
+	FlowKey = "flow"
+)
+
+// newHandler creates the slog handler for a format. The Logger filters by
+// level itself, so the handler accepts every level. Color and compact only
+// apply to the text format.
+func newHandler(format Format, w io.Writer, color Color, compact bool) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch format {
+	case JSONFormat:
+		return slog.NewJSONHandler(w, opts)
+	case LogfmtFormat:
+		return slog.NewTextHandler(w, opts)
+	default:
+		return &textHandler{mu: &sync.Mutex{}, w: w, style: newTextStyle(w, color, compact)}
+	}
+}
+
+// textHandler writes records in the human-readable format, with
+// multi-line messages indented and steps and educational messages laid
+// out for reading
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	style textStyle
+	attrs []slog.Attr
+}
+
+// textStyle is how the text format renders records
+type textStyle struct {
+	// terminal is set when a person reads the output as it is written,
+	// which allows decorations such as emoji
+	terminal bool
+	// color adds ANSI colors and bold
+	color bool
+	// compact leaves out the educational messages and puts every step on
+	// one line
+	compact bool
+}
+
+// newTextStyle chooses the style for an output. Pipes and files get plain
+// output unless colors are forced.
+func newTextStyle(w io.Writer, color Color, compact bool) textStyle {
+	s := textStyle{terminal: isTerminal(w), compact: compact}
+	switch color {
+	case ColorAlways:
+		s.color = true
+	case ColorAuto:
+		s.color = s.terminal && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	}
+	return s
+}
+
+// isTerminal reports whether w is a terminal rather than a pipe or a file
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI escape sequences of the colored output
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// paint wraps text in ANSI escape sequences if the output is colored
+func (s textStyle) paint(text string, codes ...string) string {
+	if !s.color || text == "" {
+		return text
+	}
+	return strings.Join(codes, "") + text + ansiReset
+}
+
+// Enabled implements slog.Handler
+func (h *textHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// WithAttrs implements slog.Handler
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{mu: h.mu, w: h.w, style: h.style, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+// WithGroup implements slog.Handler. Groups are flattened in the text
+// format.
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Handle implements slog.Handler
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var step int64
+	var stepName, topic, flow string
+	var extra []string
+
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	for _, a := range attrs {
+		switch a.Key {
+		case StepKey:
+			step = a.Value.Int64()
+		case StepNameKey:
+			stepName = a.Value.String()
+		case TopicKey:
+			topic = a.Value.String()
+		case TopicIDKey:
+			// The topic title is shown instead
+		case FlowKey:
+			flow = a.Value.String()
+		default:
+			extra = append(extra, fmt.Sprintf("%s=%v", a.Key, a.Value.Any()))
+		}
+	}
+
+	message := r.Message
+	if len(extra) > 0 {
+		message += " " + strings.Join(extra, " ")
+	}
+
+	// The flow ID leads, so interleaved flows can be told apart
+	prefix := ""
+	if flow != "" {
+		prefix = "[" + flow + "] "
+	}
+
+	s := h.style
+	var lines []string
+	timestamp := r.Time.Format("15:04:05.000")
+	switch {
+	case topic != "" && s.compact:
+		return nil
+	case topic != "":
+		header := "EDUCATIONAL: " + strings.ToUpper(topic)
+		width := utf8.RuneCountInString(header)
+		if s.terminal {
+			// The emoji takes two columns
+			header = "📚 " + header
+			width += 3
+		}
+		separator := s.paint(strings.Repeat("-", width), ansiDim)
+		lines = []string{
+			s.formatLine(timestamp, r.Level, fmt.Sprintf("\n%s\n%s%s\n%s\n", separator, prefix, s.paint(header, ansiBold, ansiCyan), separator)),
+			s.formatLine(timestamp, r.Level, prefix+message),
+			s.formatLine(timestamp, r.Level, separator+"\n"),
+		}
+	case stepName != "" && s.compact:
+		header := s.paint(fmt.Sprintf("STEP %d: %s", step, strings.ToUpper(stepName)), ansiBold, ansiBlue)
+		lines = []string{s.formatLine(timestamp, r.Level, prefix+header+" - "+message)}
+	case stepName != "":
+		lines = []string{
+			s.formatLine(timestamp, r.Level, prefix+s.paint(fmt.Sprintf("STEP %d: %s", step, strings.ToUpper(stepName)), ansiBold, ansiBlue)),
+			s.formatLine(timestamp, r.Level, prefix+"  "+message),
+		}
+	default:
+		lines = []string{s.formatLine(timestamp, r.Level, prefix+message)}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(h.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatLine formats a message with timestamp and level, indenting the
+// lines after the first. Debug messages are dimmed, and warnings and errors
+// stand out in color.
+func (s textStyle) formatLine(timestamp string, level slog.Level, message string) string {
+	levelStr := ""
+	switch {
+	case level < slog.LevelInfo:
+		levelStr = s.paint("DEBUG", ansiDim)
+		message = s.paint(message, ansiDim)
+	case level < slog.LevelWarn:
+		levelStr = "INFO "
+	case level < slog.LevelError:
+		levelStr = s.paint("WARN ", ansiBold, ansiYellow)
+		message = s.paint(message, ansiYellow)
+	default:
+		levelStr = s.paint("ERROR", ansiBold, ansiRed)
+		message = s.paint(message, ansiRed)
+	}
+
+	formatted := fmt.Sprintf("%s %s: %s", s.paint("["+timestamp+"]", ansiDim), levelStr, message)
+	return strings.ReplaceAll(formatted, "\n", "\n                ")
+}
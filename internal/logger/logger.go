@@ -1,11 +1,17 @@
 package logger
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"strings"
+	"sync"
 	"time"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
 )
 
 // LogLevel represents the severity level of a log message
@@ -24,67 +30,237 @@ const (
 	DisabledLevel
 )
 
-// Logger provides educational logging for the OAuth2 flow
+// Logger provides educational logging for the OAuth2 flow. Records go to a
+// slog.Handler, which writes them as readable text, JSON or logfmt. A Logger
+// is safe for concurrent use, and loggers derived with With share its
+// settings.
 type Logger struct {
-	level  LogLevel
-	writer io.Writer
+	core  *core
+	attrs []slog.Attr
+}
+
+// core holds the settings shared by a logger and the loggers derived from it
+type core struct {
+	mu      sync.RWMutex
+	level   LogLevel
+	writer  io.Writer
+	format  Format
+	color   Color
+	compact bool
+	handler slog.Handler
+	// recorder receives every record, whatever the level
+	recorder slog.Handler
+	// stepHook is called after every step, whatever the level
+	stepHook func(stepNumber int, stepName, description string)
+	// tracer starts the spans of the flows, if they are traced
+	tracer Tracer
+
+	// secrets are values that must never appear in the output. They are
+	// added as they are resolved, possibly while another goroutine logs,
+	// so the slice is replaced rather than changed in place.
+	secrets []string
+	// secretRefs counts the AddSecret calls of every secret not yet undone
+	// by RemoveSecret, so a value added twice is listed once, and stays
+	// until both owners have dropped it
+	secretRefs map[string]int
+	// unsafe turns redaction off, for local teaching sessions
+	unsafe bool
 }
 
 // New creates a new Logger with the specified log level
 func New(level LogLevel) *Logger {
-	return &Logger{
-		level:  level,
-		writer: os.Stdout,
+	return &Logger{core: &core{
+		level:   level,
+		writer:  os.Stdout,
+		format:  TextFormat,
+		color:   ColorAuto,
+		handler: newHandler(TextFormat, os.Stdout, ColorAuto, false),
+	}}
+}
+
+// With returns a logger that adds the given attributes to every record,
+// such as the flow ID. The arguments are key-value pairs or slog.Attrs, as
+// for slog.Logger.With.
+func (l *Logger) With(args ...interface{}) *Logger {
+	var r slog.Record
+	r.Add(args...)
+
+	attrs := append([]slog.Attr(nil), l.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return &Logger{core: l.core, attrs: attrs}
+}
+
+// NewFlowID returns a random ID to tag the records of one flow with
+func NewFlowID() string {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		// Telling flows apart is a convenience, not worth failing for
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
 	}
+	return hex.EncodeToString(id)
 }
 
 // SetWriter sets the output writer for the logger
 func (l *Logger) SetWriter(w io.Writer) {
-	l.writer = w
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.writer = w
+	l.core.handler = newHandler(l.core.format, w, l.core.color, l.core.compact)
+}
+
+// SetFormat sets the encoding of the log records
+func (l *Logger) SetFormat(format Format) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.format = format
+	l.core.handler = newHandler(format, l.core.writer, l.core.color, l.core.compact)
+}
+
+// Format returns the encoding of the log records
+func (l *Logger) Format() Format {
+	l.core.mu.RLock()
+	defer l.core.mu.RUnlock()
+	return l.core.format
+}
+
+// SetColor sets when the text format is colored
+func (l *Logger) SetColor(color Color) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.color = color
+	l.core.handler = newHandler(l.core.format, l.core.writer, color, l.core.compact)
+}
+
+// SetCompact leaves the educational messages out of the text format and
+// puts every step on one line. Transcripts still record them.
+func (l *Logger) SetCompact(compact bool) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.compact = compact
+	l.core.handler = newHandler(l.core.format, l.core.writer, l.core.color, compact)
+}
+
+// SetHandler sends the records to a custom slog.Handler. SetWriter,
+// SetFormat, SetColor and SetCompact replace it again.
+func (l *Logger) SetHandler(h slog.Handler) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.handler = h
 }
 
 // SetLevel sets the minimum log level
 func (l *Logger) SetLevel(level LogLevel) {
-	l.level = level
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.level = level
+}
+
+// SetRecorder sends every record to h as well, whatever the level, such
+// as to record a transcript of the run. nil stops recording.
+func (l *Logger) SetRecorder(h slog.Handler) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.recorder = h
+}
+
+// SetStepHook calls f after every step is logged, whatever the level, such
+// as to pause the flow in tutor mode. nil removes the hook.
+func (l *Logger) SetStepHook(f func(stepNumber int, stepName, description string)) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.stepHook = f
 }
 
-// formatMessage formats a log message with timestamp and level
-func (l *Logger) formatMessage(level LogLevel, message string) string {
-	levelStr := ""
+// SetTracer starts the spans of StartSpan with t. nil stops tracing.
+func (l *Logger) SetTracer(t Tracer) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.tracer = t
+}
+
+// StartSpan starts a span with the tracer, as a child of the span in ctx if
+// there is one. The span gets the logger's attributes, such as the flow ID,
+// besides attrs. Without a tracer the span does nothing.
+func (l *Logger) StartSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	l.core.mu.RLock()
+	tracer := l.core.tracer
+	l.core.mu.RUnlock()
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return tracer.Start(ctx, name, append(append([]slog.Attr(nil), l.attrs...), attrs...)...)
+}
+
+// enabled reports whether messages at level are logged or recorded
+func (l *Logger) enabled(level LogLevel) bool {
+	l.core.mu.RLock()
+	defer l.core.mu.RUnlock()
+	return level >= l.core.level || l.core.recorder != nil
+}
+
+// slogLevel maps a LogLevel to the slog level
+func slogLevel(level LogLevel) slog.Level {
 	switch level {
 	case DebugLevel:
-		levelStr = "DEBUG"
-	case InfoLevel:
-		levelStr = "INFO "
+		return slog.LevelDebug
 	case WarnLevel:
-		levelStr = "WARN "
+		return slog.LevelWarn
 	case ErrorLevel:
-		levelStr = "ERROR"
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
 	}
-
-	timestamp := time.Now().Format("15:04:05.000")
-	return fmt.Sprintf("[%s] %s: %s", timestamp, levelStr, message)
 }
 
-// log logs a message at the specified level
-func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
-	if level < l.level {
+// emit sends a record to the handler, with secrets redacted from the
+// message and the string attributes
+func (l *Logger) emit(level LogLevel, message string, attrs ...slog.Attr) {
+	if !l.enabled(level) {
 		return
 	}
 
-	message := fmt.Sprintf(format, args...)
-	formattedMessage := l.formatMessage(level, message)
-
-	// For multi-line educational messages, indent subsequent lines
-	lines := strings.Split(formattedMessage, "\n")
-	if len(lines) > 1 {
-		for i := 1; i < len(lines); i++ {
-			lines[i] = "                " + lines[i]
+	r := slog.NewRecord(time.Now(), slogLevel(level), l.redact(message), 0)
+	for _, a := range append(append([]slog.Attr(nil), l.attrs...), attrs...) {
+		if a.Value.Kind() == slog.KindString {
+			a.Value = slog.StringValue(l.redact(a.Value.String()))
 		}
-		formattedMessage = strings.Join(lines, "\n")
+		r.AddAttrs(a)
 	}
+	l.core.mu.RLock()
+	handler, recorder, display := l.core.handler, l.core.recorder, level >= l.core.level
+	l.core.mu.RUnlock()
 
-	fmt.Fprintln(l.writer, formattedMessage)
+	// A broken log output must not break the flow
+	if recorder != nil {
+		_ = recorder.Handle(context.Background(), r)
+	}
+	if display {
+		_ = handler.Handle(context.Background(), r)
+	}
+}
+
+// Replay writes a recorded record as it is, with its original time, if its
+// level is enabled
+func (l *Logger) Replay(r slog.Record) {
+	l.core.mu.RLock()
+	handler, level := l.core.handler, l.core.level
+	l.core.mu.RUnlock()
+
+	if level == DisabledLevel || r.Level < slogLevel(level) {
+		return
+	}
+	_ = handler.Handle(context.Background(), r)
+}
+
+// log logs a message at the specified level
+func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.emit(level, fmt.Sprintf(format, args...))
 }
 
 // Debug logs a debug message
@@ -107,38 +283,86 @@ func (l *Logger) Error(format string, args ...interface{}) {
 	l.log(ErrorLevel, format, args...)
 }
 
-// Educational logs an educational message about the OAuth2 flow
+// Educational logs an educational message about the OAuth2 flow, as a
+// record with the topic attribute
 func (l *Logger) Educational(topic string, message string) {
-	if l.level > InfoLevel {
+	if !l.enabled(InfoLevel) {
 		return
 	}
 
-	header := fmt.Sprintf("📚 EDUCATIONAL: %s", strings.ToUpper(topic))
-	separator := strings.Repeat("-", len(header))
+	l.emit(InfoLevel, message, slog.String(TopicKey, topic))
+}
+
+// Teach logs the educational message of a topic from the catalog, in the
+// selected language, as a record with the topic and topic ID attributes
+func (l *Logger) Teach(topicID string) {
+	if !l.enabled(InfoLevel) {
+		return
+	}
 
-	l.Info("\n%s\n%s\n%s\n", separator, header, separator)
-	l.Info("%s", message)
-	l.Info("%s\n", separator)
+	m := catalog.Get(topicID)
+	l.emit(InfoLevel, m.Text, slog.String(TopicKey, m.Title), slog.String(TopicIDKey, topicID))
 }
 
-// Step logs a step in the OAuth2 flow process
+// Step logs a step in the OAuth2 flow process, as a record with the step
+// number and name attributes
 func (l *Logger) Step(stepNumber int, stepName string, description string) {
-	if l.level > InfoLevel {
-		return
+	if l.enabled(InfoLevel) {
+		l.emit(InfoLevel, description, slog.Int(StepKey, stepNumber), slog.String(StepNameKey, stepName))
 	}
 
-	l.Info("STEP %d: %s", stepNumber, strings.ToUpper(stepName))
-	l.Info("  %s", description)
+	l.core.mu.RLock()
+	hook := l.core.stepHook
+	l.core.mu.RUnlock()
+	if hook != nil {
+		hook(stepNumber, stepName, description)
+	}
 }
 
 // DefaultLogger is the default logger instance
 var DefaultLogger = New(InfoLevel)
 
+// With returns a logger derived from the default logger that adds the given
+// attributes to every record
+func With(args ...interface{}) *Logger {
+	return DefaultLogger.With(args...)
+}
+
 // SetDefaultLogLevel sets the log level for the default logger
 func SetDefaultLogLevel(level LogLevel) {
 	DefaultLogger.SetLevel(level)
 }
 
+// SetDefaultFormat sets the encoding of the default logger
+func SetDefaultFormat(format Format) {
+	DefaultLogger.SetFormat(format)
+}
+
+// SetDefaultColor sets when the default logger's text format is colored
+func SetDefaultColor(color Color) {
+	DefaultLogger.SetColor(color)
+}
+
+// SetDefaultCompact makes the default logger's text format compact
+func SetDefaultCompact(compact bool) {
+	DefaultLogger.SetCompact(compact)
+}
+
+// AddSecret makes the default logger mask value in every message
+func AddSecret(value string) {
+	DefaultLogger.AddSecret(value)
+}
+
+// RemoveSecret undoes an AddSecret of value on the default logger
+func RemoveSecret(value string) {
+	DefaultLogger.RemoveSecret(value)
+}
+
+// SetDefaultUnsafe turns redaction off in the default logger
+func SetDefaultUnsafe(unsafe bool) {
+	DefaultLogger.SetUnsafe(unsafe)
+}
+
 // Debug logs a debug message to the default logger
 func Debug(format string, args ...interface{}) {
 	DefaultLogger.Debug(format, args...)
@@ -164,6 +388,11 @@ func Educational(topic string, message string) {
 	DefaultLogger.Educational(topic, message)
 }
 
+// Teach logs the educational message of a topic to the default logger
+func Teach(topicID string) {
+	DefaultLogger.Teach(topicID)
+}
+
 // Step logs a step in the OAuth2 flow process to the default logger
 func Step(stepNumber int, stepName string, description string) {
 	DefaultLogger.Step(stepNumber, stepName, description)
@@ -0,0 +1,263 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(InfoLevel)
+	l.SetWriter(&buf)
+
+	l.Step(7, "Exchange Code", "Exchanging the code")
+	l.Info("first\nsecond")
+	l.Debug("hidden")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "INFO : STEP 7: EXCHANGE CODE") || !strings.HasSuffix(lines[1], "INFO :   Exchanging the code") {
+		t.Errorf("Step is formatted incorrectly:\n%s", buf.String())
+	}
+	if lines[3] != "                second" {
+		t.Errorf("Continuation line is not indented: %q", lines[3])
+	}
+}
+
+func TestColor(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(InfoLevel)
+	l.SetWriter(&buf)
+
+	// A buffer is not a terminal, so the output is plain
+	l.Step(7, "Exchange Code", "Exchanging the code")
+	l.Educational("PKCE", "The verifier stays here")
+	l.Warn("careful")
+	if strings.Contains(buf.String(), "\x1b[") || strings.Contains(buf.String(), "📚") {
+		t.Errorf("Output to a buffer is decorated:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "EDUCATIONAL: PKCE\n") {
+		t.Errorf("Educational header is missing:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	l.SetColor(ColorAlways)
+	l.Step(7, "Exchange Code", "Exchanging the code")
+	l.Warn("careful")
+	l.Error("failed")
+	output := buf.String()
+	for _, want := range []string{
+		ansiBold + ansiBlue + "STEP 7: EXCHANGE CODE" + ansiReset,
+		ansiBold + ansiYellow + "WARN " + ansiReset + ": " + ansiYellow + "careful" + ansiReset,
+		ansiBold + ansiRed + "ERROR" + ansiReset + ": " + ansiRed + "failed" + ansiReset,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Colored output lacks %q:\n%q", want, output)
+		}
+	}
+
+	buf.Reset()
+	l.SetColor(ColorNever)
+	l.Warn("careful")
+	if strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("Output is colored: %q", buf.String())
+	}
+}
+
+func TestCompact(t *testing.T) {
+	var buf bytes.Buffer
+	var records []slog.Record
+	l := New(InfoLevel)
+	l.SetWriter(&buf)
+	l.SetCompact(true)
+	l.SetRecorder(recordFunc(func(r slog.Record) { records = append(records, r) }))
+
+	l.Step(7, "Exchange Code", "Exchanging the code")
+	l.Educational("PKCE", "The verifier stays here")
+	l.Info("done")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "INFO : STEP 7: EXCHANGE CODE - Exchanging the code") {
+		t.Errorf("Step is not on one line: %q", lines[0])
+	}
+	if len(records) != 3 {
+		t.Errorf("Educational message is not recorded: %d records", len(records))
+	}
+
+	// Compact only applies to the text format
+	buf.Reset()
+	l.SetFormat(JSONFormat)
+	l.Educational("PKCE", "The verifier stays here")
+	if !strings.Contains(buf.String(), `"topic":"PKCE"`) {
+		t.Errorf("Educational record is missing: %s", buf.String())
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	if c, err := ParseColor("never"); err != nil || c != ColorNever {
+		t.Errorf("ParseColor is incorrect: got %q, %v", c, err)
+	}
+	if _, err := ParseColor("sometimes"); err == nil {
+		t.Error("Unknown color mode is accepted")
+	}
+}
+
+// recordFunc is a slog.Handler that passes every record to a function
+type recordFunc func(slog.Record)
+
+func (f recordFunc) Enabled(context.Context, slog.Level) bool { return true }
+func (f recordFunc) WithAttrs([]slog.Attr) slog.Handler       { return f }
+func (f recordFunc) WithGroup(string) slog.Handler            { return f }
+func (f recordFunc) Handle(_ context.Context, r slog.Record) error {
+	f(r)
+	return nil
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(DebugLevel)
+	l.SetWriter(&buf)
+	l.SetFormat(JSONFormat)
+	l.AddSecret("s3cr3t-value")
+
+	l.Step(7, "Exchange Code", "Exchanging the code")
+	l.Educational("PKCE", "The verifier stays here")
+	l.Warn("token=%s", "s3cr3t-value")
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Invalid JSON record %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+
+	if r := records[0]; r[StepKey] != float64(7) || r[StepNameKey] != "Exchange Code" || r["msg"] != "Exchanging the code" {
+		t.Errorf("Step record is incorrect: %v", r)
+	}
+	if r := records[1]; r[TopicKey] != "PKCE" || r["level"] != "INFO" {
+		t.Errorf("Educational record is incorrect: %v", r)
+	}
+	if r := records[2]; r["msg"] != "token="+Mask("s3cr3t-value") || r["level"] != "WARN" {
+		t.Errorf("Secret is not redacted: %v", r)
+	}
+}
+
+func TestTeach(t *testing.T) {
+	t.Cleanup(func() { catalog.SetLocale(catalog.DefaultLocale) })
+	if err := catalog.SetLocale("de"); err != nil {
+		t.Fatalf("Failed to select German: %v", err)
+	}
+
+	var buf bytes.Buffer
+	l := New(InfoLevel)
+	l.SetWriter(&buf)
+	l.SetFormat(JSONFormat)
+	l.Teach(catalog.TopicTokenExchange)
+
+	var r map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("Invalid JSON record %q: %v", buf.String(), err)
+	}
+	m := catalog.Get(catalog.TopicTokenExchange)
+	if r[TopicKey] != "Token-Austausch" || r[TopicIDKey] != catalog.TopicTokenExchange || r["msg"] != m.Text {
+		t.Errorf("Educational record is incorrect: %v", r)
+	}
+}
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(InfoLevel)
+	l.SetWriter(&buf)
+	flow := l.With(FlowKey, "ab12cd34")
+
+	flow.Step(3, "Start Server", "Starting")
+	l.Info("untagged")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], "INFO : [ab12cd34] STEP 3: START SERVER") || !strings.HasSuffix(lines[1], "INFO : [ab12cd34]   Starting") {
+		t.Errorf("Flow ID is missing:\n%s", buf.String())
+	}
+	if strings.Contains(lines[2], "ab12cd34") {
+		t.Errorf("Parent logger carries the flow ID: %q", lines[2])
+	}
+
+	// Derived loggers share the settings of their parent
+	buf.Reset()
+	l.SetFormat(JSONFormat)
+	flow.Info("tagged")
+	var r map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("Invalid JSON record %q: %v", buf.String(), err)
+	}
+	if r[FlowKey] != "ab12cd34" || r["msg"] != "tagged" {
+		t.Errorf("Flow record is incorrect: %v", r)
+	}
+}
+
+func TestStepHook(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(WarnLevel)
+	l.SetWriter(&buf)
+
+	var steps []string
+	l.SetStepHook(func(stepNumber int, stepName, description string) {
+		steps = append(steps, fmt.Sprintf("%d %s: %s", stepNumber, stepName, description))
+	})
+	l.With(FlowKey, "ab12cd34").Step(4, "Open Browser", "Opening")
+	l.SetStepHook(nil)
+	l.Step(5, "Wait for Authorization", "Waiting")
+
+	// The hook runs even when the steps aren't logged
+	if len(steps) != 1 || steps[0] != "4 Open Browser: Opening" {
+		t.Errorf("Steps are incorrect: %q", steps)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Steps were logged below the level:\n%s", buf.String())
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	l := New(InfoLevel)
+	l.SetWriter(io.Discard)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			flow := l.With(FlowKey, NewFlowID())
+			for j := 0; j < 100; j++ {
+				flow.Step(j, "Step", "message")
+				flow.AddSecret("concurrent-secret")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		l.SetLevel(LogLevel(i % 2))
+		l.SetWriter(io.Discard)
+		l.SetUnsafe(i%2 == 0)
+	}
+	wg.Wait()
+}
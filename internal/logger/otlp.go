@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// otlpExport is an OTLP/JSON ExportTraceServiceRequest of the
+// OpenTelemetry protocol. IDs are hex and 64-bit integers are strings.
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpResourceSpans are the spans of one service
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpResource describes the service
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+// otlpScopeSpans are the spans of one instrumentation scope
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+// otlpScope is an instrumentation scope
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpSpan is a span
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlpStatus is the outcome of a span
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpKeyValue is an attribute
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is the value of an attribute; exactly one field is set
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    string   `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	// otlpKindInternal is SPAN_KIND_INTERNAL
+	otlpKindInternal = 1
+	// otlpStatusError is STATUS_CODE_ERROR. Spans that succeeded keep the
+	// unset status, as OpenTelemetry SDKs do.
+	otlpStatusError = 2
+)
+
+// WriteOTLP writes the ended spans as one OTLP/JSON export request on a
+// single line, the format the OpenTelemetry Collector's otlpjsonfile
+// receiver reads, with service as the service name. Nothing is written if
+// no span ended.
+func (t *MemoryTracer) WriteOTLP(w io.Writer, service string) error {
+	spans := t.Spans()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	scope := otlpScopeSpans{Scope: otlpScope{Name: service}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        otlpAttributes(s.Attrs),
+		}
+		if s.Failed {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	export := otlpExport{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]slog.Attr{slog.String("service.name", service)})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+	data, err := json.Marshal(export)
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write spans: %w", err)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes to OTLP key-values. Kinds OTLP has no
+// value for are written as strings.
+func otlpAttributes(attrs []slog.Attr) []otlpKeyValue {
+	values := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		var value otlpAnyValue
+		switch v.Kind() {
+		case slog.KindBool:
+			b := v.Bool()
+			value.BoolValue = &b
+		case slog.KindInt64:
+			value.IntValue = strconv.FormatInt(v.Int64(), 10)
+		case slog.KindUint64:
+			value.IntValue = strconv.FormatUint(v.Uint64(), 10)
+		case slog.KindFloat64:
+			f := v.Float64()
+			value.DoubleValue = &f
+		default:
+			s := v.String()
+			value.StringValue = &s
+		}
+		values = append(values, otlpKeyValue{Key: a.Key, Value: value})
+	}
+	return values
+}
+
+// unixNano formats a time as OTLP does, in nanoseconds since the epoch
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
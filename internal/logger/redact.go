@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redactedPrefix starts every mask, so masks are recognized and not masked again
+const redactedPrefix = "[REDACTED"
+
+// sensitiveParams are the URL, form and JSON parameters that carry
+// credentials in OAuth2 and OpenID Connect
+var sensitiveParams = []string{
+	"access_token", "refresh_token", "id_token", "id_token_hint", "logout_token",
+	"code", "code_verifier", "device_code",
+	"client_secret", "client_assertion", "assertion", "password",
+	"token", "subject_token", "actor_token",
+}
+
+// Patterns of credentials in log messages. The group to mask is the last one.
+var (
+	paramNames = strings.Join(sensitiveParams, "|")
+
+	// code=... in URLs and form bodies
+	paramPattern = regexp.MustCompile(`(?i)(?:^|[?&;\s"'(])(?:` + paramNames + `)=([^&\s"'#;)]+)`)
+	// "code": "..." in JSON bodies
+	jsonPattern = regexp.MustCompile(`(?i)"(?:` + paramNames + `)"\s*:\s*"((?:[^"\\]|\\.)*)"`)
+	// Authorization: Bearer ... headers, with or without the scheme
+	headerPattern = regexp.MustCompile(`(?i)(?:proxy-)?authorization\s*[:=]\s*(?:(?:bearer|basic|dpop|token)\s+)?([^\s,"']+)`)
+	// Bearer ... outside of headers
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/=-]{8,})`)
+	// JWTs, such as ID tokens, anywhere
+	jwtPattern = regexp.MustCompile(`(eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*)`)
+)
+
+// maskKey makes masks consistent within a run, so a value can be followed
+// through the log, without letting masks from different runs be compared
+var maskKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("logger: failed to generate the mask key: " + err.Error())
+	}
+	return key
+}()
+
+// Mask returns the placeholder that replaces value in the logs. The same
+// value always gets the same placeholder within a run.
+func Mask(value string) string {
+	mac := hmac.New(sha256.New, maskKey)
+	mac.Write([]byte(value))
+	return redactedPrefix + ":" + hex.EncodeToString(mac.Sum(nil)[:4]) + "]"
+}
+
+// AddSecret makes the logger mask value in every message
+func (l *Logger) AddSecret(value string) {
+	// Very short values would redact ordinary words
+	if len(value) < 4 {
+		return
+	}
+
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	if l.core.secretRefs == nil {
+		l.core.secretRefs = make(map[string]int)
+	}
+	l.core.secretRefs[value]++
+	if l.core.secretRefs[value] == 1 {
+		l.core.secrets = append(slices.Clip(l.core.secrets), value)
+	}
+}
+
+// RemoveSecret undoes an AddSecret of value, for secrets that are no
+// longer used, such as the PKCE verifier of a finished flow. The value is
+// masked until every AddSecret of it has been undone.
+func (l *Logger) RemoveSecret(value string) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	if l.core.secretRefs[value] == 0 {
+		return
+	}
+	l.core.secretRefs[value]--
+	if l.core.secretRefs[value] == 0 {
+		delete(l.core.secretRefs, value)
+		l.core.secrets = slices.DeleteFunc(slices.Clone(l.core.secrets), func(s string) bool { return s == value })
+	}
+}
+
+// SetUnsafe turns redaction off, so tokens, codes and secrets appear in the
+// logs. It is meant for local teaching sessions only.
+func (l *Logger) SetUnsafe(unsafe bool) {
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	l.core.unsafe = unsafe
+}
+
+// Unsafe reports whether redaction is off
+func (l *Logger) Unsafe() bool {
+	l.core.mu.RLock()
+	defer l.core.mu.RUnlock()
+	return l.core.unsafe
+}
+
+// redact masks the known secrets and anything that looks like a credential:
+// sensitive parameters in URLs, forms and JSON, Authorization headers,
+// bearer tokens and JWTs
+func (l *Logger) redact(message string) string {
+	if l.Unsafe() {
+		return message
+	}
+	return l.redactAlways(message)
+}
+
+// redactAlways redacts message like redact, even when redaction is off
+func (l *Logger) redactAlways(message string) string {
+	l.core.mu.RLock()
+	secrets := l.core.secrets
+	l.core.mu.RUnlock()
+
+	// secrets is replaced, never changed in place, so the snapshot stays valid
+	for _, secret := range secrets {
+		if strings.Contains(message, secret) {
+			message = strings.ReplaceAll(message, secret, Mask(secret))
+		}
+	}
+
+	message = maskGroup(paramPattern, message, true)
+	message = maskGroup(jsonPattern, message, false)
+	message = maskGroup(headerPattern, message, false)
+	message = maskGroup(bearerPattern, message, false)
+	return maskGroup(jwtPattern, message, false)
+}
+
+// maskGroup masks the last group of every match of re. URL-encoded values
+// are decoded first, so they get the same mask as elsewhere.
+func maskGroup(re *regexp.Regexp, s string, urlEncoded bool) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[len(m)-2], m[len(m)-1]
+		if start < 0 || strings.HasPrefix(s[start:end], redactedPrefix) {
+			continue
+		}
+		value := s[start:end]
+		if urlEncoded {
+			if decoded, err := url.QueryUnescape(value); err == nil {
+				value = decoded
+			}
+		}
+
+		sb.WriteString(s[last:start])
+		sb.WriteString(Mask(value))
+		last = end
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// Redact masks credentials in s like the default logger does, for output
+// that doesn't go through the logger, such as error messages
+func Redact(s string) string {
+	return DefaultLogger.redact(s)
+}
+
+// RedactAlways masks credentials in s like Redact, even when redaction is
+// turned off with SetUnsafe, for output that is meant to be shared, such as
+// transcripts and reports
+func RedactAlways(s string) string {
+	return DefaultLogger.redactAlways(s)
+}
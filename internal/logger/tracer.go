@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Tracer starts the spans of a trace: the timed operations of a flow, such
+// as the wait for the browser or the token exchange, which a tracing
+// backend shows as a timeline. Its methods must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span, as a child of the span in ctx if there is one,
+	// and returns a context carrying the new span
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is a timed operation of a trace
+type Span interface {
+	// SetAttributes adds attributes to the span
+	SetAttributes(attrs ...slog.Attr)
+	// End ends the span. A non-nil err marks it as failed, with the OAuth
+	// error code of err if it has one.
+	End(err error)
+}
+
+// Attribute keys of the spans
+const (
+	// ProviderKey is the host of the provider a span talks to
+	ProviderKey = "oauth2.provider"
+	// GrantTypeKey is the grant type of a flow or a token request
+	GrantTypeKey = "oauth2.grant_type"
+	// ScopeCountKey is the number of scopes requested
+	ScopeCountKey = "oauth2.scope_count"
+	// ErrorCodeKey is the OAuth error code a span failed with, such as
+	// invalid_grant
+	ErrorCodeKey = "oauth2.error_code"
+)
+
+// ErrorCode returns the OAuth error code of err, if it or an error it
+// wraps has an ErrorCode method, or ""
+func ErrorCode(err error) string {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return ""
+}
+
+// noopSpan is the span of a logger without a tracer
+type noopSpan struct{}
+
+// SetAttributes implements Span
+func (noopSpan) SetAttributes(...slog.Attr) {}
+
+// End implements Span
+func (noopSpan) End(error) {}
+
+// SpanData is an ended span
+type SpanData struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    []slog.Attr
+	// Error is the redacted message of the error the span failed with,
+	// or "" if it succeeded
+	Error  string
+	Failed bool
+}
+
+// MemoryTracer keeps the ended spans in memory, to export them when the
+// run ends or to inspect them in tests
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewMemoryTracer creates a tracer that keeps the spans in memory
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// Start implements Tracer
+func (t *MemoryTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	s := &memorySpan{tracer: t, data: SpanData{
+		SpanID: randomID(8),
+		Name:   name,
+		Start:  time.Now(),
+		Attrs:  append([]slog.Attr(nil), attrs...),
+	}}
+	if parent, ok := ctx.Value(spanKey{}).(*memorySpan); ok {
+		s.data.TraceID, s.data.ParentID = parent.data.TraceID, parent.data.SpanID
+	} else {
+		s.data.TraceID = randomID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Spans returns the ended spans, in the order they ended
+func (t *MemoryTracer) Spans() []SpanData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SpanData(nil), t.spans...)
+}
+
+// memorySpan is a span of a MemoryTracer
+type memorySpan struct {
+	tracer *MemoryTracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SetAttributes implements Span
+func (s *memorySpan) SetAttributes(attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+}
+
+// End implements Span. Only the first call counts.
+func (s *memorySpan) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Failed = true
+		// Spans leave the machine for a collector, so they stay redacted
+		// even with SetUnsafe, like transcripts
+		s.data.Error = RedactAlways(err.Error())
+		if code := ErrorCode(err); code != "" {
+			s.data.Attrs = append(s.data.Attrs, slog.String(ErrorCodeKey, code))
+		}
+	}
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, data)
+}
+
+// randomID returns a random hex ID of n bytes, as trace and span IDs are
+func randomID(n int) string {
+	id := make([]byte, n)
+	// crypto/rand.Read never fails since Go 1.24
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// codedError is an error with an OAuth error code
+type codedError struct{ code string }
+
+func (e *codedError) Error() string     { return "failed with " + e.code }
+func (e *codedError) ErrorCode() string { return e.code }
+
+func TestMemoryTracer(t *testing.T) {
+	tracer := NewMemoryTracer()
+	l := New(InfoLevel).With(FlowKey, "ab12cd34")
+	l.SetTracer(tracer)
+
+	ctx, root := l.StartSpan(context.Background(), "oauth2.login", slog.Int(ScopeCountKey, 3))
+	_, child := l.StartSpan(ctx, "oauth2.token_exchange")
+	child.SetAttributes(slog.String(GrantTypeKey, "authorization_code"))
+	child.End(fmt.Errorf("token request failed: %w", &codedError{"invalid_grant"}))
+	child.End(nil)
+	root.End(nil)
+
+	// A new context starts a new trace
+	_, other := l.StartSpan(context.Background(), "oauth2.token_refresh")
+	other.End(nil)
+
+	spans := tracer.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	exchange, login, refresh := spans[0], spans[1], spans[2]
+	if login.ParentID != "" || exchange.ParentID != login.SpanID || exchange.TraceID != login.TraceID {
+		t.Errorf("Token exchange is not a child of the login: %+v, %+v", exchange, login)
+	}
+	if len(login.TraceID) != 32 || len(login.SpanID) != 16 {
+		t.Errorf("IDs have the wrong length: trace %q, span %q", login.TraceID, login.SpanID)
+	}
+	if refresh.TraceID == login.TraceID || refresh.ParentID != "" {
+		t.Errorf("Refresh is not a trace of its own: %+v", refresh)
+	}
+	if !exchange.Failed || exchange.Error != "token request failed: failed with invalid_grant" || login.Failed {
+		t.Errorf("Span status is incorrect: %+v, %+v", exchange, login)
+	}
+	if exchange.End.Before(exchange.Start) {
+		t.Errorf("Span ends before it starts: %v, %v", exchange.Start, exchange.End)
+	}
+
+	attrs := make(map[string]string)
+	for _, a := range exchange.Attrs {
+		attrs[a.Key] = a.Value.String()
+	}
+	if attrs[FlowKey] != "ab12cd34" || attrs[GrantTypeKey] != "authorization_code" || attrs[ErrorCodeKey] != "invalid_grant" {
+		t.Errorf("Span attributes are incorrect: %v", attrs)
+	}
+}
+
+func TestSpanErrorRedacted(t *testing.T) {
+	AddSecret("span-secret-value")
+	DefaultLogger.SetUnsafe(true)
+	defer DefaultLogger.SetUnsafe(false)
+
+	tracer := NewMemoryTracer()
+	l := New(InfoLevel)
+	l.SetTracer(tracer)
+	_, span := l.StartSpan(context.Background(), "oauth2.token_exchange")
+	span.End(errors.New("request with span-secret-value failed"))
+
+	if got := tracer.Spans()[0].Error; strings.Contains(got, "span-secret-value") {
+		t.Errorf("Span error is not redacted with unsafe logging: %q", got)
+	}
+}
+
+func TestNoTracer(t *testing.T) {
+	l := New(InfoLevel)
+	ctx := context.Background()
+	spanCtx, span := l.StartSpan(ctx, "oauth2.login")
+	span.SetAttributes(slog.Int(ScopeCountKey, 1))
+	span.End(errors.New("failed"))
+	if spanCtx != ctx {
+		t.Error("A span without a tracer changed the context")
+	}
+}
+
+func TestWriteOTLP(t *testing.T) {
+	tracer := NewMemoryTracer()
+	var buf bytes.Buffer
+	if err := tracer.WriteOTLP(&buf, "oauth2cli"); err != nil || buf.Len() != 0 {
+		t.Errorf("Spans were written without any span: %q, %v", buf.String(), err)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "oauth2.login", slog.Int(ScopeCountKey, 3), slog.Bool("cached", false))
+	_, child := tracer.Start(ctx, "oauth2.callback")
+	child.End(&codedError{"access_denied"})
+	root.End(nil)
+
+	if err := tracer.WriteOTLP(&buf, "oauth2cli"); err != nil {
+		t.Fatalf("Failed to write spans: %v", err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("Spans are not on one line: %q", buf.String())
+	}
+
+	var export struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("Invalid OTLP JSON %q: %v", buf.String(), err)
+	}
+	resource := export.ResourceSpans[0]
+	if a := resource.Resource.Attributes[0]; a.Key != "service.name" || *a.Value.StringValue != "oauth2cli" {
+		t.Errorf("Service name is incorrect: %+v", a)
+	}
+
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	callback, login := spans[0], spans[1]
+	if callback.ParentSpanID != login.SpanID || callback.Status.Code != otlpStatusError || callback.Status.Message != "failed with access_denied" {
+		t.Errorf("Callback span is incorrect: %+v", callback)
+	}
+	if login.Status.Code != 0 || login.StartTimeUnixNano == "" || login.Kind != otlpKindInternal {
+		t.Errorf("Login span is incorrect: %+v", login)
+	}
+	if a := login.Attributes[0]; a.Key != ScopeCountKey || a.Value.IntValue != "3" {
+		t.Errorf("Integer attribute is incorrect: %+v", a)
+	}
+	if a := login.Attributes[1]; a.Value.BoolValue == nil || *a.Value.BoolValue {
+		t.Errorf("Boolean attribute is incorrect: %+v", a)
+	}
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"net/url"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// Participants of the sequence diagram besides the provider's hosts
+const (
+	participantBrowser = "Browser"
+	participantCLI     = "oauth2cli"
+	participantAuthz   = "Authorization endpoint"
+)
+
+// Diagram is a sequence diagram of the exchanges of a run
+type Diagram struct {
+	Participants []string
+	Messages     []Message
+}
+
+// Message is an arrow in the sequence diagram
+type Message struct {
+	From  string
+	To    string
+	Label string
+	// Reply draws the arrow dashed, as a response
+	Reply bool
+}
+
+// browserMessages are the exchanges through the browser, which the CLI
+// doesn't see itself, by the step that starts them
+var browserMessages = map[string][]Message{
+	"Open Browser": {
+		{From: participantCLI, To: participantBrowser, Label: "Open the authorization URL"},
+		{From: participantBrowser, To: participantAuthz, Label: "Authorization request with code_challenge"},
+	},
+	"Authorization Code Received": {
+		{From: participantAuthz, To: participantBrowser, Label: "Redirect with code and state", Reply: true},
+		{From: participantBrowser, To: participantCLI, Label: "GET callback?code=…&state=…"},
+	},
+	"Generate Logout URL": {
+		{From: participantCLI, To: participantBrowser, Label: "Open the end session URL"},
+	},
+	"Logout Complete": {
+		{From: participantBrowser, To: participantCLI, Label: "Post-logout redirect"},
+	},
+}
+
+// newDiagram creates an empty diagram. Participants are added in the order
+// they appear.
+func newDiagram() *Diagram {
+	return &Diagram{}
+}
+
+// participant adds a participant if it's new
+func (d *Diagram) participant(name string) {
+	for _, p := range d.Participants {
+		if p == name {
+			return
+		}
+	}
+	d.Participants = append(d.Participants, name)
+}
+
+// add adds a message and its participants
+func (d *Diagram) add(m Message) {
+	d.participant(m.From)
+	d.participant(m.To)
+	d.Messages = append(d.Messages, m)
+}
+
+// step adds the browser exchanges started by a step
+func (d *Diagram) step(name string) {
+	for _, m := range browserMessages[name] {
+		d.add(m)
+	}
+}
+
+// exchange adds an HTTP exchange with the provider, addressed to its host
+func (d *Diagram) exchange(x *auth.Exchange) {
+	host := x.URL
+	if u, err := url.Parse(x.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	d.add(Message{From: participantCLI, To: host, Label: requestLine(x)})
+	d.add(Message{From: host, To: participantCLI, Label: outcome(x), Reply: true})
+}
+
+// Layout of the SVG diagram, in pixels
+const (
+	svgColumn = 200
+	svgMargin = 30
+	svgHeader = 50
+	svgRow    = 44
+)
+
+// SVGDiagram is the diagram laid out for drawing
+type SVGDiagram struct {
+	Width, Height int
+	Lifelines     []SVGLifeline
+	Arrows        []SVGArrow
+}
+
+// SVGLifeline is a participant's box and line
+type SVGLifeline struct {
+	Name   string
+	X      int
+	BoxX   int
+	Bottom int
+}
+
+// SVGArrow is a message
+type SVGArrow struct {
+	X1, X2, Y int
+	LabelX    int
+	Label     string
+	Reply     bool
+}
+
+// SVG lays the diagram out for the HTML report
+func (d *Diagram) SVG() *SVGDiagram {
+	x := make(map[string]int, len(d.Participants))
+	for i, p := range d.Participants {
+		x[p] = svgMargin + svgColumn/2 + i*svgColumn
+	}
+
+	height := svgHeader + (len(d.Messages)+1)*svgRow
+	s := &SVGDiagram{
+		Width:  2*svgMargin + len(d.Participants)*svgColumn,
+		Height: height,
+	}
+	for _, p := range d.Participants {
+		s.Lifelines = append(s.Lifelines, SVGLifeline{Name: p, X: x[p], BoxX: x[p] - svgColumn/2 + 10, Bottom: height - 10})
+	}
+	for i, m := range d.Messages {
+		s.Arrows = append(s.Arrows, SVGArrow{
+			X1:     x[m.From],
+			X2:     x[m.To],
+			Y:      svgHeader + (i+1)*svgRow,
+			LabelX: (x[m.From] + x[m.To]) / 2,
+			Label:  m.Label,
+			Reply:  m.Reply,
+		})
+	}
+	return s
+}
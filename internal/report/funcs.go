@@ -0,0 +1,109 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// timeLayout is how times are shown in reports
+const timeLayout = "2006-01-02 15:04:05 MST"
+
+// htmlFuncs are the functions of the HTML template
+var htmlFuncs = htmltemplate.FuncMap{
+	"join":    strings.Join,
+	"time":    formatTime,
+	"linkRFC": htmlLinkRFC,
+}
+
+// markdownFuncs are the functions of the Markdown template
+var markdownFuncs = texttemplate.FuncMap{
+	"join":    strings.Join,
+	"time":    formatTime,
+	"linkRFC": markdownLinkRFC,
+	"mermaid": mermaid,
+	"fence":   fence,
+	"quote":   quote,
+}
+
+// formatTime formats a time for a report
+func formatTime(t time.Time) string {
+	return t.Local().Format(timeLayout)
+}
+
+// htmlLinkRFC escapes a text and links the RFCs it mentions
+func htmlLinkRFC(text string) htmltemplate.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range rfcMention.FindAllStringSubmatchIndex(text, -1) {
+		match := submatches(text, loc)
+		b.WriteString(htmltemplate.HTMLEscapeString(text[last:loc[0]]))
+		fmt.Fprintf(&b, `<a href="%s">%s</a>`, htmltemplate.HTMLEscapeString(mentionURL(match)), htmltemplate.HTMLEscapeString(match[0]))
+		last = loc[1]
+	}
+	b.WriteString(htmltemplate.HTMLEscapeString(text[last:]))
+	return htmltemplate.HTML(b.String())
+}
+
+// markdownLinkRFC escapes the HTML in a text and links the RFCs it mentions
+func markdownLinkRFC(text string) string {
+	text = strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+	return rfcMention.ReplaceAllStringFunc(text, func(s string) string {
+		return fmt.Sprintf("[%s](%s)", s, mentionURL(rfcMention.FindStringSubmatch(s)))
+	})
+}
+
+// submatches returns the submatches of a match located by
+// FindAllStringSubmatchIndex, with "" for the ones that didn't participate
+func submatches(text string, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = text[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return match
+}
+
+// quote continues a Markdown block quote over the lines of a text
+func quote(text string) string {
+	return strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n> ")
+}
+
+// fence wraps a text in a Markdown code block, with a fence longer than any
+// run of backticks in the text
+func fence(lang, text string) string {
+	ticks := "```"
+	for strings.Contains(text, ticks) {
+		ticks += "`"
+	}
+	return ticks + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + ticks
+}
+
+// mermaid renders the diagram as a Mermaid sequence diagram. Participants
+// get short aliases, as host names aren't valid identifiers.
+func mermaid(d *Diagram) string {
+	alias := make(map[string]string, len(d.Participants))
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	for i, p := range d.Participants {
+		alias[p] = fmt.Sprintf("P%d", i)
+		fmt.Fprintf(&b, "    participant %s as %s\n", alias[p], mermaidText(p))
+	}
+	for _, m := range d.Messages {
+		arrow := "->>"
+		if m.Reply {
+			arrow = "-->>"
+		}
+		fmt.Fprintf(&b, "    %s%s%s: %s\n", alias[m.From], arrow, alias[m.To], mermaidText(m.Label))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// mermaidText escapes the characters Mermaid treats specially in names and
+// labels
+func mermaidText(s string) string {
+	return strings.NewReplacer(";", "#59;", "#", "#35;", "\n", " ").Replace(s)
+}
@@ -0,0 +1,116 @@
+package report
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// Reference links a note to the section of a specification it explains
+type Reference struct {
+	Title string
+	URL   string
+}
+
+// rfc returns a reference to a section of an RFC, or the whole RFC if
+// section is empty
+func rfc(number int, section, title string) Reference {
+	if section == "" {
+		return Reference{
+			Title: fmt.Sprintf("RFC %d: %s", number, title),
+			URL:   fmt.Sprintf("https://www.rfc-editor.org/rfc/rfc%d", number),
+		}
+	}
+	return Reference{
+		Title: fmt.Sprintf("RFC %d, section %s: %s", number, section, title),
+		URL:   fmt.Sprintf("https://www.rfc-editor.org/rfc/rfc%d#section-%s", number, section),
+	}
+}
+
+// oidcCore returns a reference to a section of OpenID Connect Core 1.0
+func oidcCore(anchor, title string) Reference {
+	return Reference{
+		Title: "OpenID Connect Core 1.0: " + title,
+		URL:   "https://openid.net/specs/openid-connect-core-1_0.html#" + anchor,
+	}
+}
+
+// topicReferences maps the topic IDs of the educational notes to the
+// specifications they explain
+var topicReferences = map[string][]Reference{
+	catalog.TopicAuthorizationURL: {
+		rfc(6749, "4.1.1", "Authorization Request"),
+		rfc(7636, "4.3", "Client Sends the Code Challenge with the Authorization Request"),
+	},
+	catalog.TopicPKCE: {
+		rfc(7636, "", "Proof Key for Code Exchange by OAuth Public Clients"),
+	},
+	catalog.TopicCallbackServer: {
+		rfc(8252, "7.3", "Loopback Interface Redirection"),
+	},
+	catalog.TopicHTTPSLoopbackRedirect: {
+		rfc(8252, "7.3", "Loopback Interface Redirection"),
+		rfc(8252, "8.3", "Loopback Redirect Considerations"),
+	},
+	catalog.TopicManualRedirect: {
+		rfc(8252, "7", "Receiving the Authorization Response in a Native App"),
+	},
+	catalog.TopicAuthorizationCode: {
+		rfc(6749, "4.1.2", "Authorization Response"),
+		rfc(6749, "10.12", "Cross-Site Request Forgery"),
+	},
+	catalog.TopicTokenExchange: {
+		rfc(6749, "4.1.3", "Access Token Request"),
+		rfc(7636, "4.5", "Client Sends the Authorization Code and the Code Verifier to the Token Endpoint"),
+	},
+	catalog.TopicOAuth2Tokens: {
+		rfc(6749, "5.1", "Successful Response"),
+		oidcCore("TokenResponse", "Successful Token Response"),
+	},
+	catalog.TopicIDToken: {
+		oidcCore("IDToken", "ID Token"),
+		rfc(7519, "", "JSON Web Token (JWT)"),
+	},
+	catalog.TopicDeviceAuthorization: {
+		rfc(8628, "3.1", "Device Authorization Request"),
+		rfc(8628, "3.4", "Device Access Token Request"),
+		rfc(8628, "3.5", "Device Access Token Response"),
+	},
+	catalog.TopicRefreshTokenGrant: {
+		rfc(6749, "6", "Refreshing an Access Token"),
+	},
+	catalog.TopicTokenRevocation: {
+		rfc(7009, "2", "Token Revocation"),
+	},
+	catalog.TopicTokenIntrospection: {
+		rfc(7662, "2", "Introspection Endpoint"),
+	},
+	catalog.TopicUserInfoEndpoint: {
+		oidcCore("UserInfo", "UserInfo Endpoint"),
+	},
+	catalog.TopicRPInitiatedLogout: {
+		{Title: "OpenID Connect RP-Initiated Logout 1.0", URL: "https://openid.net/specs/openid-connect-rpinitiated-1_0.html"},
+	},
+	catalog.TopicBackChannelLogout: {
+		{Title: "OpenID Connect Back-Channel Logout 1.0", URL: "https://openid.net/specs/openid-connect-backchannel-1_0.html"},
+	},
+}
+
+// referencesFor returns the references of a topic by its ID
+func referencesFor(topicID string) []Reference {
+	return topicReferences[topicID]
+}
+
+// rfcMention matches RFCs mentioned in a text, with an optional section:
+// "RFC 6749" or "RFC 6749, section 6"
+var rfcMention = regexp.MustCompile(`RFC (\d{4})(?:,? section (\d+(?:\.\d+)*))?`)
+
+// mentionURL returns the link for a match of rfcMention
+func mentionURL(match []string) string {
+	u := "https://www.rfc-editor.org/rfc/rfc" + match[1]
+	if match[2] != "" {
+		u += "#section-" + match[2]
+	}
+	return u
+}
@@ -0,0 +1,274 @@
+// Package report turns the transcript of a run into a walkthrough document,
+// in Markdown or self-contained HTML, with the steps and educational notes,
+// a sequence diagram of the exchanges, the decoded tokens and links to the
+// specifications
+package report
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/catalog"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/transcript"
+)
+
+// Format is the document format of a report
+type Format string
+
+const (
+	// Markdown renders the report as Markdown, with a Mermaid diagram
+	Markdown Format = "markdown"
+	// HTML renders the report as a single HTML file with an inline SVG diagram
+	HTML Format = "html"
+)
+
+// FormatFor picks the format by the file extension: HTML for .html and
+// .htm, Markdown otherwise
+func FormatFor(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return HTML
+	default:
+		return Markdown
+	}
+}
+
+//go:embed templates/*
+var templates embed.FS
+
+// sensitiveClaims are ID token claims that are masked in the report: they
+// tie the token to a session or to other tokens
+var sensitiveClaims = []string{"nonce", "at_hash", "c_hash", "sid"}
+
+// Report is a walkthrough of a recorded run
+type Report struct {
+	Command  string
+	Recorded time.Time
+
+	// Sections holds the steps in order. Notes logged before the first
+	// step are in a section without a number.
+	Sections []*Section
+	Diagram  *Diagram
+	Token    *Token
+
+	// Ended is set if the transcript records the end of the run
+	Ended    bool
+	ExitCode int
+	Error    string
+}
+
+// Section is a step of the flow with what happened during it
+type Section struct {
+	Step        int
+	Name        string
+	Description string
+	Time        time.Time
+
+	Notes     []*Note
+	Exchanges []*auth.Exchange
+	// Problems holds the warnings and errors logged during the step
+	Problems []string
+}
+
+// Note is an educational note
+type Note struct {
+	Topic      string
+	Text       string
+	References []Reference
+}
+
+// Token describes the token the run obtained, with the ID token claims
+type Token struct {
+	Type      string
+	ExpiresAt *time.Time
+	Scopes    []string
+	// Claims is the ID token payload, indented JSON
+	Claims string
+}
+
+// Title is the title of the report
+func (r *Report) Title() string {
+	return "OAuth2 walkthrough: oauth2cli " + r.Command
+}
+
+// References returns every reference of the notes, without duplicates
+func (r *Report) References() []Reference {
+	seen := make(map[string]bool)
+	var refs []Reference
+	for _, s := range r.Sections {
+		for _, n := range s.Notes {
+			for _, ref := range n.References {
+				if !seen[ref.URL] {
+					seen[ref.URL] = true
+					refs = append(refs, ref)
+				}
+			}
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Title < refs[j].Title })
+	return refs
+}
+
+// Build builds the report of a transcript
+func Build(t *transcript.Transcript) (*Report, error) {
+	start := t.Start()
+	r := &Report{Command: start.Command, Recorded: start.Time}
+	current := &Section{Time: start.Time}
+	d := newDiagram()
+
+	for _, e := range t.Events[1:] {
+		switch e.Type {
+		case transcript.TypeLog:
+			rec, err := e.Record()
+			if err != nil {
+				return nil, err
+			}
+			step, name, topic, topicID := logAttrs(rec)
+			if topicID == "" {
+				topicID = catalog.TopicID(topic)
+			}
+			switch {
+			case name != "":
+				if !current.empty() {
+					r.Sections = append(r.Sections, current)
+				}
+				current = &Section{Step: step, Name: name, Description: e.Message, Time: e.Time}
+				d.step(name)
+			case topic != "":
+				current.Notes = append(current.Notes, &Note{Topic: topic, Text: e.Message, References: referencesFor(topicID)})
+			case rec.Level >= slog.LevelWarn:
+				current.Problems = append(current.Problems, e.Message)
+			}
+
+		case transcript.TypeHTTP:
+			if e.Exchange != nil {
+				current.Exchanges = append(current.Exchanges, e.Exchange)
+				d.exchange(e.Exchange)
+			}
+
+		case transcript.TypeToken:
+			if e.Token != nil {
+				token, err := newToken(e.Token)
+				if err != nil {
+					return nil, err
+				}
+				r.Token = token
+			}
+
+		case transcript.TypeEnd:
+			r.Ended, r.ExitCode, r.Error = true, e.ExitCode, e.Error
+		}
+	}
+	if !current.empty() {
+		r.Sections = append(r.Sections, current)
+	}
+	if len(d.Messages) > 0 {
+		r.Diagram = d
+	}
+	return r, nil
+}
+
+// empty reports whether a section has nothing to show
+func (s *Section) empty() bool {
+	return s.Name == "" && len(s.Notes) == 0 && len(s.Exchanges) == 0 && len(s.Problems) == 0
+}
+
+// logAttrs returns the step and topic attributes of a log record
+func logAttrs(rec slog.Record) (step int, name, topic, topicID string) {
+	rec.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case logger.StepKey:
+			step = int(a.Value.Int64())
+		case logger.StepNameKey:
+			name = a.Value.String()
+		case logger.TopicKey:
+			topic = a.Value.String()
+		case logger.TopicIDKey:
+			topicID = a.Value.String()
+		}
+		return true
+	})
+	return step, name, topic, topicID
+}
+
+// newToken describes a recorded token, masking the claims that tie it to a
+// session
+func newToken(info *auth.TokenInfo) (*Token, error) {
+	t := &Token{Type: info.TokenType, ExpiresAt: info.ExpiresAt, Scopes: info.Scopes}
+	if len(info.Claims) == 0 {
+		return t, nil
+	}
+
+	claims := make(map[string]interface{}, len(info.Claims))
+	for name, value := range info.Claims {
+		if s, ok := value.(string); ok {
+			value = logger.RedactAlways(s)
+			for _, sensitive := range sensitiveClaims {
+				if name == sensitive {
+					value = logger.Mask(s)
+				}
+			}
+		}
+		claims[name] = value
+	}
+	data, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode claims: %w", err)
+	}
+	t.Claims = string(data)
+	return t, nil
+}
+
+// Write renders the report in the given format
+func (r *Report) Write(w io.Writer, format Format) error {
+	var err error
+	switch format {
+	case HTML:
+		var tmpl *htmltemplate.Template
+		tmpl, err = htmltemplate.New("report.html").Funcs(htmlFuncs).ParseFS(templates, "templates/report.html")
+		if err == nil {
+			err = tmpl.Execute(w, r)
+		}
+	case Markdown:
+		var tmpl *texttemplate.Template
+		tmpl, err = texttemplate.New("report.md").Funcs(markdownFuncs).ParseFS(templates, "templates/report.md")
+		if err == nil {
+			err = tmpl.Execute(w, r)
+		}
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	return nil
+}
+
+// requestLine returns the method and path of an exchange
+func requestLine(x *auth.Exchange) string {
+	path := x.URL
+	if u, err := url.Parse(x.URL); err == nil {
+		path = u.Path
+	}
+	return x.Method + " " + path
+}
+
+// outcome describes the response of an exchange
+func outcome(x *auth.Exchange) string {
+	if x.Error != "" {
+		return "failed: " + x.Error
+	}
+	return x.Status
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/transcript"
+)
+
+// recordRun records a transcript of a login with a token exchange
+func recordRun(t *testing.T) *transcript.Transcript {
+	t.Helper()
+
+	var buf bytes.Buffer
+	rec := transcript.NewRecorder(&buf, "login")
+	logger.DefaultLogger.SetWriter(&bytes.Buffer{})
+	logger.DefaultLogger.SetRecorder(rec.Handler())
+	t.Cleanup(func() {
+		logger.DefaultLogger.SetRecorder(nil)
+		logger.DefaultLogger.SetWriter(os.Stdout)
+	})
+
+	logger.Step(4, "Open Browser", "Opening the authorization URL in the browser")
+	logger.Educational("PKCE", "The code challenge is sent now, the verifier only with the code (RFC 7636, section 4.5)")
+	logger.Step(6, "Authorization Code Received", "The provider redirected back with a code")
+	logger.Step(7, "Exchange Code for Token", "Exchanging the code for tokens")
+	logger.Educational("Token Exchange", "The code is sent to the token endpoint <once>")
+	rec.RecordExchange(&auth.Exchange{
+		Endpoint:       "token",
+		Method:         http.MethodPost,
+		URL:            "https://idp.example.com/token",
+		Proto:          "HTTP/1.1",
+		RequestHeader:  http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+		RequestBody:    "grant_type=authorization_code&code=code-4711",
+		StatusCode:     http.StatusOK,
+		Status:         "200 OK",
+		ResponseProto:  "HTTP/1.1",
+		ResponseHeader: http.Header{"Content-Type": {"application/json"}},
+		ResponseBody:   `{"access_token":"at-secret-value","token_type":"Bearer"}`,
+		Duration:       40 * time.Millisecond,
+	})
+	logger.Warn("The provider didn't return a refresh token")
+	expires := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	rec.RecordToken(&auth.TokenInfo{
+		TokenType: "Bearer",
+		ExpiresAt: &expires,
+		Scopes:    []string{"openid", "email"},
+		Claims:    map[string]interface{}{"email": "user@example.com", "nonce": "nonce-value"},
+	})
+
+	if err := rec.Close(0, nil); err != nil {
+		t.Fatalf("Failed to close transcript: %v", err)
+	}
+	tr, err := transcript.Read(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read transcript: %v", err)
+	}
+	return tr
+}
+
+func TestBuild(t *testing.T) {
+	r, err := Build(recordRun(t))
+	if err != nil {
+		t.Fatalf("Failed to build report: %v", err)
+	}
+
+	if r.Command != "login" || !r.Ended || r.ExitCode != 0 {
+		t.Errorf("Outcome is incorrect: command %q, ended %v, exit code %d", r.Command, r.Ended, r.ExitCode)
+	}
+
+	var names []string
+	for _, s := range r.Sections {
+		names = append(names, s.Name)
+	}
+	if got, want := strings.Join(names, ","), "Open Browser,Authorization Code Received,Exchange Code for Token"; got != want {
+		t.Fatalf("Sections are incorrect: got %q, want %q", got, want)
+	}
+
+	exchange := r.Sections[2]
+	if len(exchange.Notes) != 1 || exchange.Notes[0].Topic != "Token Exchange" || len(exchange.Notes[0].References) == 0 {
+		t.Errorf("Notes are incorrect: %+v", exchange.Notes)
+	}
+	if len(exchange.Exchanges) != 1 || len(exchange.Problems) != 1 {
+		t.Errorf("Step has %d exchanges and %d problems, want 1 and 1", len(exchange.Exchanges), len(exchange.Problems))
+	}
+
+	if r.Diagram == nil {
+		t.Fatal("Diagram is missing")
+	}
+	last := r.Diagram.Messages[len(r.Diagram.Messages)-1]
+	if last.From != "idp.example.com" || last.To != participantCLI || !last.Reply || last.Label != "200 OK" {
+		t.Errorf("Token response message is incorrect: %+v", last)
+	}
+
+	if r.Token == nil || !strings.Contains(r.Token.Claims, "user@example.com") {
+		t.Fatalf("Token is incorrect: %+v", r.Token)
+	}
+	if strings.Contains(r.Token.Claims, "nonce-value") {
+		t.Errorf("Claims contain the nonce:\n%s", r.Token.Claims)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r, err := Build(recordRun(t))
+	if err != nil {
+		t.Fatalf("Failed to build report: %v", err)
+	}
+
+	tests := []struct {
+		format Format
+		want   []string
+	}{
+		{Markdown, []string{
+			"# OAuth2 walkthrough: oauth2cli login",
+			"```mermaid\nsequenceDiagram\n",
+			"P0->>P3: POST /token",
+			"## Step 7: Exchange Code for Token",
+			"[RFC 7636, section 4.5](https://www.rfc-editor.org/rfc/rfc7636#section-4.5)",
+			"(https://www.rfc-editor.org/rfc/rfc6749#section-4.1.3)",
+			"<summary>",
+			"- Scopes: openid email",
+			"&lt;once&gt;",
+		}},
+		{HTML, []string{
+			"<title>OAuth2 walkthrough: oauth2cli login</title>",
+			"<svg ",
+			">idp.example.com</text>",
+			"<h2>Step 7: Exchange Code for Token</h2>",
+			`<a href="https://www.rfc-editor.org/rfc/rfc7636#section-4.5">RFC 7636, section 4.5</a>`,
+			"&lt;once&gt;",
+			"user@example.com",
+		}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := r.Write(&buf, tt.format); err != nil {
+			t.Fatalf("Failed to write %s report: %v", tt.format, err)
+		}
+		out := buf.String()
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s report doesn't contain %q:\n%s", tt.format, want, out)
+			}
+		}
+		for _, secret := range []string{"code-4711", "at-secret-value", "nonce-value"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s report contains %q", tt.format, secret)
+			}
+		}
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	tests := map[string]Format{
+		"walkthrough.html": HTML,
+		"walkthrough.HTM":  HTML,
+		"walkthrough.md":   Markdown,
+		"walkthrough":      Markdown,
+	}
+	for path, want := range tests {
+		if got := FormatFor(path); got != want {
+			t.Errorf("Format for %q is incorrect: got %q, want %q", path, got, want)
+		}
+	}
+}
@@ -2,24 +2,41 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/korjavin/oauth2example/internal/catalog"
 	"github.com/korjavin/oauth2example/internal/logger"
 )
 
 // CallbackServer is a local HTTP server that receives the OAuth2 callback
 type CallbackServer struct {
-	server     *http.Server
-	port       int
-	path       string
-	codeChan   chan string
-	errChan    chan error
-	once       sync.Once
-	shutdownWg sync.WaitGroup
+	server        *http.Server
+	port          int
+	path          string
+	pages         *Pages
+	tlsConfig     *tls.Config
+	expectedState string
+	logout        *LogoutOptions
+	logoutState   string
+	logoutChan    chan error
+	metrics       *Metrics
+	healthChecks  bool
+	ready         atomic.Bool
+	flowActive    atomic.Bool
+	codeChan      chan string
+	errChan       chan error
+	resultOnce    sync.Once
+	once          sync.Once
+	shutdownWg    sync.WaitGroup
+	log           *logger.Logger
+	traceCtx      context.Context
 }
 
 // NewCallbackServer creates a new callback server
@@ -27,18 +44,72 @@ func NewCallbackServer(port int, path string) *CallbackServer {
 	return &CallbackServer{
 		port:     port,
 		path:     path,
+		pages:    DefaultPages(),
 		codeChan: make(chan string, 1),
 		errChan:  make(chan error, 1),
+		log:      logger.DefaultLogger,
+		traceCtx: context.Background(),
 	}
 }
 
+// SetLogger sets the logger of the server, usually the one of the flow it
+// receives the callback for, so its lines carry the flow ID
+func (s *CallbackServer) SetLogger(l *logger.Logger) {
+	s.log = l
+}
+
+// SetTraceContext sets the context carrying the span of the flow, so the
+// span of the callback is its child. It must be called before Start.
+func (s *CallbackServer) SetTraceContext(ctx context.Context) {
+	s.traceCtx = ctx
+}
+
+// SetPages sets the pages shown in the browser after the callback
+func (s *CallbackServer) SetPages(pages *Pages) {
+	s.pages = pages
+}
+
+// SetExpectedState sets the state sent in the authorization request.
+// Callbacks carrying a different state are rejected.
+func (s *CallbackServer) SetExpectedState(state string) {
+	s.expectedState = state
+}
+
+// SetMetrics records callback metrics in m and serves them on /metrics.
+// It must be called before Start.
+func (s *CallbackServer) SetMetrics(m *Metrics) {
+	s.metrics = m
+}
+
+// Metrics returns the metrics set with SetMetrics, or nil
+func (s *CallbackServer) Metrics() *Metrics {
+	return s.metrics
+}
+
+// EnableHealthChecks serves /healthz and /readyz. It must be called before Start.
+func (s *CallbackServer) EnableHealthChecks() {
+	s.healthChecks = true
+}
+
 // Start starts the callback server
 func (s *CallbackServer) Start() error {
-	addr := fmt.Sprintf(":%d", s.port)
+	// Only this machine's browser needs to reach the callback, and the
+	// health and metrics endpoints are not meant for the network either
+	addr := fmt.Sprintf("127.0.0.1:%d", s.port)
 
 	// Create a new HTTP server
 	mux := http.NewServeMux()
 	mux.HandleFunc(s.path, s.handleCallback)
+	if s.logout != nil {
+		s.registerLogoutHandlers(mux)
+	}
+	if s.healthChecks {
+		mux.HandleFunc(HealthPath, s.handleHealth)
+		mux.HandleFunc(ReadyPath, s.handleReady)
+	}
+	if s.metrics != nil {
+		mux.Handle(MetricsPath, s.metrics)
+	}
 
 	s.server = &http.Server{
 		Addr:    addr,
@@ -51,24 +122,39 @@ func (s *CallbackServer) Start() error {
 		return fmt.Errorf("port %d is not available: %w", s.port, err)
 	}
 
-	logger.Step(3, "Starting Local Callback Server",
-		fmt.Sprintf("Starting server on http://localhost:%d%s to receive the authorization code",
-			s.port, s.path))
+	// Pick up the actual port if an ephemeral one was requested
+	if s.port == 0 {
+		s.port = listener.Addr().(*net.TCPAddr).Port
+	}
+
+	// Wrap the listener if HTTPS is enabled
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	s.log.Step(3, "Starting Local Callback Server",
+		fmt.Sprintf("Starting server on %s to receive the authorization code",
+			s.GetRedirectURI()))
+
+	if s.tlsConfig != nil {
+		s.log.Info("Callback server certificate SHA-256 fingerprint: %s", s.CertificateFingerprint())
+		s.log.Teach(catalog.TopicHTTPSLoopbackRedirect)
+	}
+
+	s.log.Teach(catalog.TopicCallbackServer)
 
-	logger.Educational("Callback Server",
-		"The callback server is a local HTTP server that receives the authorization code\n"+
-			"from the OAuth2 provider after the user has authenticated and authorized the application.\n"+
-			"This is a crucial part of the OAuth2 flow, as it allows the application to securely\n"+
-			"receive the authorization code without requiring the user to manually copy and paste it.")
+	s.flowActive.Store(true)
+	s.metrics.FlowStarted()
+	s.ready.Store(true)
 
 	s.shutdownWg.Add(1)
 	go func() {
 		defer s.shutdownWg.Done()
 
 		// Start the server
-		logger.Debug("Callback server listening on %s", addr)
+		s.log.Debug("Callback server listening on %s", addr)
 		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			s.errChan <- fmt.Errorf("callback server error: %w", err)
+			s.deliverError(fmt.Errorf("callback server error: %w", err))
 		}
 	}()
 
@@ -90,107 +176,128 @@ func (s *CallbackServer) WaitForCode(ctx context.Context) (string, error) {
 // Stop stops the callback server
 func (s *CallbackServer) Stop() {
 	s.once.Do(func() {
-		logger.Debug("Stopping callback server")
+		s.log.Debug("Stopping callback server")
+		s.ready.Store(false)
+		s.finishFlow()
 
 		// Create a context with a timeout for shutdown
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		// Shutdown the server
+		if s.server == nil {
+			return
+		}
 		if err := s.server.Shutdown(ctx); err != nil {
-			logger.Error("Error shutting down callback server: %v", err)
+			s.log.Error("Error shutting down callback server: %v", err)
 		}
 
 		// Wait for the server to finish
 		s.shutdownWg.Wait()
-		logger.Debug("Callback server stopped")
+		s.log.Debug("Callback server stopped")
 	})
 }
 
 // GetRedirectURI returns the full redirect URI for this callback server
 func (s *CallbackServer) GetRedirectURI() string {
-	return fmt.Sprintf("http://localhost:%d%s", s.port, s.path)
+	return fmt.Sprintf("%s://localhost:%d%s", s.scheme(), s.port, s.path)
+}
+
+// scheme returns the URL scheme the callback server is reachable with
+func (s *CallbackServer) scheme() string {
+	if s.tlsConfig != nil {
+		return "https"
+	}
+	return "http"
 }
 
 // handleCallback handles the OAuth2 callback request
 func (s *CallbackServer) handleCallback(w http.ResponseWriter, r *http.Request) {
-	logger.Debug("Received callback request: %s", r.URL.String())
-
-	// Extract the authorization code from the request
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		logger.Error("No authorization code received")
-		http.Error(w, "No authorization code received", http.StatusBadRequest)
-		s.errChan <- fmt.Errorf("no authorization code received")
-		return
-	}
+	s.log.Debug("Received callback request: %s", r.URL.String())
 
-	// Extract error if present
-	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
-		errDesc := r.URL.Query().Get("error_description")
-		logger.Error("OAuth error: %s - %s", errMsg, errDesc)
-		http.Error(w, fmt.Sprintf("OAuth error: %s - %s", errMsg, errDesc), http.StatusBadRequest)
-		s.errChan <- fmt.Errorf("oauth error: %s - %s", errMsg, errDesc)
+	s.metrics.CallbackReceived()
+
+	_, span := s.log.StartSpan(s.traceCtx, "oauth2.callback")
+	code, err := ValidateCallback(r.URL.Query(), s.expectedState)
+	span.End(err)
+	if err != nil {
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			s.metrics.OAuthError(oauthErr.Code)
+		} else if errors.Is(err, ErrStateMismatch) {
+			s.metrics.StateMismatch()
+		}
+
+		switch {
+		case errors.As(err, &oauthErr) && oauthErr.Cancelled():
+			// access_denied means the user declined the consent screen
+			s.log.Error("OAuth error: %s - %s", oauthErr.Code, oauthErr.Description)
+			s.pages.Render(w, http.StatusOK, CancelledPage, PageData{
+				Title:   "Authorization Cancelled",
+				Message: "The authorization request was cancelled. You can close this window and return to the application.",
+			})
+		case errors.As(err, &oauthErr):
+			s.log.Error("OAuth error: %s - %s", oauthErr.Code, oauthErr.Description)
+			s.pages.Render(w, http.StatusBadRequest, ErrorPage, PageData{
+				Title:            "Authorization Failed",
+				Message:          "The authorization server returned an error. Check the application output for details.",
+				Error:            oauthErr.Code,
+				ErrorDescription: oauthErr.Description,
+			})
+		case errors.Is(err, ErrStateMismatch):
+			s.log.Error("State parameter mismatch, possible CSRF attack")
+			s.pages.Render(w, http.StatusBadRequest, ErrorPage, PageData{
+				Title:   "Authorization Failed",
+				Message: "The state parameter does not match the authorization request.",
+			})
+		default:
+			s.log.Error("No authorization code received")
+			s.pages.Render(w, http.StatusBadRequest, ErrorPage, PageData{
+				Title:   "Authorization Failed",
+				Message: "No authorization code was received.",
+			})
+		}
+		s.deliverError(err)
 		return
 	}
 
 	// Send the code to the channel
-	logger.Step(6, "Authorization Code Received",
+	s.log.Step(6, "Authorization Code Received",
 		"Received authorization code from the OAuth2 provider")
 
-	logger.Educational("Authorization Code",
-		"The authorization code is a temporary code that the OAuth2 provider issues after\n"+
-			"the user has authenticated and authorized the application. This code is then exchanged\n"+
-			"for an access token, which can be used to access the user's resources.\n\n"+
-			"The authorization code is short-lived and can only be used once. This is a security\n"+
-			"feature to prevent replay attacks.")
-
-	s.codeChan <- code
-
-	// Display a success page to the user
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-
-	successHTML := `
-<!DOCTYPE html>
-<html>
-<head>
-    <title>OAuth2 Authorization Successful</title>
-    <style>
-        body {
-            font-family: Arial, sans-serif;
-            max-width: 600px;
-            margin: 0 auto;
-            padding: 20px;
-            text-align: center;
-        }
-        .success {
-            color: #4CAF50;
-            font-size: 24px;
-            margin-bottom: 20px;
-        }
-        .info {
-            color: #555;
-            margin-bottom: 20px;
-        }
-        .code {
-            background-color: #f5f5f5;
-            padding: 10px;
-            border-radius: 4px;
-            font-family: monospace;
-            word-break: break-all;
-        }
-    </style>
-</head>
-<body>
-    <h1 class="success">Authorization Successful!</h1>
-    <p class="info">You have successfully authorized the application. You can now close this window and return to the application.</p>
-    <div class="code">
-        <p>Authorization Code:</p>
-        <code>%s</code>
-    </div>
-</body>
-</html>
-`
-	fmt.Fprintf(w, successHTML, code)
+	s.log.Teach(catalog.TopicAuthorizationCode)
+
+	s.deliverCode(code)
+
+	// Display a success page to the user. The code is only rendered if the
+	// pages were explicitly configured to show it.
+	s.pages.Render(w, http.StatusOK, SuccessPage, PageData{
+		Title:   "Authorization Successful!",
+		Message: "You have successfully authorized the application. You can now close this window and return to the application.",
+		Code:    code,
+	})
+}
+
+// deliverCode hands the code to WaitForCode unless a result was already delivered
+func (s *CallbackServer) deliverCode(code string) {
+	s.resultOnce.Do(func() {
+		s.finishFlow()
+		s.codeChan <- code
+	})
+}
+
+// deliverError hands the error to WaitForCode unless a result was already delivered
+func (s *CallbackServer) deliverError(err error) {
+	s.resultOnce.Do(func() {
+		s.finishFlow()
+		s.errChan <- err
+	})
+}
+
+// finishFlow counts the flow of a started server as finished, once, whether
+// a result was delivered or the server was stopped without one
+func (s *CallbackServer) finishFlow() {
+	if s.flowActive.CompareAndSwap(true, false) {
+		s.metrics.FlowFinished()
+	}
 }
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// SessionPurger removes cached sessions that were ended by a logout
+type SessionPurger interface {
+	PurgeSessions(issuer, sid, sub string) (int, error)
+}
+
+// LogoutOptions configures the logout endpoints of the callback server.
+// Endpoints with an empty path are not served.
+type LogoutOptions struct {
+	// ReturnPath receives the redirect to post_logout_redirect_uri
+	ReturnPath string
+
+	// FrontChannelPath is loaded by the provider in an iframe to end a session
+	FrontChannelPath string
+
+	// BackChannelPath receives logout tokens directly from the provider
+	BackChannelPath string
+
+	// Issuer is the expected issuer of front-channel and back-channel logouts
+	Issuer string
+
+	// Validator checks back-channel logout tokens
+	Validator *auth.LogoutTokenValidator
+
+	// Sessions is purged when the provider ends a session
+	Sessions SessionPurger
+}
+
+// EnableLogout serves the logout endpoints. It must be called before Start.
+func (s *CallbackServer) EnableLogout(opts LogoutOptions) {
+	s.logout = &opts
+	s.logoutChan = make(chan error, 1)
+}
+
+// SetLogoutState sets the state sent in the end session request.
+// Logout redirects carrying a different state are rejected.
+func (s *CallbackServer) SetLogoutState(state string) {
+	s.logoutState = state
+}
+
+// GetPostLogoutRedirectURI returns the URI the provider should redirect to
+// after a logout, or an empty string if no return path is configured
+func (s *CallbackServer) GetPostLogoutRedirectURI() string {
+	if s.logout == nil || s.logout.ReturnPath == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s://localhost:%d%s", s.scheme(), s.port, s.logout.ReturnPath)
+}
+
+// WaitForLogout waits for the provider to redirect back after a logout
+func (s *CallbackServer) WaitForLogout(ctx context.Context) error {
+	if s.logoutChan == nil {
+		return fmt.Errorf("logout endpoints are not enabled")
+	}
+
+	select {
+	case err := <-s.logoutChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// registerLogoutHandlers adds the configured logout endpoints to the mux
+func (s *CallbackServer) registerLogoutHandlers(mux *http.ServeMux) {
+	if s.logout.ReturnPath != "" {
+		mux.HandleFunc(s.logout.ReturnPath, s.handleLogoutReturn)
+	}
+	if s.logout.FrontChannelPath != "" {
+		mux.HandleFunc(s.logout.FrontChannelPath, s.handleFrontChannelLogout)
+	}
+	if s.logout.BackChannelPath != "" {
+		mux.HandleFunc(s.logout.BackChannelPath, s.handleBackChannelLogout)
+	}
+}
+
+// handleLogoutReturn handles the redirect back from the end_session_endpoint
+func (s *CallbackServer) handleLogoutReturn(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug("Received logout redirect")
+
+	var err error
+	if s.logoutState != "" && r.URL.Query().Get("state") != s.logoutState {
+		err = ErrStateMismatch
+		s.log.Error("Logout state parameter mismatch")
+		s.pages.Render(w, http.StatusBadRequest, ErrorPage, PageData{
+			Title:   "Logout Failed",
+			Message: "The state parameter does not match the logout request.",
+		})
+	} else {
+		s.log.Step(2, "Logout Complete",
+			"The OpenID provider redirected back after ending the session")
+		s.pages.Render(w, http.StatusOK, LoggedOutPage, PageData{
+			Title:   "Logged Out",
+			Message: "You have been logged out. You can now close this window and return to the application.",
+		})
+	}
+
+	// Only the first redirect counts
+	select {
+	case s.logoutChan <- err:
+	default:
+	}
+}
+
+// handleFrontChannelLogout handles a front-channel logout request
+// (OpenID Connect Front-Channel Logout 1.0, section 2)
+func (s *CallbackServer) handleFrontChannelLogout(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	iss := query.Get("iss")
+	sid := query.Get("sid")
+
+	// The page is loaded in the provider's iframe, so it must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	// Sessions are keyed by sid, which is only unique per issuer, so iss is
+	// required whenever an issuer is configured (section 2)
+	if s.logout.Issuer != "" && iss == "" {
+		s.log.Warn("Front-channel logout without an issuer")
+		http.Error(w, "missing iss", http.StatusBadRequest)
+		return
+	}
+	if s.logout.Issuer != "" && iss != s.logout.Issuer {
+		s.log.Warn("Front-channel logout from unexpected issuer %q", iss)
+		http.Error(w, "unexpected issuer", http.StatusBadRequest)
+		return
+	}
+	if sid == "" {
+		s.log.Warn("Front-channel logout without a session ID")
+		http.Error(w, "missing sid", http.StatusBadRequest)
+		return
+	}
+
+	s.purgeSessions(iss, sid, "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleBackChannelLogout handles a back-channel logout request
+// (OpenID Connect Back-Channel Logout 1.0, section 2.5)
+func (s *CallbackServer) handleBackChannelLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.logout.Validator == nil {
+		http.Error(w, "back-channel logout is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	logoutToken := r.PostFormValue("logout_token")
+	if logoutToken == "" {
+		writeLogoutError(w, "missing logout_token")
+		return
+	}
+
+	claims, err := s.logout.Validator.Validate(r.Context(), logoutToken)
+	if err != nil {
+		s.log.Warn("Rejected back-channel logout token: %v", err)
+		writeLogoutError(w, err.Error())
+		return
+	}
+
+	s.log.Teach(catalog.TopicBackChannelLogout)
+
+	s.purgeSessions(claims.Issuer, claims.SessionID, claims.Subject)
+	w.WriteHeader(http.StatusOK)
+}
+
+// purgeSessions removes the sessions ended by a logout from the session store
+func (s *CallbackServer) purgeSessions(issuer, sid, sub string) {
+	if s.logout.Sessions == nil {
+		return
+	}
+
+	purged, err := s.logout.Sessions.PurgeSessions(issuer, sid, sub)
+	if err != nil {
+		s.log.Error("Failed to purge sessions: %v", err)
+		return
+	}
+	s.log.Info("Logout ended %d cached session(s)", purged)
+}
+
+// writeLogoutError writes a back-channel logout error response
+func writeLogoutError(w http.ResponseWriter, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             "invalid_request",
+		"error_description": description,
+	})
+}
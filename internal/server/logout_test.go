@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+func TestHandleLogoutReturn(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.EnableLogout(LogoutOptions{ReturnPath: "/oauth/logout"})
+	s.SetLogoutState("xyz")
+
+	// A redirect with the wrong state is rejected
+	rec := httptest.NewRecorder()
+	s.handleLogoutReturn(rec, httptest.NewRequest(http.MethodGet, "/oauth/logout?state=evil", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if err := s.WaitForLogout(context.Background()); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("Expected a state mismatch, got %v", err)
+	}
+
+	// A redirect with the right state completes the logout
+	rec = httptest.NewRecorder()
+	s.handleLogoutReturn(rec, httptest.NewRequest(http.MethodGet, "/oauth/logout?state=xyz", nil))
+	if !strings.Contains(rec.Body.String(), "Logged Out") {
+		t.Error("Logged out page was not rendered")
+	}
+	if err := s.WaitForLogout(context.Background()); err != nil {
+		t.Errorf("Unexpected logout error: %v", err)
+	}
+}
+
+func TestHandleFrontChannelLogout(t *testing.T) {
+	sessions := auth.NewSessionCache()
+	sessions.Add(auth.Session{Issuer: "https://issuer.example", Subject: "alice", SessionID: "s1"})
+
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.EnableLogout(LogoutOptions{
+		FrontChannelPath: "/oauth/frontchannel",
+		Issuer:           "https://issuer.example",
+		Sessions:         sessions,
+	})
+
+	// Without iss the sid can't be trusted to belong to the issuer
+	rec := httptest.NewRecorder()
+	s.handleFrontChannelLogout(rec, httptest.NewRequest(http.MethodGet, "/oauth/frontchannel?sid=s1", nil))
+	if rec.Code != http.StatusBadRequest || len(sessions.List()) != 1 {
+		t.Errorf("Front-channel logout without iss was accepted: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFrontChannelLogout(rec, httptest.NewRequest(http.MethodGet,
+		"/oauth/frontchannel?iss="+url.QueryEscape("https://issuer.example")+"&sid=s1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Unexpected status: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if len(sessions.List()) != 0 {
+		t.Error("Front-channel logout did not purge the session")
+	}
+}
+
+func TestHandleBackChannelLogoutRejectsInvalidToken(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.EnableLogout(LogoutOptions{
+		BackChannelPath: "/oauth/backchannel",
+		Validator: &auth.LogoutTokenValidator{
+			Issuer:   "https://issuer.example",
+			ClientID: "client-123",
+			Keys:     auth.StaticKeySet{},
+		},
+	})
+
+	// Only POST is allowed
+	rec := httptest.NewRecorder()
+	s.handleBackChannelLogout(rec, httptest.NewRequest(http.MethodGet, "/oauth/backchannel", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected status: got %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	// A malformed token is rejected with a JSON error
+	req := httptest.NewRequest(http.MethodPost, "/oauth/backchannel",
+		strings.NewReader("logout_token=not-a-jwt"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	s.handleBackChannelLogout(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rec.Body.String(), "invalid_request") {
+		t.Errorf("Unexpected error body: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/korjavin/oauth2example/internal/catalog"
+)
+
+// ErrNoCode is returned when a callback carries neither a code nor an error
+var ErrNoCode = errors.New("no authorization code received")
+
+// ErrStateMismatch is returned when the state of a callback does not match
+// the state sent in the authorization request
+var ErrStateMismatch = errors.New("state parameter mismatch")
+
+// OAuthError is an error response returned by the authorization server
+// in the redirect (RFC 6749, section 4.1.2.1)
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+// Error implements the error interface
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("oauth error: %s - %s", e.Code, e.Description)
+}
+
+// ErrorCode returns the OAuth error code, for the span of the callback
+func (e *OAuthError) ErrorCode() string {
+	return e.Code
+}
+
+// Cancelled reports whether the user declined the authorization request
+func (e *OAuthError) Cancelled() bool {
+	return e.Code == "access_denied"
+}
+
+// ValidateCallback extracts the authorization code from the parameters of
+// a redirect. If expectedState is not empty, the state parameter must match.
+func ValidateCallback(query url.Values, expectedState string) (string, error) {
+	// An error response from the provider never carries a code, so check it first
+	if errMsg := query.Get("error"); errMsg != "" {
+		return "", &OAuthError{Code: errMsg, Description: query.Get("error_description")}
+	}
+
+	// Check the state before looking at the code so a forged redirect is rejected
+	if expectedState != "" && query.Get("state") != expectedState {
+		return "", ErrStateMismatch
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return "", ErrNoCode
+	}
+
+	return code, nil
+}
+
+// ParseRedirect parses input pasted by the user, which may be the full
+// redirect URL, just its query string, or the bare authorization code.
+// bare reports the last case: a bare code cannot be checked against the
+// expected state, so the caller should warn about it.
+func ParseRedirect(input string, expectedState string) (code string, bare bool, err error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", false, ErrNoCode
+	}
+
+	if !strings.ContainsAny(input, "?=&") {
+		if strings.ContainsAny(input, " \t") {
+			return "", false, fmt.Errorf("input is neither a redirect URL nor an authorization code")
+		}
+		return input, true, nil
+	}
+
+	// Take the query from a full URL, or treat the input as a query string
+	rawQuery := input
+	if i := strings.Index(input, "?"); i >= 0 {
+		u, err := url.Parse(input)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to parse redirect URL: %w", err)
+		}
+		rawQuery = u.RawQuery
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse redirect parameters: %w", err)
+	}
+
+	code, err = ValidateCallback(query, expectedState)
+	return code, false, err
+}
+
+// AcceptPastedInput reads redirect URLs or codes from r, one per line, and
+// delivers the first valid code, or error returned by the provider, to
+// WaitForCode. It races the callback server: whichever produces a result
+// first wins. Empty lines are ignored, and a line that can't be used is
+// logged and the user asked again, so a typo doesn't end the login.
+//
+// The reading goroutine runs until r is exhausted, so r should be something
+// that is closed or abandoned when the process exits, like os.Stdin.
+func (s *CallbackServer) AcceptPastedInput(r io.Reader) {
+	s.log.Teach(catalog.TopicManualRedirect)
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			_, span := s.log.StartSpan(s.traceCtx, "oauth2.callback")
+			code, bare, err := ParseRedirect(line, s.expectedState)
+			span.End(err)
+
+			var oauthErr *OAuthError
+			if errors.As(err, &oauthErr) {
+				s.log.Error("Pasted redirect carries an error: %v", err)
+				s.deliverError(err)
+				return
+			}
+			if err != nil {
+				s.log.Warn("Pasted redirect rejected: %v; paste the URL the browser was redirected to again", err)
+				continue
+			}
+			if bare {
+				s.log.Warn("A bare authorization code was pasted; the state parameter cannot be verified")
+			}
+
+			s.log.Step(6, "Authorization Code Received",
+				"Received authorization code from pasted input")
+			s.deliverCode(code)
+			return
+		}
+		if err := scanner.Err(); err != nil {
+			s.log.Debug("Stopped reading pasted input: %v", err)
+		}
+	}()
+}
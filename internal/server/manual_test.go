@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     string
+		wantBare bool
+		wantErr  error
+	}{
+		{"full URL", "http://localhost:8080/oauth/callback?code=abc&state=xyz", "abc", false, nil},
+		{"query string", "code=abc&state=xyz", "abc", false, nil},
+		{"bare code", "  4/0Abc-def_123  ", "4/0Abc-def_123", true, nil},
+		{"state mismatch", "http://localhost:8080/oauth/callback?code=abc&state=evil", "", false, ErrStateMismatch},
+		{"missing code", "http://localhost:8080/oauth/callback?state=xyz", "", false, ErrNoCode},
+		{"empty", "   ", "", false, ErrNoCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, bare, err := ParseRedirect(tt.input, "xyz")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unexpected error: got %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want || bare != tt.wantBare {
+				t.Errorf("Code is incorrect: got %q (bare %v), want %q (bare %v)", got, bare, tt.want, tt.wantBare)
+			}
+		})
+	}
+}
+
+func TestParseRedirectProviderError(t *testing.T) {
+	_, _, err := ParseRedirect("http://localhost/cb?error=access_denied&state=xyz", "xyz")
+
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("Expected an OAuthError, got %v", err)
+	}
+	if !oauthErr.Cancelled() {
+		t.Error("access_denied should be reported as cancelled")
+	}
+}
+
+func TestAcceptPastedInput(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.SetExpectedState("xyz")
+
+	// Empty lines, typos and forged redirects are skipped before the pasted URL
+	s.AcceptPastedInput(strings.NewReader("\n\nnot a code\n" +
+		"http://localhost:8080/oauth/callback?code=forged&state=evil\n" +
+		"http://localhost:8080/oauth/callback?code=pasted&state=xyz\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, err := s.WaitForCode(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive pasted code: %v", err)
+	}
+	if code != "pasted" {
+		t.Errorf("Received code is incorrect: got %s, want pasted", code)
+	}
+
+	// A later result must not block or replace the first one
+	s.deliverCode("late")
+	select {
+	case code := <-s.codeChan:
+		t.Errorf("Unexpected second result: %s", code)
+	default:
+	}
+}
+
+func TestPastedBareCodeWarning(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.New(logger.InfoLevel)
+	l.SetWriter(&buf)
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.SetLogger(l.With(logger.FlowKey, "ab12cd34"))
+	s.SetExpectedState("xyz")
+
+	s.AcceptPastedInput(strings.NewReader("bare-code\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if code, err := s.WaitForCode(ctx); err != nil || code != "bare-code" {
+		t.Fatalf("Failed to receive the bare code: %q, %v", code, err)
+	}
+	if !strings.Contains(buf.String(), "WARN : [ab12cd34] A bare authorization code was pasted") {
+		t.Errorf("Warning is not logged by the flow logger:\n%s", buf.String())
+	}
+}
+
+func TestPastedProviderError(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.SetExpectedState("xyz")
+	s.AcceptPastedInput(strings.NewReader("typo\x00?\nhttp://localhost/cb?error=access_denied&state=xyz\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := s.WaitForCode(ctx)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Errorf("Expected the provider error, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Paths of the observability endpoints
+const (
+	HealthPath  = "/healthz"
+	ReadyPath   = "/readyz"
+	MetricsPath = "/metrics"
+)
+
+// tokenExchangeBuckets are the upper bounds of the token exchange latency
+// histogram in seconds
+var tokenExchangeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics collects counters about the OAuth2 flows handled by the callback
+// server and renders them in the Prometheus text exposition format.
+// All methods are safe for concurrent use, and the recording methods do
+// nothing on a nil *Metrics so callers don't have to check.
+type Metrics struct {
+	callbacksReceived atomic.Int64
+	stateMismatches   atomic.Int64
+	activeFlows       atomic.Int64
+
+	mu              sync.Mutex
+	oauthErrors     map[string]int64
+	exchangeBuckets []int64
+	exchangeCount   int64
+	exchangeSum     float64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		oauthErrors:     make(map[string]int64),
+		exchangeBuckets: make([]int64, len(tokenExchangeBuckets)),
+	}
+}
+
+// CallbackReceived counts a request to the callback endpoint
+func (m *Metrics) CallbackReceived() {
+	if m == nil {
+		return
+	}
+	m.callbacksReceived.Add(1)
+}
+
+// StateMismatch counts a callback whose state did not match
+func (m *Metrics) StateMismatch() {
+	if m == nil {
+		return
+	}
+	m.stateMismatches.Add(1)
+}
+
+// OAuthError counts an error returned by the authorization server
+func (m *Metrics) OAuthError(code string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.oauthErrors[errorLabel(code)]++
+}
+
+// ObserveTokenExchange records how long a token exchange took
+func (m *Metrics) ObserveTokenExchange(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := d.Seconds()
+	for i, bound := range tokenExchangeBuckets {
+		if seconds <= bound {
+			m.exchangeBuckets[i]++
+		}
+	}
+	m.exchangeCount++
+	m.exchangeSum += seconds
+}
+
+// FlowStarted counts a flow that is waiting for its callback
+func (m *Metrics) FlowStarted() {
+	if m == nil {
+		return
+	}
+	m.activeFlows.Add(1)
+}
+
+// FlowFinished counts a flow that completed or failed
+func (m *Metrics) FlowFinished() {
+	if m == nil {
+		return
+	}
+	m.activeFlows.Add(-1)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	writeMetric(&buf, "oauth2cli_callbacks_received_total", "counter",
+		"Requests received on the callback endpoint.")
+	fmt.Fprintf(&buf, "oauth2cli_callbacks_received_total %d\n", m.callbacksReceived.Load())
+
+	writeMetric(&buf, "oauth2cli_state_mismatches_total", "counter",
+		"Callbacks rejected because the state parameter did not match.")
+	fmt.Fprintf(&buf, "oauth2cli_state_mismatches_total %d\n", m.stateMismatches.Load())
+
+	writeMetric(&buf, "oauth2cli_active_flows", "gauge",
+		"Authorization flows waiting for a callback.")
+	fmt.Fprintf(&buf, "oauth2cli_active_flows %d\n", m.activeFlows.Load())
+
+	m.mu.Lock()
+	codes := make([]string, 0, len(m.oauthErrors))
+	for code := range m.oauthErrors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	writeMetric(&buf, "oauth2cli_oauth_errors_total", "counter",
+		"Error responses from the authorization server by error code.")
+	for _, code := range codes {
+		fmt.Fprintf(&buf, "oauth2cli_oauth_errors_total{error=%q} %d\n", code, m.oauthErrors[code])
+	}
+
+	writeMetric(&buf, "oauth2cli_token_exchange_duration_seconds", "histogram",
+		"Latency of authorization code exchanges at the token endpoint.")
+	for i, bound := range tokenExchangeBuckets {
+		fmt.Fprintf(&buf, "oauth2cli_token_exchange_duration_seconds_bucket{le=\"%g\"} %d\n",
+			bound, m.exchangeBuckets[i])
+	}
+	fmt.Fprintf(&buf, "oauth2cli_token_exchange_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.exchangeCount)
+	fmt.Fprintf(&buf, "oauth2cli_token_exchange_duration_seconds_sum %g\n", m.exchangeSum)
+	fmt.Fprintf(&buf, "oauth2cli_token_exchange_duration_seconds_count %d\n", m.exchangeCount)
+	m.mu.Unlock()
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics endpoint
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	m.WriteTo(w)
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+// knownOAuthErrors are the error codes defined by RFC 6749 and OpenID
+// Connect Core for the authorization endpoint
+var knownOAuthErrors = map[string]bool{
+	"invalid_request":            true,
+	"unauthorized_client":        true,
+	"access_denied":              true,
+	"unsupported_response_type":  true,
+	"invalid_scope":              true,
+	"server_error":               true,
+	"temporarily_unavailable":    true,
+	"interaction_required":       true,
+	"login_required":             true,
+	"account_selection_required": true,
+	"consent_required":           true,
+	"invalid_request_uri":        true,
+	"invalid_request_object":     true,
+	"request_not_supported":      true,
+	"request_uri_not_supported":  true,
+	"registration_not_supported": true,
+}
+
+// errorLabel maps unknown error codes to "other", so a hostile redirect
+// can't inflate the number of label values or break the exposition format
+func errorLabel(code string) string {
+	if knownOAuthErrors[code] {
+		return code
+	}
+	return "other"
+}
+
+// handleHealth reports that the process is alive
+func (s *CallbackServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady reports whether the server is accepting callbacks
+func (s *CallbackServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !s.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready")
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := NewMetrics()
+	m.CallbackReceived()
+	m.CallbackReceived()
+	m.StateMismatch()
+	m.OAuthError("access_denied")
+	m.OAuthError("<script>")
+	m.ObserveTokenExchange(300 * time.Millisecond)
+	m.FlowStarted()
+
+	var sb strings.Builder
+	if _, err := m.WriteTo(&sb); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	out := sb.String()
+
+	want := []string{
+		"oauth2cli_callbacks_received_total 2\n",
+		"oauth2cli_state_mismatches_total 1\n",
+		"oauth2cli_active_flows 1\n",
+		`oauth2cli_oauth_errors_total{error="access_denied"} 1` + "\n",
+		`oauth2cli_oauth_errors_total{error="other"} 1` + "\n",
+		`oauth2cli_token_exchange_duration_seconds_bucket{le="0.25"} 0` + "\n",
+		`oauth2cli_token_exchange_duration_seconds_bucket{le="0.5"} 1` + "\n",
+		"oauth2cli_token_exchange_duration_seconds_count 1\n",
+	}
+	for _, line := range want {
+		if !strings.Contains(out, line) {
+			t.Errorf("Metrics output is missing %q", line)
+		}
+	}
+
+	// A nil *Metrics must be safe to record on
+	var nilMetrics *Metrics
+	nilMetrics.CallbackReceived()
+	nilMetrics.ObserveTokenExchange(time.Second)
+}
+
+func TestObservabilityEndpoints(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.EnableHealthChecks()
+	s.SetMetrics(NewMetrics())
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start callback server: %v", err)
+	}
+	defer s.Stop()
+
+	base := strings.TrimSuffix(s.GetRedirectURI(), "/oauth/callback")
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// Trigger a state-less callback to record something
+	resp, err := client.Get(s.GetRedirectURI() + "?error=access_denied")
+	if err != nil {
+		t.Fatalf("Callback request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{HealthPath, "ok"},
+		{ReadyPath, "ready"},
+		{MetricsPath, "oauth2cli_callbacks_received_total 1"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(base + tt.path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected status for %s: got %d", tt.path, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), tt.want) {
+			t.Errorf("Response for %s does not contain %q: %s", tt.path, tt.want, body)
+		}
+	}
+}
+
+func TestActiveFlows(t *testing.T) {
+	m := NewMetrics()
+	s := NewCallbackServer(0, "/oauth/callback")
+	s.SetExpectedState("xyz")
+	s.SetMetrics(m)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start callback server: %v", err)
+	}
+	defer s.Stop()
+
+	if got := m.activeFlows.Load(); got != 1 {
+		t.Errorf("Active flows after start are incorrect: got %d, want 1", got)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(s.GetRedirectURI() + "?code=abc&state=xyz")
+	if err != nil {
+		t.Fatalf("Callback request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if code, err := s.WaitForCode(ctx); err != nil || code != "abc" {
+		t.Fatalf("Code is incorrect: got %q, %v", code, err)
+	}
+	if got := m.activeFlows.Load(); got != 0 {
+		t.Errorf("Active flows after the callback are incorrect: got %d, want 0", got)
+	}
+
+	// A second callback and stopping the server must not count the flow again
+	resp, err = client.Get(s.GetRedirectURI() + "?code=def&state=xyz")
+	if err != nil {
+		t.Fatalf("Callback request failed: %v", err)
+	}
+	resp.Body.Close()
+	s.Stop()
+	if got := m.activeFlows.Load(); got != 0 {
+		t.Errorf("Active flows after stop are incorrect: got %d, want 0", got)
+	}
+
+	// A server stopped without a result finishes its flow too
+	stopped := NewCallbackServer(0, "/oauth/callback")
+	stopped.SetMetrics(m)
+	if err := stopped.Start(); err != nil {
+		t.Fatalf("Failed to start callback server: %v", err)
+	}
+	stopped.Stop()
+	if got := m.activeFlows.Load(); got != 0 {
+		t.Errorf("Active flows after an abandoned flow are incorrect: got %d, want 0", got)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// Page names used for the callback result pages
+const (
+	// SuccessPage is shown when an authorization code was received
+	SuccessPage = "success.html"
+	// ErrorPage is shown when the provider or the callback reported an error
+	ErrorPage = "error.html"
+	// CancelledPage is shown when the user denied the authorization request
+	CancelledPage = "cancelled.html"
+	// LoggedOutPage is shown when the provider redirects back after a logout
+	LoggedOutPage = "loggedout.html"
+)
+
+// DefaultAutoCloseDelay is the default delay before the result page closes itself
+const DefaultAutoCloseDelay = 3 * time.Second
+
+//go:embed templates/*.html
+var defaultTemplates embed.FS
+
+// PageOptions configures how the callback result pages are rendered
+type PageOptions struct {
+	// Dir is a directory containing templates that override the built-in ones
+	Dir string
+
+	// FS is a file system containing templates that override the built-in ones.
+	// It is ignored when Dir is set.
+	FS fs.FS
+
+	// AutoClose adds a script that closes the browser window after AutoCloseDelay
+	AutoClose bool
+
+	// AutoCloseDelay is the delay before the window is closed (default: 3s)
+	AutoCloseDelay time.Duration
+
+	// ShowCode displays the authorization code on the success page.
+	// This leaks the code into the browser history and screen, so it is
+	// only meant for local debugging.
+	ShowCode bool
+}
+
+// PageData is the data passed to the callback result templates
+type PageData struct {
+	Title                string
+	Message              string
+	Code                 string
+	Error                string
+	ErrorDescription     string
+	AutoClose            bool
+	AutoCloseDelayMillis int64
+	Nonce                string
+}
+
+// Pages renders the HTML pages shown in the browser after the callback
+type Pages struct {
+	tmpl *template.Template
+	opts PageOptions
+}
+
+// NewPages creates the callback result pages from the built-in templates,
+// replacing any template that is also present in opts.Dir or opts.FS
+func NewPages(opts PageOptions) (*Pages, error) {
+	tmpl, err := template.ParseFS(defaultTemplates, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse built-in page templates: %w", err)
+	}
+
+	// Use the override directory if one was given
+	override := opts.FS
+	if opts.Dir != "" {
+		override = os.DirFS(opts.Dir)
+	}
+
+	if override != nil {
+		matches, err := fs.Glob(override, "*.html")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list page templates: %w", err)
+		}
+		if len(matches) > 0 {
+			if tmpl, err = tmpl.ParseFS(override, matches...); err != nil {
+				return nil, fmt.Errorf("failed to parse page templates: %w", err)
+			}
+		}
+	}
+
+	if opts.AutoCloseDelay <= 0 {
+		opts.AutoCloseDelay = DefaultAutoCloseDelay
+	}
+
+	return &Pages{tmpl: tmpl, opts: opts}, nil
+}
+
+// DefaultPages returns the built-in callback result pages
+func DefaultPages() *Pages {
+	p, err := NewPages(PageOptions{})
+	if err != nil {
+		// The built-in templates are embedded, so this only fails on a programming error
+		panic(err)
+	}
+	return p
+}
+
+// Render writes the named page with the security headers required for a
+// page that is reached with an authorization code in its URL
+func (p *Pages) Render(w http.ResponseWriter, status int, name string, data PageData) {
+	nonce, err := generateNonce()
+	if err != nil {
+		logger.Error("Failed to generate page nonce: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	data.Nonce = nonce
+	data.AutoClose = p.opts.AutoClose
+	data.AutoCloseDelayMillis = p.opts.AutoCloseDelay.Milliseconds()
+	if !p.opts.ShowCode {
+		data.Code = ""
+	}
+
+	// Render into a buffer first so a template error doesn't produce half a page
+	var buf bytes.Buffer
+	if err := p.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		logger.Error("Failed to render %s: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	setSecurityHeaders(w, nonce)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// setSecurityHeaders sets headers that keep the callback URL and page
+// content out of caches, referrers and other origins
+func setSecurityHeaders(w http.ResponseWriter, nonce string) {
+	h := w.Header()
+	h.Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; style-src 'nonce-%s'; script-src 'nonce-%s'; "+
+			"img-src 'self' data:; base-uri 'none'; form-action 'none'; frame-ancestors 'none'",
+		nonce, nonce))
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+}
+
+// generateNonce creates a random nonce for the Content-Security-Policy
+func generateNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandleCallbackDoesNotShowCode(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+
+	// Simulate the provider redirecting back with a code
+	req := httptest.NewRequest(http.MethodGet, "/oauth/callback?code=secret-code&state=xyz", nil)
+	rec := httptest.NewRecorder()
+	s.handleCallback(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// The code must never appear in the page by default
+	if strings.Contains(rec.Body.String(), "secret-code") {
+		t.Error("Success page contains the authorization code")
+	}
+
+	// Check the security headers
+	headers := map[string]string{
+		"Cache-Control":   "no-store",
+		"Referrer-Policy": "no-referrer",
+	}
+	for name, want := range headers {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("Header %s is incorrect: got %q, want %q", name, got, want)
+		}
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Security-Policy"), "default-src 'none'") {
+		t.Errorf("Content-Security-Policy is missing or too permissive: %q", rec.Header().Get("Content-Security-Policy"))
+	}
+
+	// Check that the code was delivered
+	if code := <-s.codeChan; code != "secret-code" {
+		t.Errorf("Received code is incorrect: got %s, want secret-code", code)
+	}
+}
+
+func TestHandleCallbackCancelled(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+
+	// Simulate the user declining the consent screen
+	req := httptest.NewRequest(http.MethodGet, "/oauth/callback?error=access_denied", nil)
+	rec := httptest.NewRecorder()
+	s.handleCallback(rec, req)
+
+	if !strings.Contains(rec.Body.String(), "Authorization Cancelled") {
+		t.Error("Cancelled page was not rendered")
+	}
+
+	// Check that the error was delivered
+	if err := <-s.errChan; err == nil {
+		t.Error("Expected an error for a cancelled authorization")
+	}
+}
+
+func TestPagesEscapeProviderError(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+
+	// Simulate a malicious error description
+	req := httptest.NewRequest(http.MethodGet,
+		"/oauth/callback?error=invalid_request&error_description=%3Cscript%3Ealert(1)%3C%2Fscript%3E", nil)
+	rec := httptest.NewRecorder()
+	s.handleCallback(rec, req)
+
+	if strings.Contains(rec.Body.String(), "<script>alert(1)</script>") {
+		t.Error("Error page does not escape the error description")
+	}
+	<-s.errChan
+}
+
+func TestPagesOverride(t *testing.T) {
+	// Override only the success page
+	overrides := fstest.MapFS{
+		"success.html": {Data: []byte(`<p>Branded {{.Title}}{{if .AutoClose}} closing{{end}}</p>`)},
+	}
+
+	pages, err := NewPages(PageOptions{FS: overrides, AutoClose: true})
+	if err != nil {
+		t.Fatalf("Failed to create pages: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	pages.Render(rec, http.StatusOK, SuccessPage, PageData{Title: "Done"})
+	if got := rec.Body.String(); got != "<p>Branded Done closing</p>" {
+		t.Errorf("Overridden page is incorrect: got %q", got)
+	}
+
+	// The built-in error page is still available
+	rec = httptest.NewRecorder()
+	pages.Render(rec, http.StatusBadRequest, ErrorPage, PageData{Title: "Failed"})
+	if !strings.Contains(rec.Body.String(), "window.close()") {
+		t.Error("Built-in error page does not include the auto-close script")
+	}
+}
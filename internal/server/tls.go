@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// SelfSignedCertValidity is how long a generated loopback certificate is valid
+const SelfSignedCertValidity = 24 * time.Hour
+
+// EnableTLS makes the callback server serve HTTPS. If certFile and keyFile
+// are empty, a self-signed certificate for localhost and the loopback
+// addresses is generated on the fly.
+func (s *CallbackServer) EnableTLS(certFile, keyFile string) error {
+	var cert tls.Certificate
+	var err error
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("both a certificate and a key file are required")
+		}
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	} else {
+		cert, err = GenerateSelfSignedCert()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep the parsed leaf so the fingerprint can be shown to the user
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+
+	s.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return nil
+}
+
+// Certificate returns the certificate served by the callback server, or
+// nil if TLS is not enabled
+func (s *CallbackServer) Certificate() *x509.Certificate {
+	if s.tlsConfig == nil {
+		return nil
+	}
+	return s.tlsConfig.Certificates[0].Leaf
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the served
+// certificate, or an empty string if TLS is not enabled
+func (s *CallbackServer) CertificateFingerprint() string {
+	cert := s.Certificate()
+	if cert == nil {
+		return ""
+	}
+	return Fingerprint(cert)
+}
+
+// GenerateSelfSignedCert creates a short-lived self-signed certificate for
+// localhost, 127.0.0.1 and ::1
+func GenerateSelfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate certificate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(SelfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to parse self-signed certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate in the
+// colon-separated hex form shown by browsers
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackServerTLS(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	if err := s.EnableTLS("", ""); err != nil {
+		t.Fatalf("Failed to enable TLS: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start callback server: %v", err)
+	}
+	defer s.Stop()
+
+	// The redirect URI must switch to https
+	redirectURI := s.GetRedirectURI()
+	if !strings.HasPrefix(redirectURI, "https://localhost:") {
+		t.Fatalf("Redirect URI does not use https: %s", redirectURI)
+	}
+
+	// Check the fingerprint format (32 bytes as colon-separated hex)
+	if fp := s.CertificateFingerprint(); len(fp) != 32*3-1 {
+		t.Errorf("Fingerprint has unexpected length: %s", fp)
+	}
+
+	// Trust only the generated certificate
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	resp, err := client.Get(redirectURI + "?code=tls-code")
+	if err != nil {
+		t.Fatalf("TLS callback request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, err := s.WaitForCode(ctx)
+	if err != nil {
+		t.Fatalf("Failed to receive code: %v", err)
+	}
+	if code != "tls-code" {
+		t.Errorf("Received code is incorrect: got %s, want tls-code", code)
+	}
+}
+
+func TestEnableTLSRequiresBothFiles(t *testing.T) {
+	s := NewCallbackServer(0, "/oauth/callback")
+	if err := s.EnableTLS("cert.pem", ""); err == nil {
+		t.Error("Expected an error when the key file is missing")
+	}
+}
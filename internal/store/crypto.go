@@ -0,0 +1,316 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// envelopeVersion is the version of the encrypted entry format
+const envelopeVersion = 1
+
+// Key types recorded in envelopes
+const (
+	keyTypePassphrase = "passphrase"
+	keyTypeKeyFile    = "keyfile"
+)
+
+// Default scrypt parameters for passphrase-derived keys (N=2^15, r=8, p=1),
+// as recommended for interactive logins
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// envelopeAAD binds the ciphertext to its purpose so it can't be replayed
+// as some other kind of encrypted data
+var envelopeAAD = []byte("oauth2cli token cache entry v1")
+
+// ErrWrongKey is returned when no configured key can decrypt an entry
+var ErrWrongKey = errors.New("token cache entry can't be decrypted with the configured key")
+
+// envelope is an encrypted entry as it is stored in the token file
+type envelope struct {
+	Version int        `json:"v"`
+	KeyType string     `json:"key"`
+	KeyID   string     `json:"kid"`
+	KDF     *kdfParams `json:"kdf,omitempty"`
+	Nonce   string     `json:"nonce"`
+	Data    string     `json:"data"`
+}
+
+// kdfParams are the scrypt parameters used to derive a key from a passphrase
+type kdfParams struct {
+	Name string `json:"name"`
+	Salt string `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// keySource is a passphrase or a raw key
+type keySource struct {
+	keyType    string
+	passphrase []byte
+	key        []byte
+}
+
+// Encryptor encrypts token cache entries with AES-256-GCM. New entries are
+// encrypted with the primary key; old keys are only used to decrypt, so
+// a store can be rotated to a new key by rewriting it.
+type Encryptor struct {
+	primary keySource
+	old     []keySource
+
+	// mu guards the derived key cache. Deriving from a passphrase is slow on
+	// purpose, so each salt is only derived once per process.
+	mu      sync.Mutex
+	derived map[string][]byte
+	salt    []byte
+}
+
+// NewPassphraseEncryptor creates an Encryptor that derives its key from a
+// passphrase with scrypt
+func NewPassphraseEncryptor(passphrase string) (*Encryptor, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("encryption passphrase is empty")
+	}
+	return &Encryptor{
+		primary: keySource{keyType: keyTypePassphrase, passphrase: []byte(passphrase)},
+		derived: make(map[string][]byte),
+	}, nil
+}
+
+// NewKeyFileEncryptor creates an Encryptor using the 32-byte key stored in
+// a file, either raw or as hex or base64 text
+func NewKeyFileEncryptor(path string) (*Encryptor, error) {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{
+		primary: keySource{keyType: keyTypeKeyFile, key: key},
+		derived: make(map[string][]byte),
+	}, nil
+}
+
+// AddOldPassphrase allows entries encrypted with a previous passphrase to be read
+func (e *Encryptor) AddOldPassphrase(passphrase string) {
+	e.old = append(e.old, keySource{keyType: keyTypePassphrase, passphrase: []byte(passphrase)})
+}
+
+// AddOldKeyFile allows entries encrypted with a previous key file to be read
+func (e *Encryptor) AddOldKeyFile(path string) error {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+	e.old = append(e.old, keySource{keyType: keyTypeKeyFile, key: key})
+	return nil
+}
+
+// GenerateKeyFile writes a new random key to path, readable only by the user
+func GenerateKeyFile(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// seal encrypts plaintext with the primary key
+func (e *Encryptor) seal(plaintext []byte) (*envelope, error) {
+	key, kdf, err := e.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return &envelope{
+		Version: envelopeVersion,
+		KeyType: e.primary.keyType,
+		KeyID:   keyID(key),
+		KDF:     kdf,
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		Data:    base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, envelopeAAD)),
+	}, nil
+}
+
+// open decrypts an envelope with the primary key or one of the old keys
+func (e *Encryptor) open(env *envelope) ([]byte, error) {
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported encrypted entry version %d", env.Version)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entry nonce: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entry ciphertext: %w", err)
+	}
+
+	for _, src := range append([]keySource{e.primary}, e.old...) {
+		if src.keyType != env.KeyType {
+			continue
+		}
+
+		key, err := e.keyFor(src, env.KDF)
+		if err != nil {
+			return nil, err
+		}
+		if keyID(key) != env.KeyID {
+			continue
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("invalid entry nonce length")
+		}
+		plaintext, err := aead.Open(nil, nonce, data, envelopeAAD)
+		if err != nil {
+			return nil, fmt.Errorf("token cache entry was tampered with: %w", err)
+		}
+		return plaintext, nil
+	}
+
+	return nil, ErrWrongKey
+}
+
+// primaryKey returns the key for new entries and, for a passphrase, the
+// KDF parameters needed to derive it again
+func (e *Encryptor) primaryKey() ([]byte, *kdfParams, error) {
+	if e.primary.keyType == keyTypeKeyFile {
+		return e.primary.key, nil, nil
+	}
+
+	// Use one salt per process so a whole file is derived only once
+	e.mu.Lock()
+	if e.salt == nil {
+		e.salt = make([]byte, 16)
+		if _, err := rand.Read(e.salt); err != nil {
+			e.mu.Unlock()
+			return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+	salt := e.salt
+	e.mu.Unlock()
+
+	kdf := &kdfParams{
+		Name: "scrypt",
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+	}
+	key, err := e.keyFor(e.primary, kdf)
+	return key, kdf, err
+}
+
+// keyFor returns the AES key of a key source, deriving it for a passphrase
+func (e *Encryptor) keyFor(src keySource, kdf *kdfParams) ([]byte, error) {
+	if src.keyType == keyTypeKeyFile {
+		return src.key, nil
+	}
+
+	if kdf == nil || kdf.Name != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation for passphrase-encrypted entry")
+	}
+
+	// Refuse parameters that would make a crafted file hang the process
+	if kdf.N < 2 || kdf.N > 1<<20 || kdf.R < 1 || kdf.R > 32 || kdf.P < 1 || kdf.P > 16 {
+		return nil, fmt.Errorf("key derivation parameters out of range")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(kdf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key derivation salt: %w", err)
+	}
+
+	cacheKey := fmt.Sprintf("%x|%s|%d|%d|%d", sha256.Sum256(src.passphrase), kdf.Salt, kdf.N, kdf.R, kdf.P)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.derived[cacheKey]; ok {
+		return key, nil
+	}
+
+	key, err := scrypt.Key(src.passphrase, salt, kdf.N, kdf.R, kdf.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	e.derived[cacheKey] = key
+	return key, nil
+}
+
+// newAEAD creates an AES-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyID identifies a key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("oauth2cli key id\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// readKeyFile reads a 32-byte key stored raw, as hex or as base64
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key file %s must contain 32 bytes, raw or as hex or base64", path)
+}
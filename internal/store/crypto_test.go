@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+func TestEncryptedFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	enc, err := NewPassphraseEncryptor("correct horse")
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	s := NewFileStore(path)
+	s.SetEncryption(enc)
+	testStore(t, s)
+
+	key := NewKey("https://accounts.google.com", "client", "", nil)
+	if err := s.Put(NewEntry(key, &auth.TokenResponse{AccessToken: "secret-access-token", ExpiresIn: 3600}, nil)); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// The token must not appear in the file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read token file: %v", err)
+	}
+	if strings.Contains(string(data), "secret-access-token") {
+		t.Errorf("Token file contains the plaintext token")
+	}
+
+	stats, err := s.Stats()
+	if err != nil || stats.Plaintext != 0 || stats.Encrypted != 1 {
+		t.Errorf("Unexpected stats: %+v, %v", stats, err)
+	}
+
+	// A store without the key can't read the file
+	if _, err := NewFileStore(path).Get(key); err == nil {
+		t.Errorf("Expected an error reading an encrypted file without a key")
+	}
+
+	// Neither can a store with the wrong passphrase
+	wrong, _ := NewPassphraseEncryptor("wrong")
+	other := NewFileStore(path)
+	other.SetEncryption(wrong)
+	if _, err := other.Get(key); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+}
+
+func TestEncryptedFileStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	key := NewKey("https://accounts.google.com", "client", "", nil)
+
+	// Start with a plaintext file
+	plain := NewFileStore(path)
+	if err := plain.Put(NewEntry(key, &auth.TokenResponse{AccessToken: "at", ExpiresIn: 3600}, nil)); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// Enabling encryption keeps the entries readable and encrypts them on rekey
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := GenerateKeyFile(keyFile); err != nil {
+		t.Fatalf("Failed to generate key file: %v", err)
+	}
+	enc, err := NewKeyFileEncryptor(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %v", err)
+	}
+	s := NewFileStore(path)
+	s.SetEncryption(enc)
+
+	if n, err := s.Rekey(); err != nil || n != 1 {
+		t.Fatalf("Unexpected rekey result: %d, %v", n, err)
+	}
+	stats, err := s.Stats()
+	if err != nil || stats.Plaintext != 0 || stats.Encrypted != 1 {
+		t.Errorf("Unexpected stats after migration: %+v, %v", stats, err)
+	}
+	if got, err := s.Get(key); err != nil || got.Token.AccessToken != "at" {
+		t.Errorf("Unexpected entry after migration: %+v, %v", got, err)
+	}
+}
+
+func TestEncryptedFileStoreRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	key := NewKey("https://accounts.google.com", "client", "", nil)
+
+	oldEnc, _ := NewPassphraseEncryptor("old passphrase")
+	s := NewFileStore(path)
+	s.SetEncryption(oldEnc)
+	if err := s.Put(NewEntry(key, &auth.TokenResponse{AccessToken: "at", ExpiresIn: 3600}, nil)); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// Rotate to a new passphrase, keeping the old one for decryption
+	newEnc, _ := NewPassphraseEncryptor("new passphrase")
+	newEnc.AddOldPassphrase("old passphrase")
+	rotated := NewFileStore(path)
+	rotated.SetEncryption(newEnc)
+	if _, err := rotated.Rekey(); err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+
+	// The new passphrase alone must now be enough
+	onlyNew, _ := NewPassphraseEncryptor("new passphrase")
+	s = NewFileStore(path)
+	s.SetEncryption(onlyNew)
+	if got, err := s.Get(key); err != nil || got.Token.AccessToken != "at" {
+		t.Errorf("Unexpected entry after rotation: %+v, %v", got, err)
+	}
+
+	// And the old one must no longer work
+	s = NewFileStore(path)
+	s.SetEncryption(oldEnc)
+	if _, err := s.Get(key); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey with the old passphrase, got %v", err)
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key := strings.Repeat("ab", 32)
+
+	hexFile := filepath.Join(dir, "hex")
+	if err := os.WriteFile(hexFile, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readKeyFile(hexFile); err != nil {
+		t.Errorf("Failed to read hex key file: %v", err)
+	}
+
+	shortFile := filepath.Join(dir, "short")
+	if err := os.WriteFile(shortFile, []byte("too short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readKeyFile(shortFile); err == nil {
+		t.Errorf("Expected an error for a short key file")
+	}
+}
@@ -0,0 +1,331 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Versions of the token file format. Version 2 files may hold encrypted entries.
+const (
+	fileFormatVersion          = 1
+	encryptedFileFormatVersion = 2
+)
+
+// lockTimeout is how long to wait for another process to release the lock
+const lockTimeout = 10 * time.Second
+
+// DefaultPath returns the default location of the token file, under the
+// user's config directory ($XDG_CONFIG_HOME on Linux)
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "oauth2cli", "tokens.json"), nil
+}
+
+// fileContents is the JSON layout of the token file
+type fileContents struct {
+	Version int         `json:"version"`
+	Entries []*Entry    `json:"entries,omitempty"`
+	Sealed  []*envelope `json:"sealed,omitempty"`
+}
+
+// FileStats describes how the entries of a token file are stored
+type FileStats struct {
+	// Exists reports whether the token file exists
+	Exists bool
+	// Plaintext is the number of entries stored unencrypted
+	Plaintext int
+	// Encrypted is the number of entries stored encrypted
+	Encrypted int
+}
+
+// FileStore keeps tokens in a JSON file readable only by the user. Every
+// operation holds a lock on a companion .lock file and replaces the token
+// file atomically, so parallel invocations don't corrupt it.
+type FileStore struct {
+	path string
+	enc  *Encryptor
+
+	// mu serializes access within the process; the file lock only
+	// coordinates between processes
+	mu sync.Mutex
+}
+
+// NewFileStore creates a store backed by the file at path. The file and
+// its directory are created on the first write.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Path returns the location of the token file
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// SetEncryption encrypts entries with enc when the file is written. Existing
+// plaintext entries are encrypted on the next write.
+func (s *FileStore) SetEncryption(enc *Encryptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc = enc
+}
+
+// Encrypted reports whether entries are encrypted when the file is written
+func (s *FileStore) Encrypted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc != nil
+}
+
+// Get returns the entry for key, or ErrNotFound
+func (s *FileStore) Get(key Key) (*Entry, error) {
+	var entry *Entry
+	err := s.withLock(false, func(entries map[string]*Entry) (bool, error) {
+		var ok bool
+		if entry, ok = entries[key.String()]; !ok {
+			return false, ErrNotFound
+		}
+		return false, nil
+	})
+	return entry, err
+}
+
+// Put adds or replaces the entry for entry.Key
+func (s *FileStore) Put(entry *Entry) error {
+	return s.withLock(true, func(entries map[string]*Entry) (bool, error) {
+		entries[entry.Key.String()] = entry
+		return true, nil
+	})
+}
+
+// Delete removes the entry for key
+func (s *FileStore) Delete(key Key) error {
+	return s.withLock(true, func(entries map[string]*Entry) (bool, error) {
+		if _, ok := entries[key.String()]; !ok {
+			return false, nil
+		}
+		delete(entries, key.String())
+		return true, nil
+	})
+}
+
+// Update calls fn with the entry for key and writes it back if fn reports a
+// change, holding the file lock throughout
+func (s *FileStore) Update(key Key, fn func(entry *Entry) (bool, error)) error {
+	return s.withLock(true, func(entries map[string]*Entry) (bool, error) {
+		entry, ok := entries[key.String()]
+		if !ok {
+			return false, ErrNotFound
+		}
+		return fn(entry)
+	})
+}
+
+// List returns all entries ordered by key
+func (s *FileStore) List() ([]*Entry, error) {
+	var list []*Entry
+	err := s.withLock(false, func(entries map[string]*Entry) (bool, error) {
+		list = sortedEntries(entries)
+		return false, nil
+	})
+	return list, err
+}
+
+// PurgeSessions removes the entries of sessions ended by a logout
+func (s *FileStore) PurgeSessions(issuer, sid, sub string) (int, error) {
+	var purged int
+	err := s.withLock(true, func(entries map[string]*Entry) (bool, error) {
+		purged = purge(entries, issuer, sid, sub)
+		return purged > 0, nil
+	})
+	return purged, err
+}
+
+// Rekey rewrites every entry with the current key. Entries encrypted with an
+// old key, or not encrypted at all, are re-encrypted with the primary key.
+func (s *FileStore) Rekey() (int, error) {
+	var count int
+	err := s.withLock(true, func(entries map[string]*Entry) (bool, error) {
+		count = len(entries)
+		return count > 0, nil
+	})
+	return count, err
+}
+
+// Stats reports how the entries in the token file are stored, without
+// decrypting them
+func (s *FileStore) Stats() (FileStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileStats{}, nil
+	}
+	if err != nil {
+		return FileStats{}, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return FileStats{}, fmt.Errorf("failed to parse token file %s: %w", s.path, err)
+	}
+	return FileStats{
+		Exists:    true,
+		Plaintext: len(contents.Entries),
+		Encrypted: len(contents.Sealed),
+	}, nil
+}
+
+// withLock locks the file, loads the entries and calls fn with them. If fn
+// reports a change, the entries are written back before unlocking.
+func (s *FileStore) withLock(write bool, fn func(entries map[string]*Entry) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if write {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+			return fmt.Errorf("failed to create token directory: %w", err)
+		}
+	}
+
+	unlock, err := lockFile(s.path+".lock", write)
+	if err != nil {
+		// Reading a store that was never written needs no lock
+		if !write && errors.Is(err, os.ErrNotExist) {
+			_, err := fn(map[string]*Entry{})
+			return err
+		}
+		return err
+	}
+	defer unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	changed, err := fn(entries)
+	if err != nil || !changed {
+		return err
+	}
+
+	return s.save(entries)
+}
+
+// load reads the token file. A missing file is an empty store.
+func (s *FileStore) load() (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse token file %s: %w", s.path, err)
+	}
+	if contents.Version != fileFormatVersion && contents.Version != encryptedFileFormatVersion {
+		return nil, fmt.Errorf("unsupported token file version %d", contents.Version)
+	}
+
+	for _, e := range contents.Entries {
+		entries[e.Key.String()] = e
+	}
+
+	// Decrypt the encrypted entries
+	if len(contents.Sealed) > 0 && s.enc == nil {
+		return nil, fmt.Errorf("token file %s is encrypted; a passphrase or key file is required", s.path)
+	}
+	for _, env := range contents.Sealed {
+		plaintext, err := s.enc.open(env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt token file %s: %w", s.path, err)
+		}
+
+		var e Entry
+		if err := json.Unmarshal(plaintext, &e); err != nil {
+			return nil, fmt.Errorf("failed to parse decrypted token entry: %w", err)
+		}
+		entries[e.Key.String()] = &e
+	}
+	return entries, nil
+}
+
+// save writes the token file atomically: the data goes to a temporary file
+// in the same directory, which then replaces the old file
+func (s *FileStore) save(entries map[string]*Entry) error {
+	contents := fileContents{
+		Version: fileFormatVersion,
+		Entries: sortedEntries(entries),
+	}
+
+	// Encrypt each entry separately, so the file stays mergeable and a
+	// damaged entry doesn't lose the others
+	if s.enc != nil {
+		contents.Version = encryptedFileFormatVersion
+		for _, e := range contents.Entries {
+			plaintext, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("failed to encode token entry: %w", err)
+			}
+			env, err := s.enc.seal(plaintext)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt token entry: %w", err)
+			}
+			contents.Sealed = append(contents.Sealed, env)
+		}
+		contents.Entries = nil
+	}
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tokens: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces path with data, readable only by the user
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary token file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	// Remove the temporary file unless it was renamed into place
+	defer os.Remove(tmpName)
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set token file permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to flush token file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close token file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace token file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !unix
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// staleLockAge is the age after which a lock file is assumed to be left
+// behind by a crashed process
+const staleLockAge = time.Minute
+
+// lockFile takes a lock by exclusively creating path. Platforms without
+// flock don't get shared locks, so readers lock exclusively too.
+func lockFile(path string, exclusive bool) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		// Break locks left behind by a crashed process
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock file %s", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
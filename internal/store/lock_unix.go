@@ -0,0 +1,52 @@
+//go:build unix
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockFile takes an advisory lock on path, creating it when writing. Readers
+// share the lock; a writer holds it exclusively.
+func lockFile(path string, exclusive bool) (func(), error) {
+	flags := os.O_RDONLY
+	if exclusive {
+		flags = os.O_RDWR | os.O_CREATE
+	}
+
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	// Poll with a non-blocking lock so a hung process can't block us forever
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock token file: %w", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
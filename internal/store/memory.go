@@ -0,0 +1,103 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps tokens in memory for the lifetime of the process. It
+// keeps copies of the entries, so changing an entry it returned doesn't
+// change the store.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*Entry)}
+}
+
+// Get returns the entry for key, or ErrNotFound
+func (s *MemoryStore) Get(key Key) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return entry.clone(), nil
+}
+
+// Put adds or replaces the entry for entry.Key
+func (s *MemoryStore) Put(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[entry.Key.String()] = entry.clone()
+	return nil
+}
+
+// Delete removes the entry for key
+func (s *MemoryStore) Delete(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key.String())
+	return nil
+}
+
+// List returns all entries ordered by key
+func (s *MemoryStore) List() ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := sortedEntries(s.entries)
+	for i, entry := range list {
+		list[i] = entry.clone()
+	}
+	return list, nil
+}
+
+// Update calls fn with a copy of the entry for key and stores it if fn
+// reports a change
+func (s *MemoryStore) Update(key Key, fn func(entry *Entry) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key.String()]
+	if !ok {
+		return ErrNotFound
+	}
+	entry = entry.clone()
+	changed, err := fn(entry)
+	if err != nil || !changed {
+		return err
+	}
+	s.entries[key.String()] = entry
+	return nil
+}
+
+// PurgeSessions removes the entries of sessions ended by a logout
+func (s *MemoryStore) PurgeSessions(issuer, sid, sub string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return purge(s.entries, issuer, sid, sub), nil
+}
+
+// sortedEntries returns the entries of a map ordered by key
+func sortedEntries(entries map[string]*Entry) []*Entry {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]*Entry, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, entries[k])
+	}
+	return list
+}
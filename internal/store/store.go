@@ -0,0 +1,138 @@
+// Package store persists OAuth2 tokens between runs.
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// ErrNotFound is returned when no entry exists for a key
+var ErrNotFound = errors.New("token not found")
+
+// ExpirySkew is how long before its expiry an access token is treated as expired,
+// so it doesn't expire while a request is in flight
+const ExpirySkew = time.Minute
+
+// Key identifies a cached token
+type Key struct {
+	Provider string   `json:"provider"`
+	ClientID string   `json:"client_id"`
+	Account  string   `json:"account,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// NewKey creates a key with the scopes in canonical order
+func NewKey(provider, clientID, account string, scopes []string) Key {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	return Key{Provider: provider, ClientID: clientID, Account: account, Scopes: sorted}
+}
+
+// String returns the canonical form of the key, which is independent of
+// the order of the scopes
+func (k Key) String() string {
+	scopes := append([]string(nil), k.Scopes...)
+	sort.Strings(scopes)
+	return strings.Join([]string{k.Provider, k.ClientID, k.Account, strings.Join(scopes, " ")}, "|")
+}
+
+// Entry is a cached token with the metadata needed to reuse it
+type Entry struct {
+	Key       Key                 `json:"key"`
+	Token     *auth.TokenResponse `json:"token"`
+	ExpiresAt time.Time           `json:"expires_at"`
+
+	// Identity of the session, taken from the ID token
+	Issuer    string `json:"issuer,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+
+	// Display details of the account, taken from the ID token
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewEntry creates an entry for a token that was just received, computing
+// the absolute expiry from expires_in
+func NewEntry(key Key, token *auth.TokenResponse, claims *auth.IDTokenClaims) *Entry {
+	now := time.Now()
+	entry := &Entry{
+		Key:       key,
+		Token:     token,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if token.ExpiresIn > 0 {
+		entry.ExpiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if claims != nil {
+		entry.Issuer = claims.Issuer
+		entry.Subject = claims.Subject
+		entry.SessionID = claims.SessionID
+		entry.Email = claims.Email
+		entry.Name = claims.Name
+	}
+	return entry
+}
+
+// Valid reports whether the access token can still be used
+func (e *Entry) Valid() bool {
+	if e.Token == nil || e.Token.AccessToken == "" {
+		return false
+	}
+	// Tokens without expires_in are assumed valid until the provider rejects them
+	if e.ExpiresAt.IsZero() {
+		return true
+	}
+	return time.Now().Add(ExpirySkew).Before(e.ExpiresAt)
+}
+
+// TokenStore stores tokens by key. Implementations must be safe for
+// concurrent use.
+type TokenStore interface {
+	// Get returns the entry for key, or ErrNotFound
+	Get(key Key) (*Entry, error)
+
+	// Put adds or replaces the entry for entry.Key
+	Put(entry *Entry) error
+
+	// Delete removes the entry for key. Deleting a missing key is not an error.
+	Delete(key Key) error
+
+	// List returns all entries
+	List() ([]*Entry, error)
+
+	// Update calls fn with the entry for key and stores it if fn reports a
+	// change, holding the store's lock throughout, so no other update can
+	// land in between. It returns ErrNotFound if there is no entry.
+	Update(key Key, fn func(entry *Entry) (bool, error)) error
+}
+
+// clone returns a copy of the entry that shares nothing with it
+func (e *Entry) clone() *Entry {
+	c := *e
+	if e.Token != nil {
+		token := *e.Token
+		c.Token = &token
+	}
+	return &c
+}
+
+// purge removes the entries ended by a logout and returns how many were removed
+func purge(entries map[string]*Entry, issuer, sid, sub string) int {
+	purged := 0
+	for k, e := range entries {
+		if auth.MatchesLogout(e.Issuer, e.SessionID, e.Subject, issuer, sid, sub) {
+			delete(entries, k)
+			purged++
+		}
+	}
+	return purged
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// testStore runs the behaviour every TokenStore must have
+func testStore(t *testing.T, s TokenStore) {
+	key := NewKey("https://accounts.google.com", "client", "alice", []string{"profile", "openid"})
+
+	// A missing key is reported as ErrNotFound
+	if _, err := s.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	entry := NewEntry(key, &auth.TokenResponse{AccessToken: "at", ExpiresIn: 3600}, nil)
+	if err := s.Put(entry); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// The scope order must not matter
+	got, err := s.Get(NewKey("https://accounts.google.com", "client", "alice", []string{"openid", "profile"}))
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if got.Token.AccessToken != "at" || !got.Valid() {
+		t.Errorf("Unexpected entry: %+v", got)
+	}
+
+	list, err := s.List()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("Unexpected list: %v, %v", list, err)
+	}
+
+	// Entries handed out are copies; only Put and Update change the store
+	got.Token.AccessToken = "changed"
+	list[0].Token.AccessToken = "changed"
+	if got, _ := s.Get(key); got.Token.AccessToken != "at" {
+		t.Errorf("Changing a returned entry changed the store: %+v", got.Token)
+	}
+
+	err = s.Update(key, func(e *Entry) (bool, error) {
+		e.Token.AccessToken = "updated"
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update entry: %v", err)
+	}
+	if got, _ := s.Get(key); got.Token.AccessToken != "updated" {
+		t.Errorf("Update was not stored: %+v", got.Token)
+	}
+	err = s.Update(key, func(e *Entry) (bool, error) {
+		e.Token.AccessToken = "discarded"
+		return false, nil
+	})
+	if got, _ := s.Get(key); err != nil || got.Token.AccessToken != "updated" {
+		t.Errorf("Unchanged update was stored: %+v, %v", got.Token, err)
+	}
+	missing := NewKey("https://accounts.google.com", "client", "bob", nil)
+	if err := s.Update(missing, func(*Entry) (bool, error) { return true, nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing entry, got %v", err)
+	}
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	if _, err := s.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Entry still present after delete: %v", err)
+	}
+
+	// Deleting a missing key is not an error
+	if err := s.Delete(key); err != nil {
+		t.Errorf("Deleting a missing key failed: %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oauth2cli", "tokens.json")
+	testStore(t, NewFileStore(path))
+
+	// The file must only be readable by the user
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Token file was not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Token file permissions are incorrect: got %o, want 600", perm)
+	}
+}
+
+func TestFileStoreConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	// Separate stores simulate separate processes sharing the file
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := NewFileStore(path)
+			key := NewKey("provider", "client", fmt.Sprintf("account-%d", i), nil)
+			if err := s.Put(NewEntry(key, &auth.TokenResponse{AccessToken: "at"}, nil)); err != nil {
+				t.Errorf("Failed to put entry %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	list, err := NewFileStore(path).List()
+	if err != nil {
+		t.Fatalf("Failed to list entries: %v", err)
+	}
+	if len(list) != 10 {
+		t.Errorf("Lost updates: got %d entries, want 10", len(list))
+	}
+}
+
+func TestFileStoreConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	key := NewKey("provider", "client", "alice", nil)
+	if err := NewFileStore(path).Put(NewEntry(key, &auth.TokenResponse{AccessToken: "at"}, nil)); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// Every increment must see the previous one
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := NewFileStore(path).Update(key, func(e *Entry) (bool, error) {
+				e.Token.ExpiresIn++
+				return true, nil
+			})
+			if err != nil {
+				t.Errorf("Failed to update entry: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entry, err := NewFileStore(path).Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if entry.Token.ExpiresIn != 10 {
+		t.Errorf("Lost updates: got %d, want 10", entry.Token.ExpiresIn)
+	}
+}
+
+func TestEntryValid(t *testing.T) {
+	entry := &Entry{Token: &auth.TokenResponse{AccessToken: "at"}, ExpiresAt: time.Now().Add(30 * time.Second)}
+	if entry.Valid() {
+		t.Error("Token expiring within the skew should not be valid")
+	}
+
+	entry.ExpiresAt = time.Now().Add(time.Hour)
+	if !entry.Valid() {
+		t.Error("Token expiring in an hour should be valid")
+	}
+}
+
+func TestPurgeSessions(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	for _, sid := range []string{"s1", "s2"} {
+		entry := NewEntry(NewKey("p", "c", sid, nil), &auth.TokenResponse{AccessToken: "at"},
+			&auth.IDTokenClaims{Issuer: "iss", Subject: "alice", SessionID: sid})
+		if err := s.Put(entry); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	if n, err := s.PurgeSessions("iss", "s1", ""); err != nil || n != 1 {
+		t.Errorf("Unexpected purge result: %d, %v", n, err)
+	}
+	if list, _ := s.List(); len(list) != 1 || list[0].SessionID != "s2" {
+		t.Errorf("Unexpected remaining entries: %+v", list)
+	}
+}
@@ -0,0 +1,177 @@
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// Recorder writes a transcript. It is safe for concurrent use. Everything
+// recorded is redacted like the logs, even when the logs are unsafe, so a
+// transcript can be attached to a bug report.
+type Recorder struct {
+	mu      sync.Mutex
+	closer  io.Closer
+	encoder *json.Encoder
+	err     error
+}
+
+// Create creates a transcript file, readable only by the user, and records
+// the start of the command
+func Create(path string, command string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcript: %w", err)
+	}
+
+	r := NewRecorder(f, command)
+	r.closer = f
+	return r, nil
+}
+
+// NewRecorder records a transcript of the command to w
+func NewRecorder(w io.Writer, command string) *Recorder {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	r := &Recorder{encoder: encoder}
+	r.record(Event{Type: TypeStart, Version: Version, Command: command})
+	return r
+}
+
+// record writes an event. Write errors are kept for Close, so a broken
+// transcript doesn't break the flow.
+func (r *Recorder) record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.encoder.Encode(e); err != nil {
+		r.err = fmt.Errorf("failed to write transcript: %w", err)
+	}
+}
+
+// RecordExchange records an HTTP exchange, redacted
+func (r *Recorder) RecordExchange(exchange *auth.Exchange) {
+	redacted := *exchange
+	redacted.URL = logger.RedactAlways(exchange.URL)
+	redacted.RequestHeader = redactHeader(exchange.RequestHeader)
+	redacted.RequestBody = logger.RedactAlways(exchange.RequestBody)
+	redacted.ResponseHeader = redactHeader(exchange.ResponseHeader)
+	redacted.ResponseBody = logger.RedactAlways(exchange.ResponseBody)
+	redacted.Error = logger.RedactAlways(exchange.Error)
+
+	r.record(Event{Type: TypeHTTP, Exchange: &redacted})
+}
+
+// redactHeader redacts header values. Values are redacted together with
+// their name, so Authorization headers are recognized.
+func redactHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			line := logger.RedactAlways(name + ": " + value)
+			redacted[name] = append(redacted[name], strings.TrimPrefix(line, name+": "))
+		}
+	}
+	return redacted
+}
+
+// RecordToken records the metadata of the token a run obtained
+func (r *Recorder) RecordToken(info *auth.TokenInfo) {
+	r.record(Event{Type: TypeToken, Token: info})
+}
+
+// Transport returns a transport recording every exchange sent through base
+func (r *Recorder) Transport(base http.RoundTripper) http.RoundTripper {
+	return &auth.TracingTransport{Base: base, Record: r.RecordExchange}
+}
+
+// Handler returns a slog.Handler recording log records
+func (r *Recorder) Handler() slog.Handler {
+	return &handler{recorder: r}
+}
+
+// Close records the end of the run and closes the file
+func (r *Recorder) Close(exitCode int, runErr error) error {
+	e := Event{Type: TypeEnd, ExitCode: exitCode}
+	if runErr != nil {
+		e.Error = logger.RedactAlways(runErr.Error())
+	}
+	r.record(e)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closer != nil {
+		if err := r.closer.Close(); err != nil && r.err == nil {
+			r.err = fmt.Errorf("failed to write transcript: %w", err)
+		}
+	}
+	return r.err
+}
+
+// handler records log records as log events. The logger has redacted them
+// already, unless redaction is off, so they are redacted again.
+type handler struct {
+	recorder *Recorder
+	attrs    []slog.Attr
+}
+
+// Enabled implements slog.Handler
+func (h *handler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// WithAttrs implements slog.Handler
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{recorder: h.recorder, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+// WithGroup implements slog.Handler. Groups are flattened.
+func (h *handler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Handle implements slog.Handler
+func (h *handler) Handle(_ context.Context, rec slog.Record) error {
+	e := Event{Type: TypeLog, Time: rec.Time, Level: rec.Level.String(), Message: logger.RedactAlways(rec.Message)}
+
+	add := func(a slog.Attr) bool {
+		if e.Attrs == nil {
+			e.Attrs = make(map[string]interface{})
+		}
+		value := a.Value.Resolve()
+		if value.Kind() == slog.KindString {
+			e.Attrs[a.Key] = logger.RedactAlways(value.String())
+		} else {
+			e.Attrs[a.Key] = value.Any()
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	rec.Attrs(add)
+
+	h.recorder.record(e)
+	return nil
+}
@@ -0,0 +1,131 @@
+package transcript
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// Replayer serves the recorded responses back, as an http.RoundTripper for
+// tests and as an http.Handler standing in for the provider. Requests are
+// matched by method and path, so the provider may live at another address;
+// repeated requests get the recorded responses in order, and the last one
+// once they run out.
+type Replayer struct {
+	mu        sync.Mutex
+	responses map[string][]*auth.Exchange
+	served    map[string]int
+}
+
+// NewReplayer serves the responses recorded in t
+func NewReplayer(t *Transcript) *Replayer {
+	r := &Replayer{
+		responses: make(map[string][]*auth.Exchange),
+		served:    make(map[string]int),
+	}
+	for _, exchange := range t.Exchanges() {
+		if exchange.Error != "" {
+			continue
+		}
+		key := replayKey(exchange.Method, exchange.URL)
+		r.responses[key] = append(r.responses[key], exchange)
+	}
+	return r
+}
+
+// replayKey identifies the requests a response is served for
+func replayKey(method, rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+	return method + " " + path
+}
+
+// Paths returns the method and path of every recorded request
+func (r *Replayer) Paths() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paths := make([]string, 0, len(r.responses))
+	for key := range r.responses {
+		paths = append(paths, key)
+	}
+	return paths
+}
+
+// next returns the response to serve for a request, or nil
+func (r *Replayer) next(method, rawURL string) *auth.Exchange {
+	key := replayKey(method, rawURL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	responses := r.responses[key]
+	if len(responses) == 0 {
+		return nil
+	}
+	i := r.served[key]
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	r.served[key]++
+	return responses[i]
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	exchange := r.next(req.Method, req.URL.String())
+	if exchange == nil {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL.Path)
+	}
+
+	return &http.Response{
+		Status:        exchange.Status,
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        responseHeader(exchange),
+		Body:          io.NopCloser(strings.NewReader(exchange.ResponseBody)),
+		ContentLength: int64(len(exchange.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	exchange := r.next(req.Method, req.URL.String())
+	if exchange == nil {
+		logger.Warn("No recorded response for %s %s", req.Method, req.URL.Path)
+		http.Error(w, "no recorded response", http.StatusNotFound)
+		return
+	}
+	logger.Info("Replaying the recorded response to %s %s: %s", req.Method, req.URL.Path, exchange.Status)
+
+	for name, values := range responseHeader(exchange) {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(exchange.StatusCode)
+	io.WriteString(w, exchange.ResponseBody)
+}
+
+// responseHeader returns the recorded response headers, without the length,
+// which redaction may have changed
+func responseHeader(exchange *auth.Exchange) http.Header {
+	header := exchange.ResponseHeader.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Content-Length")
+	return header
+}
@@ -0,0 +1,160 @@
+// Package transcript records a run of the CLI to a file, with its steps,
+// educational notes, HTTP exchanges and the token it obtained, and reads it
+// back to replay it
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+)
+
+// Version is the version of the transcript format
+const Version = 1
+
+// Event types
+const (
+	// TypeStart starts a transcript
+	TypeStart = "start"
+	// TypeLog is a log record, such as a step or an educational note
+	TypeLog = "log"
+	// TypeHTTP is an HTTP exchange with the provider
+	TypeHTTP = "http"
+	// TypeToken describes the token the run obtained
+	TypeToken = "token"
+	// TypeEnd ends a transcript
+	TypeEnd = "end"
+)
+
+// Event is a line of a transcript, which is a JSON Lines file
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Version and Command are set on start events
+	Version int    `json:"version,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// Level, Message and Attrs are set on log events
+	Level   string                 `json:"level,omitempty"`
+	Message string                 `json:"msg,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+
+	// Exchange is set on http events
+	Exchange *auth.Exchange `json:"exchange,omitempty"`
+
+	// Token is set on token events, without the tokens themselves
+	Token *auth.TokenInfo `json:"token,omitempty"`
+
+	// ExitCode and Error are set on end events
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Record returns the log record of a log event, with its original time
+func (e *Event) Record() (slog.Record, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(e.Level)); err != nil {
+		return slog.Record{}, fmt.Errorf("invalid level %q: %w", e.Level, err)
+	}
+
+	r := slog.NewRecord(e.Time, level, e.Message, 0)
+	for key, value := range e.Attrs {
+		r.AddAttrs(attr(key, value))
+	}
+	return r, nil
+}
+
+// attr converts an attribute read from JSON back to its slog form, so
+// numbers like the step number are integers again
+func attr(key string, value interface{}) slog.Attr {
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return slog.Int64(key, i)
+		}
+		if f, err := n.Float64(); err == nil {
+			return slog.Float64(key, f)
+		}
+	}
+	return slog.Any(key, value)
+}
+
+// Transcript is a recorded run
+type Transcript struct {
+	Events []Event
+}
+
+// Open reads a transcript file
+func Open(path string) (*Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	defer f.Close()
+
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Read reads a transcript
+func Read(r io.Reader) (*Transcript, error) {
+	var t Transcript
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var e Event
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(t.Events) == 0 {
+			if e.Type != TypeStart {
+				return nil, fmt.Errorf("line %d: not a transcript", line)
+			}
+			if e.Version != Version {
+				return nil, fmt.Errorf("unsupported transcript version %d", e.Version)
+			}
+		}
+		t.Events = append(t.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.Events) == 0 {
+		return nil, fmt.Errorf("empty transcript")
+	}
+	return &t, nil
+}
+
+// Start returns the start event
+func (t *Transcript) Start() Event {
+	return t.Events[0]
+}
+
+// Exchanges returns the recorded HTTP exchanges in order
+func (t *Transcript) Exchanges() []*auth.Exchange {
+	var exchanges []*auth.Exchange
+	for _, e := range t.Events {
+		if e.Type == TypeHTTP && e.Exchange != nil {
+			exchanges = append(exchanges, e.Exchange)
+		}
+	}
+	return exchanges
+}
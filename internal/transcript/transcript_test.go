@@ -0,0 +1,196 @@
+package transcript
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+)
+
+// recordExchange records a token exchange with a fake provider
+func recordExchange(t *testing.T, status int, body string) []byte {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, "login")
+	logger.DefaultLogger.SetWriter(&bytes.Buffer{})
+	logger.DefaultLogger.SetRecorder(rec.Handler())
+	t.Cleanup(func() {
+		logger.DefaultLogger.SetRecorder(nil)
+		logger.DefaultLogger.SetWriter(os.Stdout)
+	})
+
+	client, err := auth.NewOAuth2Client(auth.OAuth2Config{
+		ClientID:  "client-123",
+		Endpoint:  auth.Endpoint{AuthURL: srv.URL + "/auth", TokenURL: srv.URL + "/token"},
+		Transport: rec.Transport(nil),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	token, exchangeErr := client.ExchangeCodeForToken(context.Background(), "code-4711")
+	if exchangeErr == nil {
+		info, err := auth.NewTokenInfo(token, time.Now().Add(time.Hour), false)
+		if err != nil {
+			t.Fatalf("Failed to describe token: %v", err)
+		}
+		rec.RecordToken(info)
+	}
+
+	if err := rec.Close(1, exchangeErr); err != nil {
+		t.Fatalf("Failed to close transcript: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecordAndRead(t *testing.T) {
+	data := recordExchange(t, http.StatusOK, `{"access_token":"at-secret-value","token_type":"Bearer","expires_in":3600}`)
+	if bytes.Contains(data, []byte("at-secret-value")) || bytes.Contains(data, []byte("code-4711")) {
+		t.Errorf("Transcript contains credentials:\n%s", data)
+	}
+
+	tr, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read transcript: %v", err)
+	}
+	if start := tr.Start(); start.Command != "login" || start.Version != Version {
+		t.Errorf("Start event is incorrect: %+v", start)
+	}
+
+	var steps []int64
+	var types []string
+	for _, e := range tr.Events {
+		types = append(types, e.Type)
+		if _, ok := e.Attrs[logger.StepKey]; ok {
+			r, err := e.Record()
+			if err != nil {
+				t.Fatalf("Failed to convert log event: %v", err)
+			}
+			r.Attrs(func(a slog.Attr) bool {
+				if a.Key == logger.StepKey {
+					steps = append(steps, a.Value.Int64())
+				}
+				return true
+			})
+		}
+	}
+	if len(steps) != 2 || steps[0] != 7 || steps[1] != 8 {
+		t.Errorf("Steps are incorrect: got %v, want [7 8]", steps)
+	}
+	got := strings.Join(types, " ")
+	if !strings.HasPrefix(got, "start log") || !strings.Contains(got, "http") || !strings.HasSuffix(got, "token end") {
+		t.Errorf("Event types are incorrect: %s", got)
+	}
+
+	exchanges := tr.Exchanges()
+	if len(exchanges) != 1 || exchanges[0].Method != http.MethodPost || exchanges[0].StatusCode != http.StatusOK {
+		t.Fatalf("Exchanges are incorrect: %+v", exchanges)
+	}
+	if !strings.Contains(exchanges[0].RequestBody, "code="+logger.Mask("code-4711")) {
+		t.Errorf("Request body is not redacted consistently: %s", exchanges[0].RequestBody)
+	}
+}
+
+func TestRecordUnsafe(t *testing.T) {
+	// Unsafe logs are for the terminal; the transcript is still redacted
+	logger.SetDefaultUnsafe(true)
+	t.Cleanup(func() { logger.SetDefaultUnsafe(false) })
+
+	data := recordExchange(t, http.StatusOK, `{"access_token":"at-secret-value","token_type":"Bearer","expires_in":3600}`)
+	if bytes.Contains(data, []byte("at-secret-value")) || bytes.Contains(data, []byte("code-4711")) {
+		t.Errorf("Transcript of an unsafe run contains credentials:\n%s", data)
+	}
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf, "login")
+	logger.DefaultLogger.SetWriter(&bytes.Buffer{})
+	logger.DefaultLogger.SetRecorder(rec.Handler())
+	defer func() {
+		logger.DefaultLogger.SetRecorder(nil)
+		logger.DefaultLogger.SetWriter(os.Stdout)
+	}()
+	logger.DefaultLogger.With("url", "/oauth/callback?code=code-4711&state=xyz").Info("Callback received")
+	logger.Info("Received code=code-4711")
+	if err := rec.Close(0, nil); err != nil {
+		t.Fatalf("Failed to close transcript: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("code-4711")) {
+		t.Errorf("Log records of an unsafe run contain credentials:\n%s", buf.Bytes())
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	data := recordExchange(t, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Bad Request"}`)
+	tr, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read transcript: %v", err)
+	}
+
+	// The failing exchange is reproduced offline, at another address
+	client, err := auth.NewOAuth2Client(auth.OAuth2Config{
+		ClientID:  "client-123",
+		Endpoint:  auth.Endpoint{AuthURL: "https://idp.invalid/auth", TokenURL: "https://idp.invalid/token"},
+		Transport: NewReplayer(tr),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = client.ExchangeCodeForToken(context.Background(), "another-code")
+	var providerErr *auth.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Code != "invalid_grant" {
+		t.Errorf("Expected the recorded invalid_grant error, got %v", err)
+	}
+
+	// The replayer also stands in for the provider over HTTP
+	w := httptest.NewRecorder()
+	NewReplayer(tr).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/token", nil))
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("Served response is incorrect: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	NewReplayer(tr).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/userinfo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Unrecorded request is not rejected: %d", w.Code)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "empty transcript"},
+		{"not a transcript", `{"type":"log","msg":"hello"}`, "not a transcript"},
+		{"version", `{"type":"start","version":99}`, "unsupported transcript version 99"},
+		{"invalid JSON", "{\"type\":\"start\",\"version\":1}\n{", "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
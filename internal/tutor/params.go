@@ -0,0 +1,92 @@
+package tutor
+
+// param explains a request parameter and what happens if it's changed
+type param struct {
+	// About says what the parameter is for
+	About string
+	// Try says how the provider or the local validators react to a change
+	Try string
+}
+
+// params explains the parameters of the authorization URL and of the forms
+// posted to the provider
+var params = map[string]param{
+	"client_id": {
+		About: "Identifies this application to the provider",
+		Try:   "An unknown client ID is rejected with invalid_client or an error page before the login",
+	},
+	"client_secret": {
+		About: "Authenticates a confidential client; public clients rely on PKCE instead",
+		Try:   "A wrong secret is rejected with invalid_client",
+	},
+	"redirect_uri": {
+		About: "Where the provider sends the browser back with the code; it must be registered, and the token request must repeat it exactly",
+		Try:   "An unregistered URI is refused on the provider's page (redirect_uri_mismatch); a different one in the token request fails with invalid_grant",
+	},
+	"response_type": {
+		About: "'code' selects the authorization code flow",
+		Try:   "Other values are refused with unsupported_response_type, or select a flow this program can't finish",
+	},
+	"scope": {
+		About: "The permissions requested, separated by spaces",
+		Try:   "Unknown scopes are refused with invalid_scope; fewer scopes mean fewer claims and a shorter consent screen",
+	},
+	"state": {
+		About: "A random value the provider sends back unchanged; this program remembers it to reject redirects it didn't start (CSRF protection)",
+		Try:   "The provider echoes the changed value, and the callback server rejects the redirect because it no longer matches",
+	},
+	"code_challenge": {
+		About: "The SHA-256 hash of the code verifier, base64url-encoded; the provider stores it with the code",
+		Try:   "The real verifier no longer hashes to the stored challenge, so the token exchange fails with invalid_grant",
+	},
+	"code_challenge_method": {
+		About: "How the challenge was derived from the verifier: S256, or plain, which offers no protection if the URL leaks",
+		Try:   "'plain' makes the provider compare the verifier with the challenge directly, which fails with invalid_grant; some providers refuse it",
+	},
+	"audience": {
+		About: "The API the access token is meant for, on providers that issue JWT access tokens",
+	},
+	"login_hint": {
+		About: "The account the provider should preselect",
+	},
+	"prompt": {
+		About: "Asks the provider to re-authenticate (login), ask for consent again (consent) or show its account chooser (select_account)",
+	},
+	"code": {
+		About: "The authorization code from the redirect; short-lived and single-use",
+		Try:   "A changed or reused code is rejected with invalid_grant",
+	},
+	"code_verifier": {
+		About: "The PKCE secret this program generated; the provider hashes it and compares it with the code_challenge",
+		Try:   "A different verifier doesn't match the challenge, so the provider refuses the code with invalid_grant: a stolen code alone is useless",
+	},
+	"grant_type": {
+		About: "Selects the grant: authorization_code exchanges a code, refresh_token uses a refresh token",
+		Try:   "Other values are refused with unsupported_grant_type",
+	},
+	"refresh_token": {
+		About: "The refresh token obtained with the original tokens",
+		Try:   "A changed or revoked refresh token is rejected with invalid_grant",
+	},
+	"token": {
+		About: "The token to revoke or introspect",
+		Try:   "Revoking an unknown token still succeeds (RFC 7009); introspecting one reports it inactive",
+	},
+	"token_type_hint": {
+		About: "Whether the token is an access_token or a refresh_token, to speed up the lookup",
+	},
+}
+
+// reactions explains the error codes of the provider (RFC 6749, sections
+// 4.1.2.1 and 5.2)
+var reactions = map[string]string{
+	"invalid_request":           "The request is missing a parameter or has a malformed one.",
+	"invalid_client":            "The provider couldn't authenticate the client: the client ID or secret is wrong.",
+	"invalid_grant":             "The code or refresh token is invalid, expired or already used, or it was issued for another redirect URI, or the code verifier doesn't match the challenge.",
+	"unauthorized_client":       "This client isn't allowed to use the grant type.",
+	"unsupported_grant_type":    "The provider doesn't know the grant type.",
+	"invalid_scope":             "A requested scope is unknown or not allowed for this client.",
+	"access_denied":             "The user, or a policy of the provider, declined the request.",
+	"unsupported_response_type": "The provider doesn't support the response type.",
+	"redirect_uri_mismatch":     "The redirect URI isn't registered for this client.",
+}
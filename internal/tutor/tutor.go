@@ -0,0 +1,235 @@
+// Package tutor implements tutor mode: the flow pauses at every step, and
+// the parameters of every request are shown with explanations before they
+// are sent, so a learner can inspect them, change them and see how the
+// provider or the local validators react
+package tutor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/logger"
+	"github.com/korjavin/oauth2example/internal/server"
+)
+
+// Tutor pauses the flow and reads the learner's commands. It is safe for
+// concurrent use: steps may be logged by the callback server.
+type Tutor struct {
+	in  io.Reader
+	out io.Writer
+
+	mu    sync.Mutex
+	start sync.Once
+	lines chan string
+	// stopped is set when the input ends or the learner interrupts; the
+	// flow then runs on without pauses
+	stopped bool
+	// changes are the parameters the learner changed, to explain failures
+	changes []change
+}
+
+// change is a parameter the learner changed
+type change struct {
+	request string
+	name    string
+	from    string
+	to      string
+	removed bool
+}
+
+// New creates a tutor reading commands from in and writing to out
+func New(in io.Reader, out io.Writer) *Tutor {
+	return &Tutor{in: in, out: out}
+}
+
+// Step pauses at a step of the flow until the learner presses Enter. It is
+// meant as the logger's step hook.
+func (t *Tutor) Step(stepNumber int, stepName, description string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+
+	fmt.Fprintf(t.out, "\n[tutor] Step %d: %s. Press Enter to continue ", stepNumber, stepName)
+	t.readLine()
+}
+
+// Review shows the parameters of a request with explanations and lets the
+// learner change them before the request is sent. It is meant as the
+// auth.OAuth2Config Review hook.
+func (t *Tutor) Review(method, endpoint string, params url.Values) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+
+	request := method + " " + endpoint
+	t.show(request, params)
+	for {
+		fmt.Fprint(t.out, "tutor> ")
+		line, ok := t.readLine()
+		if !ok {
+			return
+		}
+
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		switch {
+		case fields[0] == "" || fields[0] == "send":
+			return
+		case fields[0] == "set" && len(fields) == 3:
+			name, value := fields[1], strings.TrimSpace(fields[2])
+			t.changes = append(t.changes, change{request: request, name: name, from: params.Get(name), to: value})
+			params.Set(name, value)
+			fmt.Fprintf(t.out, "Changed %s. Press Enter to send the request.\n", name)
+		case fields[0] == "unset" && len(fields) == 2:
+			name := fields[1]
+			t.changes = append(t.changes, change{request: request, name: name, from: params.Get(name), removed: true})
+			params.Del(name)
+			fmt.Fprintf(t.out, "Removed %s. Press Enter to send the request.\n", name)
+		case fields[0] == "show":
+			t.show(request, params)
+		default:
+			fmt.Fprintln(t.out, "Commands:\n"+
+				"  Enter or send        send the request\n"+
+				"  set <name> <value>   change or add a parameter\n"+
+				"  unset <name>         remove a parameter\n"+
+				"  show                 show the parameters again")
+		}
+	}
+}
+
+// show prints the parameters of a request with what they are for
+func (t *Tutor) show(request string, values url.Values) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(t.out, "\n[tutor] About to send %s with:\n", stripQuery(request))
+	hidden := false
+	for _, name := range names {
+		value := redact(name, values.Get(name))
+		hidden = hidden || strings.Contains(value, "[REDACTED:")
+		fmt.Fprintf(t.out, "\n  %s = %s\n", name, value)
+		if p, ok := params[name]; ok {
+			fmt.Fprintf(t.out, "      %s\n", p.About)
+			if p.Try != "" {
+				fmt.Fprintf(t.out, "      If changed: %s\n", p.Try)
+			}
+		}
+	}
+	fmt.Fprintln(t.out)
+	if hidden {
+		fmt.Fprintln(t.out, "Secrets are redacted; run with --unsafe-log to see them.")
+	}
+	fmt.Fprintln(t.out, "Press Enter to send, or change a value with 'set <name> <value>'; 'help' lists the commands.")
+}
+
+// Explain tells how the provider or the local validators reacted to a
+// failed flow, with the changes that may have caused it
+func (t *Tutor) Explain(err error) {
+	if err == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reaction := reaction(err)
+	if reaction == "" && len(t.changes) == 0 {
+		return
+	}
+	fmt.Fprintln(t.out, "\n[tutor] Why the flow failed:")
+	if reaction != "" {
+		fmt.Fprintf(t.out, "  %s\n", reaction)
+	}
+	if len(t.changes) == 0 {
+		return
+	}
+	fmt.Fprintln(t.out, "  Your changes:")
+	for _, c := range t.changes {
+		if c.removed {
+			fmt.Fprintf(t.out, "  - %s: removed %s\n", stripQuery(c.request), c.name)
+			continue
+		}
+		fmt.Fprintf(t.out, "  - %s: %s from %q to %q\n", stripQuery(c.request), c.name, redact(c.name, c.from), redact(c.name, c.to))
+	}
+}
+
+// reaction explains which check rejected the flow
+func reaction(err error) string {
+	var providerErr *auth.ProviderError
+	var oauthErr *server.OAuthError
+	switch {
+	case errors.Is(err, server.ErrStateMismatch):
+		return "The callback server rejected the redirect: its state isn't the one this program sent. " +
+			"This is the CSRF protection of RFC 6749, section 10.12."
+	case errors.Is(err, server.ErrNoCode):
+		return "The redirect carried neither a code nor an error."
+	case errors.As(err, &oauthErr):
+		return fmt.Sprintf("The provider redirected back with the error %s. %s", oauthErr.Code, reactions[oauthErr.Code])
+	case errors.As(err, &providerErr) && providerErr.Code != "":
+		return fmt.Sprintf("The provider answered %s. %s", providerErr.Code, reactions[providerErr.Code])
+	case errors.As(err, &providerErr):
+		return fmt.Sprintf("The provider answered with status %d.", providerErr.StatusCode)
+	}
+	return ""
+}
+
+// readLine waits for a line of input. It returns false, and stops further
+// pauses, when the input ends or the learner interrupts.
+func (t *Tutor) readLine() (string, bool) {
+	t.start.Do(func() {
+		t.lines = make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(t.in)
+			for scanner.Scan() {
+				t.lines <- scanner.Text()
+			}
+			close(t.lines)
+		}()
+	})
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	select {
+	case line, ok := <-t.lines:
+		if ok {
+			return line, true
+		}
+		fmt.Fprintln(t.out, "\n[tutor] No more input; continuing without pauses")
+	case <-interrupt:
+		fmt.Fprintln(t.out)
+	}
+	t.stopped = true
+	return "", false
+}
+
+// redact redacts a parameter value like the logs do, recognizing it by its
+// name
+func redact(name, value string) string {
+	return strings.TrimPrefix(logger.Redact(name+"="+value), name+"=")
+}
+
+// stripQuery drops the query of the URL in a request line, since its
+// parameters are shown separately
+func stripQuery(request string) string {
+	if i := strings.Index(request, "?"); i >= 0 {
+		return request[:i]
+	}
+	return request
+}
@@ -0,0 +1,93 @@
+package tutor
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/korjavin/oauth2example/internal/auth"
+	"github.com/korjavin/oauth2example/internal/server"
+)
+
+func TestReview(t *testing.T) {
+	var out bytes.Buffer
+	tutor := New(strings.NewReader("help\nset state forged state\nunset prompt\n\n"), &out)
+
+	params := url.Values{"state": {"original"}, "prompt": {"login"}, "client_id": {"client-123"}}
+	tutor.Review("GET", "https://idp.example.com/auth?x=1", params)
+
+	if got := params.Get("state"); got != "forged state" {
+		t.Errorf("State is incorrect: got %q, want %q", got, "forged state")
+	}
+	if _, ok := params["prompt"]; ok {
+		t.Error("Prompt was not removed")
+	}
+	if !strings.Contains(out.String(), "About to send GET https://idp.example.com/auth with:") ||
+		!strings.Contains(out.String(), params["client_id"][0]) ||
+		!strings.Contains(out.String(), "Commands:") {
+		t.Errorf("Review output is incorrect:\n%s", out.String())
+	}
+	if len(tutor.changes) != 2 || tutor.changes[0].from != "original" || !tutor.changes[1].removed {
+		t.Errorf("Changes are incorrect: %+v", tutor.changes)
+	}
+}
+
+func TestRedactedValues(t *testing.T) {
+	var out bytes.Buffer
+	tutor := New(strings.NewReader("\n"), &out)
+
+	tutor.Review("POST", "https://idp.example.com/token", url.Values{"code": {"code-4711"}})
+	if strings.Contains(out.String(), "code-4711") || !strings.Contains(out.String(), "--unsafe-log") {
+		t.Errorf("Code is not redacted:\n%s", out.String())
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+	var out bytes.Buffer
+	tutor := New(strings.NewReader("\n\n"), &out)
+
+	tutor.Step(1, "Generate Authorization URL", "Creating the URL")
+	tutor.Step(2, "Generate PKCE Code Verifier and Challenge", "Creating the verifier")
+	if tutor.stopped {
+		t.Fatal("Tutor stopped before the input ended")
+	}
+
+	// Without input, the flow runs on instead of waiting forever
+	tutor.Step(3, "Start Server", "Starting the server")
+	params := url.Values{"state": {"original"}}
+	tutor.Review("GET", "https://idp.example.com/auth", params)
+	if !tutor.stopped || params.Get("state") != "original" {
+		t.Errorf("Tutor did not stop at the end of the input:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "About to send") {
+		t.Errorf("Tutor reviewed a request after the input ended:\n%s", out.String())
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"state mismatch", fmt.Errorf("callback: %w", server.ErrStateMismatch), "CSRF protection"},
+		{"redirect error", &server.OAuthError{Code: "access_denied"}, "declined the request"},
+		{"token error", &auth.ProviderError{StatusCode: 400, Code: "invalid_grant"}, "The provider answered invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tutor := New(strings.NewReader("set state forged\n\n"), &out)
+			tutor.Review("GET", "https://idp.example.com/auth", url.Values{"state": {"original"}})
+			out.Reset()
+
+			tutor.Explain(tt.err)
+			if !strings.Contains(out.String(), tt.want) || !strings.Contains(out.String(), `state from "original" to "forged"`) {
+				t.Errorf("Explanation is incorrect:\n%s", out.String())
+			}
+		})
+	}
+}
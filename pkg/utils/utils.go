@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
@@ -61,20 +62,41 @@ func GetEnvBool(key string, defaultValue bool) bool {
 	return value == "1" || value == "true" || value == "yes" || value == "y"
 }
 
-// PrintEnvHelp prints help information about required environment variables
-func PrintEnvHelp() {
-	fmt.Println("Required Environment Variables:")
-	fmt.Println("  GOOGLE_CLIENT_ID     - OAuth2 client ID from Google")
-	fmt.Println("  GOOGLE_CLIENT_SECRET - OAuth2 client secret from Google")
-	fmt.Println("")
-	fmt.Println("Optional Environment Variables:")
-	fmt.Println("  REDIRECT_URI - Callback URL (default: http://localhost:8080/oauth/callback)")
-	fmt.Println("  DEBUG        - Enable/disable detailed logs (default: true)")
-	fmt.Println("")
-	fmt.Println("Example:")
-	fmt.Println("  export GOOGLE_CLIENT_ID=your-client-id")
-	fmt.Println("  export GOOGLE_CLIENT_SECRET=your-client-secret")
-	fmt.Println("  ./oauth2cli")
+// EnvVar describes an environment variable for PrintEnvHelp
+type EnvVar struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// PrintEnvHelp prints help information about the given environment variables to w
+func PrintEnvHelp(w io.Writer, vars []EnvVar) {
+	// Align the descriptions after the longest name
+	width := 0
+	for _, v := range vars {
+		if len(v.Name) > width {
+			width = len(v.Name)
+		}
+	}
+
+	printVars := func(required bool) {
+		for _, v := range vars {
+			if v.Required == required {
+				fmt.Fprintf(w, "  %-*s - %s\n", width, v.Name, v.Description)
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "Required Environment Variables:")
+	printVars(true)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Optional Environment Variables:")
+	printVars(false)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Example:")
+	fmt.Fprintln(w, "  export GOOGLE_CLIENT_ID=your-client-id")
+	fmt.Fprintln(w, "  export GOOGLE_CLIENT_SECRET=your-client-secret")
+	fmt.Fprintln(w, "  ./oauth2cli login")
 }
 
 // FormatCodeBlock formats a string as a code block for display